@@ -11,6 +11,8 @@ import (
 )
 
 func (d *db) PutAttachment(ctx context.Context, docID, rev string, att *driver.Attachment, options map[string]interface{}) (newRev string, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.PutAttachment", docID)
+	defer endSpan(span, &err)
 	if docID == "" {
 		return "", missingArg("docID")
 	}
@@ -31,6 +33,10 @@ func (d *db) PutAttachment(ctx context.Context, docID, rev string, att *driver.A
 	if err != nil {
 		return "", err
 	}
+	gzip, gzipThreshold, err := gzipRequest(options)
+	if err != nil {
+		return "", err
+	}
 
 	query, err := optionsToParams(options)
 	if err != nil {
@@ -43,10 +49,12 @@ func (d *db) PutAttachment(ctx context.Context, docID, rev string, att *driver.A
 		Rev string `json:"rev"`
 	}
 	opts := &chttp.Options{
-		Body:        att.Content,
-		ContentType: att.ContentType,
-		FullCommit:  fullCommit,
-		Query:       query,
+		Body:          att.Content,
+		ContentType:   att.ContentType,
+		FullCommit:    fullCommit,
+		Query:         query,
+		Gzip:          gzip,
+		GzipThreshold: gzipThreshold,
 	}
 	_, err = d.Client.DoJSON(ctx, http.MethodPut, d.path(chttp.EncodeDocID(docID)+"/"+att.Filename), opts, &response)
 	if err != nil {
@@ -55,7 +63,9 @@ func (d *db) PutAttachment(ctx context.Context, docID, rev string, att *driver.A
 	return response.Rev, nil
 }
 
-func (d *db) GetAttachmentMeta(ctx context.Context, docID, filename string, options map[string]interface{}) (*driver.Attachment, error) {
+func (d *db) GetAttachmentMeta(ctx context.Context, docID, filename string, options map[string]interface{}) (_ *driver.Attachment, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.GetAttachmentMeta", docID)
+	defer endSpan(span, &err)
 	resp, err := d.fetchAttachment(ctx, http.MethodHead, docID, filename, options)
 	if err != nil {
 		return nil, err
@@ -64,11 +74,14 @@ func (d *db) GetAttachmentMeta(ctx context.Context, docID, filename string, opti
 	return att, err
 }
 
-func (d *db) GetAttachment(ctx context.Context, docID, filename string, options map[string]interface{}) (*driver.Attachment, error) {
+func (d *db) GetAttachment(ctx context.Context, docID, filename string, options map[string]interface{}) (_ *driver.Attachment, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.GetAttachment", docID)
+	defer endSpanOnError(span, &err)
 	resp, err := d.fetchAttachment(ctx, http.MethodGet, docID, filename, options)
 	if err != nil {
 		return nil, err
 	}
+	resp.Body = endSpanOnClose(span, resp.Body)
 	return decodeAttachment(resp)
 }
 
@@ -138,6 +151,8 @@ func getDigest(resp *http.Response) (string, error) {
 }
 
 func (d *db) DeleteAttachment(ctx context.Context, docID, rev, filename string, options map[string]interface{}) (newRev string, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.DeleteAttachment", docID)
+	defer endSpan(span, &err)
 	if docID == "" {
 		return "", missingArg("docID")
 	}
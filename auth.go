@@ -2,6 +2,7 @@ package couchdb
 
 import (
 	"context"
+	"crypto"
 	"errors"
 	"net/http"
 
@@ -63,20 +64,22 @@ func (a authFunc) auth(ctx context.Context, c *client) error {
 	return a(ctx, c)
 }
 
+// setAuth returns an Authenticator which installs a with chttp.Client.SetAuth,
+// replacing any authenticator set previously.
+func setAuth(a chttp.Authenticator) Authenticator {
+	return authFunc(func(_ context.Context, c *client) error {
+		return c.Client.SetAuth(a)
+	})
+}
+
 // BasicAuth provides support for HTTP Basic authentication.
 func BasicAuth(user, password string) Authenticator {
-	auth := chttp.BasicAuth{Username: user, Password: password}
-	return authFunc(func(ctx context.Context, c *client) error {
-		return auth.Authenticate(c.Client)
-	})
+	return setAuth(&chttp.BasicAuth{Username: user, Password: password})
 }
 
 // CookieAuth provides support for CouchDB cookie-based authentication.
 func CookieAuth(user, password string) Authenticator {
-	auth := chttp.CookieAuth{Username: user, Password: password}
-	return authFunc(func(ctx context.Context, c *client) error {
-		return auth.Authenticate(c.Client)
-	})
+	return setAuth(&chttp.CookieAuth{Username: user, Password: password})
 }
 
 // ProxyAuth provides support for Proxy authentication.
@@ -94,16 +97,35 @@ func CookieAuth(user, password string) Authenticator {
 //
 // See https://docs.couchdb.org/en/stable/api/server/authn.html?highlight=proxy%20auth#proxy-authentication
 func ProxyAuth(user, secret string, roles []string, headers ...map[string]string) Authenticator {
+	return ProxyAuthHash(crypto.SHA1, user, secret, roles, headers...)
+}
+
+// ProxyAuthHash works like ProxyAuth, but generates the X-Auth-CouchDB-Token
+// header using the specified hash algorithm, which must match the server's
+// `chttpd_auth/hash_algorithms` setting. crypto.SHA1, crypto.SHA256,
+// crypto.SHA384 and crypto.SHA512 are supported.
+func ProxyAuthHash(hash crypto.Hash, user, secret string, roles []string, headers ...map[string]string) Authenticator {
 	headerOverrides := http.Header{}
 	for _, h := range headers {
 		for k, v := range h {
 			headerOverrides.Set(k, v)
 		}
 	}
-	auth := chttp.ProxyAuth{Username: user, Secret: secret, Roles: roles, Headers: headerOverrides}
-	return authFunc(func(ctx context.Context, c *client) error {
-		return auth.Authenticate(c.Client)
-	})
+	return setAuth(&chttp.ProxyAuth{Username: user, Secret: secret, Roles: roles, Headers: headerOverrides, Hash: hash})
+}
+
+// JWTAuth provides support for CouchDB JWT authentication, using a static
+// token. See https://docs.couchdb.org/en/stable/api/server/authn.html#jwt-authentication
+func JWTAuth(token string) Authenticator {
+	return setAuth(&chttp.JWTAuth{Token: token})
+}
+
+// JWTAuthSource provides support for CouchDB JWT authentication, using tokens
+// obtained from source. Tokens are cached, and refreshed shortly before they
+// expire. chttp.HMACTokenSource and chttp.RSATokenSource may be used to sign
+// tokens locally.
+func JWTAuthSource(source chttp.TokenSource) Authenticator {
+	return setAuth(&chttp.JWTAuth{Source: source})
 }
 
 type rawCookie struct {
@@ -132,7 +154,8 @@ func (a *rawCookie) RoundTrip(r *http.Request) (*http.Response, error) {
 }
 
 // SetCookie adds cookie to all outbound requests. This is useful when using
-// kivik as a proxy.
+// kivik as a proxy. To send the credentials of a different user with each
+// request, see chttp.WithSessionCookie and related functions.
 func SetCookie(cookie *http.Cookie) Authenticator {
 	return &rawCookie{cookie: cookie}
 }
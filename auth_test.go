@@ -2,11 +2,13 @@ package couchdb
 
 import (
 	"context"
+	"crypto"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"gitlab.com/flimzy/testy"
 
@@ -162,6 +164,44 @@ func TestAuthentication(t *testing.T) {
 		},
 		auther: ProxyAuth("bob", "abc123", []string{"users", "admins"}, map[string]string{"X-Auth-CouchDB-Token": "moo"}), // nolint: misspell
 	})
+	tests.Add("ProxyAuthHash", tst{
+		handler: func(t *testing.T) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if h := r.Header.Get("X-Auth-CouchDB-Token"); h != "14abffc9d8d7a47f312f51651d30fde4bb4a1a4a747a9c1e0db75936da2e501a" {
+					t.Errorf("Unexpected X-Auth-CouchDB-Token header: %s", h)
+				}
+				w.WriteHeader(200)
+				_, _ = w.Write([]byte(`{}`))
+			})
+		},
+		auther: ProxyAuthHash(crypto.SHA256, "bob", "abc123", []string{"users"}), // nolint: misspell
+	})
+	tests.Add("JWTAuth", tst{
+		handler: func(t *testing.T) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if h := r.Header.Get("Authorization"); h != "Bearer abc.def.ghi" {
+					t.Errorf("Unexpected Authorization header: %s", h)
+				}
+				w.WriteHeader(200)
+				_, _ = w.Write([]byte(`{}`))
+			})
+		},
+		auther: JWTAuth("abc.def.ghi"), // nolint: misspell
+	})
+	tests.Add("JWTAuthSource", tst{
+		handler: func(t *testing.T) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if h := r.Header.Get("Authorization"); h != "Bearer xyz" {
+					t.Errorf("Unexpected Authorization header: %s", h)
+				}
+				w.WriteHeader(200)
+				_, _ = w.Write([]byte(`{}`))
+			})
+		},
+		auther: JWTAuthSource(func(_ context.Context) (string, time.Time, error) { // nolint: misspell
+			return "xyz", time.Time{}, nil
+		}),
+	})
 	tests.Add("SetCookie", tst{
 		handler: func(t *testing.T) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -218,3 +258,37 @@ func TestAuthentication(t *testing.T) {
 		testy.StatusErrorRE(t, test.err, test.status, err)
 	})
 }
+
+func TestAuthenticationReplaced(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_session" {
+			http.SetCookie(w, &http.Cookie{Name: kivik.SessionCookieName, Value: "auth-token"})
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		if h := r.Header.Get("Authorization"); h != "" {
+			t.Errorf("Unexpected Authorization header: %s", h)
+		}
+		if _, err := r.Cookie(kivik.SessionCookieName); err != nil {
+			t.Errorf("Expected session cookie: %s", err)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer s.Close()
+	driverClient, err := (&Couch{}).NewClient(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := driverClient.(*client)
+	if err := c.Authenticate(context.Background(), BasicAuth("bob", "abc123")); err != nil { // nolint: misspell
+		t.Fatal(err)
+	}
+	if err := c.Authenticate(context.Background(), CookieAuth("bob", "abc123")); err != nil { // nolint: misspell
+		t.Fatal(err)
+	}
+	if _, err := c.Version(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,7 +3,6 @@ package couchdb
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"io"
 	"net/http"
 
@@ -38,36 +37,53 @@ func (r *bulkResults) Next(update *driver.BulkResult) error {
 		}
 		return io.EOF
 	}
-	var updateResult struct {
-		ID     string `json:"id"`
-		Rev    string `json:"rev"`
-		Error  string `json:"error"`
-		Reason string `json:"reason"`
-	}
+	var updateResult BulkDocsError
 	if err := r.dec.Decode(&updateResult); err != nil {
 		return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
 	}
 	update.ID = updateResult.ID
 	update.Rev = updateResult.Rev
 	update.Error = nil
-	if updateResult.Error != "" {
-		var status int
-		switch updateResult.Error {
-		case "conflict":
-			status = http.StatusConflict
-		default:
-			status = http.StatusInternalServerError
-		}
-		update.Error = &kivik.Error{HTTPStatus: status, FromServer: true, Err: errors.New(updateResult.Reason)}
+	if updateResult.Err != "" {
+		update.Error = &kivik.Error{HTTPStatus: updateResult.StatusCode(), FromServer: true, Err: &updateResult}
 	}
 	return nil
 }
 
+// BulkDocsError represents an error for a single document returned by a
+// BulkDocs call. Its status code, as reported by kivik.StatusCode, is derived
+// from the CouchDB error name, so that for instance a rejection by a
+// validate_doc_update function (forbidden, 403) can be told apart from a
+// server failure (500). BulkDocs results wrap it in a *kivik.Error, from which
+// it may be retrieved with xerrors.As.
+type BulkDocsError struct {
+	ID     string `json:"id"`
+	Rev    string `json:"rev"`
+	Err    string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+var _ error = &BulkDocsError{}
+
+func (e *BulkDocsError) Error() string {
+	if e.Reason == "" {
+		return e.Err
+	}
+	return e.Reason + " (" + e.Err + ")"
+}
+
+// StatusCode returns the HTTP status code corresponding to the error.
+func (e *BulkDocsError) StatusCode() int {
+	return errorStatus(e.Err)
+}
+
 func (r *bulkResults) Close() error {
 	return r.body.Close()
 }
 
-func (d *db) BulkDocs(ctx context.Context, docs []interface{}, options map[string]interface{}) (driver.BulkResults, error) {
+func (d *db) BulkDocs(ctx context.Context, docs []interface{}, options map[string]interface{}) (_ driver.BulkResults, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.BulkDocs", "")
+	defer endSpanOnError(span, &err)
 	if options == nil {
 		options = make(map[string]interface{})
 	}
@@ -75,10 +91,16 @@ func (d *db) BulkDocs(ctx context.Context, docs []interface{}, options map[strin
 	if err != nil {
 		return nil, err
 	}
+	gzip, gzipThreshold, err := gzipRequest(options)
+	if err != nil {
+		return nil, err
+	}
 	options["docs"] = docs
 	opts := &chttp.Options{
-		GetBody:    chttp.BodyEncoder(options),
-		FullCommit: fullCommit,
+		GetBody:       chttp.BodyEncoder(options),
+		FullCommit:    fullCommit,
+		Gzip:          gzip,
+		GzipThreshold: gzipThreshold,
 	}
 	resp, err := d.Client.DoReq(ctx, http.MethodPost, d.path("_bulk_docs"), opts)
 	if err != nil {
@@ -98,7 +120,12 @@ func (d *db) BulkDocs(ctx context.Context, docs []interface{}, options map[strin
 			return nil, e
 		}
 	}
-	results, bulkErr := newBulkResults(resp.Body)
+	body := resp.Body
+	if err == nil {
+		// Otherwise, the span is ended with err when BulkDocs returns.
+		body = endSpanOnClose(span, body)
+	}
+	results, bulkErr := newBulkResults(body)
 	if bulkErr != nil {
 		return nil, bulkErr
 	}
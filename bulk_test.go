@@ -1,6 +1,7 @@
 package couchdb
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -127,6 +128,39 @@ func TestBulkDocs(t *testing.T) {
 			status:  http.StatusBadRequest,
 			err:     "kivik: option 'X-Couch-Full-Commit' must be bool, not int",
 		},
+		{
+			name:    "gzip request",
+			options: map[string]interface{}{OptionGzipRequest: 10},
+			docs:    []interface{}{map[string]string{"foo": "bar"}},
+			db: newCustomDB(func(req *http.Request) (*http.Response, error) {
+				defer req.Body.Close() // nolint: errcheck
+				if value := req.Header.Get("Content-Encoding"); value != "gzip" {
+					return nil, errors.New("Content-Encoding not set to gzip")
+				}
+				gz, err := gzip.NewReader(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				var body map[string]interface{}
+				if err := json.NewDecoder(gz).Decode(&body); err != nil {
+					return nil, err
+				}
+				if _, ok := body[OptionGzipRequest]; ok {
+					return nil, errors.New("gzip request key found in body")
+				}
+				return &http.Response{
+					StatusCode: http.StatusCreated,
+					Body:       ioutil.NopCloser(strings.NewReader("[]")),
+				}, nil
+			}),
+		},
+		{
+			name:    "invalid gzip request type",
+			db:      &db{},
+			options: map[string]interface{}{OptionGzipRequest: "yes"},
+			status:  http.StatusBadRequest,
+			err:     "kivik: option 'kivik:gzip-request' must be bool or int, not string",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -205,7 +239,7 @@ func TestBulkNext(t *testing.T) {
 			}(),
 			expected: &driver.BulkResult{
 				ID:    "foo",
-				Error: &kivik.Error{HTTPStatus: http.StatusConflict, FromServer: true, Err: errors.New("annoying conflict")},
+				Error: &kivik.Error{HTTPStatus: http.StatusConflict, FromServer: true, Err: &BulkDocsError{ID: "foo", Err: "conflict", Reason: "annoying conflict"}},
 			},
 		},
 		{
@@ -219,7 +253,21 @@ func TestBulkNext(t *testing.T) {
 			}(),
 			expected: &driver.BulkResult{
 				ID:    "foo",
-				Error: &kivik.Error{HTTPStatus: http.StatusInternalServerError, FromServer: true, Err: errors.New("foo is erroneous")},
+				Error: &kivik.Error{HTTPStatus: http.StatusInternalServerError, FromServer: true, Err: &BulkDocsError{ID: "foo", Err: "foo", Reason: "foo is erroneous"}},
+			},
+		},
+		{
+			name: "validation rejection",
+			results: func() *bulkResults {
+				r, err := newBulkResults(Body(`[{"id":"foo","error":"forbidden","reason":"only admins may write"}]`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return r
+			}(),
+			expected: &driver.BulkResult{
+				ID:    "foo",
+				Error: &kivik.Error{HTTPStatus: http.StatusForbidden, FromServer: true, Err: &BulkDocsError{ID: "foo", Err: "forbidden", Reason: "only admins may write"}},
 			},
 		},
 		{
@@ -272,3 +320,35 @@ func TestBulkClose(t *testing.T) {
 		t.Errorf("Failed to close")
 	}
 }
+
+func TestBulkErrorStatus(t *testing.T) {
+	tests := map[string]int{
+		"conflict":             http.StatusConflict,
+		"forbidden":            http.StatusForbidden,
+		"unauthorized":         http.StatusUnauthorized,
+		"not_found":            http.StatusNotFound,
+		"bad_request":          http.StatusBadRequest,
+		"illegal_docid":        http.StatusBadRequest,
+		"too_large":            http.StatusRequestEntityTooLarge,
+		"document_too_large":   http.StatusRequestEntityTooLarge,
+		"attachment_too_large": http.StatusRequestEntityTooLarge,
+		"missing_stub":         http.StatusPreconditionFailed,
+		"unknown_error":        http.StatusInternalServerError,
+		"foo":                  http.StatusInternalServerError,
+	}
+	for name, status := range tests {
+		t.Run(name, func(t *testing.T) {
+			var err error = &BulkDocsError{ID: "foo", Err: name, Reason: "reason"}
+			if got := kivik.StatusCode(err); got != status {
+				t.Errorf("BulkDocsError: expected %d, got %d", status, got)
+			}
+			if msg := err.Error(); msg != "reason ("+name+")" {
+				t.Errorf("BulkDocsError: unexpected message %q", msg)
+			}
+			err = &BulkGetError{ID: "foo", Err: name, Reason: "reason"}
+			if got := kivik.StatusCode(err); got != status {
+				t.Errorf("BulkGetError: expected %d, got %d", status, got)
+			}
+		})
+	}
+}
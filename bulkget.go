@@ -10,7 +10,9 @@ import (
 	"github.com/go-kivik/kivik/v4/driver"
 )
 
-func (d *db) BulkGet(ctx context.Context, docs []driver.BulkGetReference, opts map[string]interface{}) (driver.Rows, error) {
+func (d *db) BulkGet(ctx context.Context, docs []driver.BulkGetReference, opts map[string]interface{}) (_ driver.Rows, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.BulkGet", "")
+	defer endSpanOnError(span, &err)
 	query, err := optionsToParams(opts)
 	if err != nil {
 		return nil, err
@@ -29,11 +31,12 @@ func (d *db) BulkGet(ctx context.Context, docs []driver.BulkGetReference, opts m
 	if err = chttp.ResponseError(resp); err != nil {
 		return nil, err
 	}
-	return newBulkGetRows(ctx, resp.Body), nil
+	return newBulkGetRows(ctx, endSpanOnClose(span, resp.Body)), nil
 }
 
 // BulkGetError represents an error for a single document returned by a
-// GetBulk call.
+// GetBulk call. Its status code, as reported by kivik.StatusCode, is derived
+// from the CouchDB error name.
 type BulkGetError struct {
 	ID     string `json:"id"`
 	Rev    string `json:"rev"`
@@ -47,6 +50,11 @@ func (e *BulkGetError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Err, e.Reason)
 }
 
+// StatusCode returns the HTTP status code corresponding to the error.
+func (e *BulkGetError) StatusCode() int {
+	return errorStatus(e.Err)
+}
+
 type bulkResultDoc struct {
 	Doc   json.RawMessage `json:"ok,omitempty"`
 	Error *BulkGetError   `json:"error,omitempty"`
@@ -0,0 +1,368 @@
+// Package bulkwriter provides a BulkWriter, which writes an unbounded stream
+// of documents to a CouchDB database in batches, with the _bulk_docs
+// endpoint.
+//
+// Example:
+//
+//     w := &bulkwriter.BulkWriter{
+//         DB:          db,
+//         Parallelism: 4,
+//         OnResult: func(r bulkwriter.Result) {
+//             if r.Err != nil {
+//                 log.Printf("%s: %s", r.ID, r.Err)
+//             }
+//         },
+//     }
+//     for _, doc := range docs {
+//         if err := w.Write(ctx, doc); err != nil {
+//             return err
+//         }
+//     }
+//     return w.Close(ctx)
+//
+// Write blocks while the writer's buffer is full, so that a fast producer is
+// held back by the rate at which the database accepts documents.
+package bulkwriter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// Default values used by a BulkWriter when the corresponding field is unset.
+const (
+	DefaultBatchSize     = 1000
+	DefaultBatchBytes    = 4 << 20
+	DefaultFlushInterval = time.Second
+	DefaultParallelism   = 1
+	DefaultMaxAttempts   = 3
+)
+
+// ErrClosed is returned by Write after Close has been called.
+var ErrClosed = errors.New("bulkwriter: writer closed")
+
+// Result is the outcome of writing a single document.
+type Result struct {
+	// ID and Rev are the document ID and new revision. ID is assigned by the
+	// server for documents without an _id.
+	ID  string
+	Rev string
+
+	// Err is the error for this document, such as a conflict, or the error
+	// which failed the whole batch.
+	Err error
+
+	// Doc is the document, as passed to Write.
+	Doc interface{}
+}
+
+// BulkWriter batches documents, by count and size, and writes them with
+// _bulk_docs. The zero value is not usable; DB must be set. A BulkWriter
+// must not be copied after first use.
+//
+// Batches which fail with a transient error (429, 502, 503 or 504, which
+// includes network failures) are retried. As _bulk_docs is not idempotent, a
+// retried batch may report conflicts for documents written by an attempt
+// which appeared to fail, and documents without an _id may be written twice.
+type BulkWriter struct {
+	// DB is the database to write to.
+	DB *kivik.DB
+
+	// BatchSize is the maximum number of documents in a batch. Defaults to
+	// DefaultBatchSize.
+	BatchSize int
+
+	// BatchBytes is the approximate maximum size of a batch, in bytes of
+	// JSON. A document larger than BatchBytes is sent in a batch of its own.
+	// Defaults to DefaultBatchBytes.
+	BatchBytes int
+
+	// FlushInterval is the maximum time a document waits for its batch to
+	// fill up. Defaults to DefaultFlushInterval.
+	FlushInterval time.Duration
+
+	// Parallelism is the number of batches sent concurrently. Defaults to
+	// DefaultParallelism.
+	Parallelism int
+
+	// MaxAttempts is the number of times a batch is sent before a transient
+	// failure is reported. MinDelay is the delay before the first retry,
+	// doubling with each subsequent retry up to MaxDelay. They default to
+	// DefaultMaxAttempts, chttp.DefaultMinDelay and chttp.DefaultMaxDelay
+	// respectively.
+	MaxAttempts int
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+
+	// Options are passed to each BulkDocs call, such as new_edits.
+	Options kivik.Options
+
+	// OnResult, if set, is called with the result of each document. It may be
+	// called concurrently from several goroutines, when Parallelism is
+	// greater than 1. Results of a batch are reported in order, but batches
+	// may complete in any order.
+	OnResult func(Result)
+
+	// Results, if set, receives the result of each document, and is closed
+	// by Close. The writer blocks until each result is received, or Close
+	// gives up, in which case undelivered results are dropped.
+	Results chan<- Result
+
+	once    sync.Once
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	writers sync.WaitGroup
+	docs    chan *pending
+	batches chan []*pending
+	ctx     context.Context
+	cancel  func()
+	done    chan struct{}
+}
+
+// pending is a document waiting to be written.
+type pending struct {
+	doc  interface{}
+	json json.RawMessage
+}
+
+func (w *BulkWriter) batchSize() int {
+	if w.BatchSize > 0 {
+		return w.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+func (w *BulkWriter) batchBytes() int {
+	if w.BatchBytes > 0 {
+		return w.BatchBytes
+	}
+	return DefaultBatchBytes
+}
+
+func (w *BulkWriter) flushInterval() time.Duration {
+	if w.FlushInterval > 0 {
+		return w.FlushInterval
+	}
+	return DefaultFlushInterval
+}
+
+func (w *BulkWriter) parallelism() int {
+	if w.Parallelism > 0 {
+		return w.Parallelism
+	}
+	return DefaultParallelism
+}
+
+func (w *BulkWriter) maxAttempts() int {
+	if w.MaxAttempts > 0 {
+		return w.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (w *BulkWriter) start() {
+	w.once.Do(func() {
+		w.docs = make(chan *pending, w.batchSize())
+		w.closing = make(chan struct{})
+		w.batches = make(chan []*pending)
+		w.ctx, w.cancel = context.WithCancel(context.Background())
+		w.done = make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < w.parallelism(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for batch := range w.batches {
+					w.send(batch)
+				}
+			}()
+		}
+		go func() {
+			w.batch()
+			close(w.batches)
+			wg.Wait()
+			w.cancel()
+			if w.Results != nil {
+				close(w.Results)
+			}
+			close(w.done)
+		}()
+	})
+}
+
+// Write queues doc to be written. It blocks while the writer's buffer is
+// full, until ctx is done or the writer is closed. An error is returned only
+// if doc cannot be marshaled to JSON, or the writer is closed; the outcome of
+// the write is reported as a Result.
+func (w *BulkWriter) Write(ctx context.Context, doc interface{}) error {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	w.start()
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return ErrClosed
+	}
+	w.writers.Add(1)
+	w.mu.RUnlock()
+	defer w.writers.Done()
+	select {
+	case w.docs <- &pending{doc: doc, json: raw}:
+		return nil
+	case <-w.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close writes any queued documents, and waits for all results to be
+// reported. Writes blocked on a full buffer return ErrClosed. If ctx is done
+// first, outstanding requests are cancelled, and ctx's error is returned.
+func (w *BulkWriter) Close(ctx context.Context) error {
+	w.start()
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.closing)
+		// Blocked writers return promptly once closing is closed, so the
+		// docs channel is only closed once nothing can send on it.
+		w.writers.Wait()
+		close(w.docs)
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		return ctx.Err()
+	}
+}
+
+// batch collects queued documents into batches, until the writer is closed.
+func (w *BulkWriter) batch() {
+	var batch []*pending
+	size := 0
+	timer := time.NewTimer(w.flushInterval())
+	timer.Stop()
+	defer timer.Stop()
+	flush := func() {
+		timer.Stop()
+		if len(batch) > 0 {
+			w.batches <- batch
+		}
+		batch, size = nil, 0
+	}
+	for {
+		select {
+		case p, ok := <-w.docs:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) > 0 && size+len(p.json)+1 > w.batchBytes() {
+				flush()
+			}
+			if len(batch) == 0 {
+				timer.Reset(w.flushInterval())
+			}
+			batch = append(batch, p)
+			size += len(p.json) + 1
+			if len(batch) >= w.batchSize() || size >= w.batchBytes() {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// transient returns true if err may succeed if retried.
+func transient(err error) bool {
+	switch kivik.StatusCode(err) {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// send writes a batch, retrying transient failures, and reports the results.
+func (w *BulkWriter) send(batch []*pending) {
+	docs := make([]interface{}, len(batch))
+	for i, p := range batch {
+		docs[i] = p.json
+	}
+	results, err := w.bulkDocs(docs)
+	for attempt := 1; len(results) == 0 && transient(err) && attempt < w.maxAttempts(); attempt++ {
+		timer := time.NewTimer(chttp.Backoff(attempt, w.MinDelay, w.MaxDelay))
+		select {
+		case <-timer.C:
+			results, err = w.bulkDocs(docs)
+		case <-w.ctx.Done():
+			timer.Stop()
+			err = w.ctx.Err()
+		}
+	}
+	for i, p := range batch {
+		r := Result{Err: err, Doc: p.doc}
+		if i < len(results) {
+			r = results[i]
+			r.Doc = p.doc
+		}
+		if r.ID == "" {
+			r.ID = docID(p.json)
+		}
+		w.report(r)
+	}
+}
+
+// bulkDocs sends a single _bulk_docs request. If the response is cut short,
+// the results read so far are returned along with the error.
+func (w *BulkWriter) bulkDocs(docs []interface{}) ([]Result, error) {
+	opts := kivik.Options{}
+	for k, v := range w.Options {
+		opts[k] = v
+	}
+	bulk, err := w.DB.BulkDocs(w.ctx, docs, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer bulk.Close() // nolint: errcheck
+	results := make([]Result, 0, len(docs))
+	for bulk.Next() {
+		results = append(results, Result{ID: bulk.ID(), Rev: bulk.Rev(), Err: bulk.UpdateErr()})
+	}
+	return results, bulk.Err()
+}
+
+// docID returns the _id of a marshaled document, if it has one.
+func docID(raw json.RawMessage) string {
+	var doc struct {
+		ID string `json:"_id"`
+	}
+	_ = json.Unmarshal(raw, &doc)
+	return doc.ID
+}
+
+func (w *BulkWriter) report(r Result) {
+	if w.OnResult != nil {
+		w.OnResult(r)
+	}
+	if w.Results != nil {
+		select {
+		case w.Results <- r:
+		case <-w.ctx.Done():
+		}
+	}
+}
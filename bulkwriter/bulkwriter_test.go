@@ -0,0 +1,348 @@
+package bulkwriter_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/couchdb/v4/bulkwriter"
+	"github.com/go-kivik/couchdb/v4/couchdbtest"
+	"github.com/go-kivik/couchdb/v4/faultinject"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// counter counts the _bulk_docs requests it sends.
+type counter struct {
+	n int32
+}
+
+func (c *counter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/_bulk_docs") {
+		atomic.AddInt32(&c.n, 1)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (c *counter) requests() int {
+	return int(atomic.LoadInt32(&c.n))
+}
+
+// newDB returns a database on a fake server, accessed through faults, which
+// sends requests through the returned counter.
+func newDB(t *testing.T, s *couchdbtest.Server, faults ...*faultinject.Fault) (*kivik.DB, *counter) {
+	t.Helper()
+	c := &counter{}
+	return s.NewDB(t, "testdb", &faultinject.Transport{Transport: c, Faults: faults}), c
+}
+
+// collect records results.
+type collect struct {
+	mu      sync.Mutex
+	results map[string]bulkwriter.Result
+}
+
+func (c *collect) add(r bulkwriter.Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.results == nil {
+		c.results = make(map[string]bulkwriter.Result)
+	}
+	c.results[r.ID] = r
+}
+
+func (c *collect) failed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []string
+	for id, r := range c.results {
+		if r.Err != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func writeDocs(t *testing.T, w *bulkwriter.BulkWriter, n int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("doc%03d", i)
+		if err := w.Write(ctx, map[string]interface{}{"_id": id, "n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBulkWriter(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db, c := newDB(t, s)
+	results := &collect{}
+	w := &bulkwriter.BulkWriter{
+		DB:          db,
+		BatchSize:   10,
+		Parallelism: 3,
+		OnResult:    results.add,
+	}
+	writeDocs(t, w, 25)
+
+	if n := c.requests(); n != 3 {
+		t.Errorf("Expected 3 requests, got %d", n)
+	}
+	if n := len(results.results); n != 25 {
+		t.Errorf("Expected 25 results, got %d", n)
+	}
+	if failed := results.failed(); len(failed) > 0 {
+		t.Errorf("Unexpected failures: %v", failed)
+	}
+	r := results.results["doc007"]
+	if !strings.HasPrefix(r.Rev, "1-") {
+		t.Errorf("Unexpected rev: %s", r.Rev)
+	}
+	if d := testy.DiffInterface(map[string]interface{}{"_id": "doc007", "n": 7}, r.Doc); d != nil {
+		t.Error(d)
+	}
+	var doc struct {
+		N int `json:"n"`
+	}
+	if err := db.Get(context.Background(), "doc024").ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.N != 24 {
+		t.Errorf("Unexpected doc: %v", doc)
+	}
+}
+
+func TestBulkWriterBatchBytes(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db, c := newDB(t, s)
+	w := &bulkwriter.BulkWriter{
+		DB:         db,
+		BatchBytes: 50,
+	}
+	// Each document is 20 bytes of JSON, so two fit in a batch.
+	writeDocs(t, w, 5)
+	if n := c.requests(); n != 3 {
+		t.Errorf("Expected 3 requests, got %d", n)
+	}
+}
+
+func TestBulkWriterFlushInterval(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db, _ := newDB(t, s)
+	results := make(chan bulkwriter.Result)
+	w := &bulkwriter.BulkWriter{
+		DB:            db,
+		FlushInterval: 10 * time.Millisecond,
+		Results:       results,
+	}
+	defer w.Close(context.Background()) // nolint: errcheck
+	if err := w.Write(context.Background(), map[string]string{"_id": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-results:
+		if r.ID != "a" || r.Err != nil {
+			t.Errorf("Unexpected result: %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("partial batch was not flushed")
+	}
+}
+
+func TestBulkWriterConflict(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db, _ := newDB(t, s)
+	if _, err := db.Put(context.Background(), "a", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan bulkwriter.Result)
+	w := &bulkwriter.BulkWriter{DB: db, Results: results}
+	go func() {
+		for _, id := range []string{"a", "b"} {
+			if err := w.Write(context.Background(), map[string]string{"_id": id}); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := w.Close(context.Background()); err != nil {
+			t.Error(err)
+		}
+	}()
+	var got []string
+	for r := range results {
+		got = append(got, fmt.Sprintf("%s: %d", r.ID, kivik.StatusCode(r.Err)))
+	}
+	if d := testy.DiffInterface([]string{"a: 409", "b: 0"}, got); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestBulkWriterRetry(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	fault := &faultinject.Fault{
+		Kind:   faultinject.Status,
+		Status: http.StatusServiceUnavailable,
+		Path:   regexp.MustCompile(`/_bulk_docs$`),
+		Burst:  2,
+		Limit:  2,
+	}
+	db, _ := newDB(t, s, fault)
+	results := &collect{}
+	w := &bulkwriter.BulkWriter{
+		DB:       db,
+		MinDelay: time.Millisecond,
+		OnResult: results.add,
+	}
+	writeDocs(t, w, 3)
+	if n := fault.Injected(); n != 2 {
+		t.Errorf("Expected 2 injected faults, got %d", n)
+	}
+	if failed := results.failed(); len(failed) > 0 {
+		t.Errorf("Unexpected failures: %v", failed)
+	}
+}
+
+func TestBulkWriterGiveUp(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	fault := &faultinject.Fault{
+		Kind:   faultinject.Status,
+		Status: http.StatusServiceUnavailable,
+		Path:   regexp.MustCompile(`/_bulk_docs$`),
+	}
+	db, _ := newDB(t, s, fault)
+	results := &collect{}
+	w := &bulkwriter.BulkWriter{
+		DB:          db,
+		MaxAttempts: 2,
+		MinDelay:    time.Millisecond,
+		OnResult:    results.add,
+	}
+	writeDocs(t, w, 2)
+	if n := fault.Injected(); n != 2 {
+		t.Errorf("Expected 2 injected faults, got %d", n)
+	}
+	if d := testy.DiffInterface([]string{"doc000", "doc001"}, results.failed()); d != nil {
+		t.Error(d)
+	}
+	if status := kivik.StatusCode(results.results["doc000"].Err); status != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status: %d", status)
+	}
+}
+
+func TestBulkWriterBackpressure(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db, _ := newDB(t, s)
+	release := make(chan struct{})
+	w := &bulkwriter.BulkWriter{
+		DB:        db,
+		BatchSize: 1,
+		OnResult: func(bulkwriter.Result) {
+			<-release
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var err error
+	written := 0
+	for ; written < 100; written++ {
+		if err = w.Write(ctx, map[string]int{"n": written}); err != nil {
+			break
+		}
+	}
+	if err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if written > 5 {
+		t.Errorf("Expected Write to block, but %d documents were accepted", written)
+	}
+	close(release)
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(context.Background(), map[string]int{}); err != bulkwriter.ErrClosed {
+		t.Errorf("Unexpected error after Close: %v", err)
+	}
+}
+
+func TestBulkWriterCloseBlockedWrite(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db, _ := newDB(t, s)
+	release := make(chan struct{})
+	defer close(release)
+	w := &bulkwriter.BulkWriter{
+		DB:        db,
+		BatchSize: 1,
+		OnResult: func(bulkwriter.Result) {
+			<-release
+		},
+	}
+	writeErr := make(chan error, 1)
+	go func() {
+		for {
+			if err := w.Write(context.Background(), map[string]int{}); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+	}()
+	// Give the producer time to fill the buffer and block.
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := w.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected Close error: %v", err)
+	}
+	select {
+	case err := <-writeErr:
+		if err != bulkwriter.ErrClosed {
+			t.Errorf("Unexpected Write error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked Write did not return")
+	}
+}
+
+func TestBulkWriterUndrainedResults(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db, _ := newDB(t, s)
+	w := &bulkwriter.BulkWriter{
+		DB:      db,
+		Results: make(chan bulkwriter.Result),
+	}
+	if err := w.Write(context.Background(), map[string]string{"_id": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := w.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected Close error: %v", err)
+	}
+	// Once Close gives up, the workers stop waiting for Results to be read.
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Close(ctx); err != nil {
+		t.Errorf("Writer did not finish: %v", err)
+	}
+}
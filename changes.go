@@ -14,32 +14,98 @@ import (
 )
 
 // Changes returns the changes stream for the database.
-func (d *db) Changes(ctx context.Context, opts map[string]interface{}) (driver.Changes, error) {
+func (d *db) Changes(ctx context.Context, opts map[string]interface{}) (_ driver.Changes, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Changes", "")
+	defer endSpanOnError(span, &err)
 	key := "results"
-	if f, ok := opts["feed"]; ok {
-		if f == "eventsource" {
-			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: eventsource feed not supported, use 'continuous'")}
-		}
-		if f == "continuous" {
-			key = ""
-		}
+	feed := opts["feed"]
+	if feed == "continuous" || feed == "eventsource" {
+		key = ""
 	}
-	query, err := optionsToParams(opts)
+	policy, err := reconnectPolicy(opts)
 	if err != nil {
 		return nil, err
 	}
-	options := &chttp.Options{
-		Query: query,
+	body, err := changesBody(opts)
+	if err != nil {
+		return nil, err
 	}
-	resp, err := d.Client.DoReq(ctx, http.MethodGet, d.path("_changes"), options)
+	query, err := optionsToParams(opts)
 	if err != nil {
 		return nil, err
 	}
-	if err = chttp.ResponseError(resp); err != nil {
+	req := &feedRequest{
+		client:  d.Client,
+		method:  http.MethodGet,
+		path:    d.path("_changes"),
+		options: &chttp.Options{Query: query},
+	}
+	if body != nil {
+		req.method = http.MethodPost
+		req.options.GetBody = chttp.BodyEncoder(body)
+		req.options.Header = http.Header{
+			chttp.HeaderIdempotencyKey: []string{},
+		}
+	}
+	resp, err := openFeed(ctx, req, policy)
+	if err != nil {
 		return nil, err
 	}
 	etag, _ := chttp.ETag(resp)
-	return newChangesRows(ctx, key, resp.Body, etag), nil
+	rc := endSpanOnClose(span, resp.Body)
+	if feed == "eventsource" {
+		// Events are translated to the continuous feed format.
+		rc = newEventSourceReader(ctx, rc, func(ctx context.Context, lastEventID string) (io.ReadCloser, error) {
+			reopen := *req.options
+			if lastEventID != "" {
+				reopen.Header = http.Header{}
+				for k, v := range req.options.Header {
+					reopen.Header[k] = v
+				}
+				reopen.Header.Set(headerLastEventID, lastEventID)
+			}
+			resp, err := req.do(ctx, &reopen)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Body, nil
+		})
+	}
+	return newChangesRows(ctx, key, rc, etag), nil
+}
+
+// changesBody removes the doc_ids and selector options from opts, and returns
+// the request body to send them with, or nil if neither is set. The matching
+// filter is selected, unless one was given explicitly.
+func changesBody(opts map[string]interface{}) (map[string]interface{}, error) {
+	var body map[string]interface{}
+	for _, key := range []string{"doc_ids", "selector"} {
+		v, ok := opts[key]
+		if !ok {
+			continue
+		}
+		delete(opts, key)
+		if s, ok := v.(string); ok {
+			// Already JSON-encoded
+			v = json.RawMessage(s)
+		}
+		if body == nil {
+			body = map[string]interface{}{}
+		}
+		body[key] = v
+	}
+	if _, ok := opts["filter"]; ok || body == nil {
+		return body, nil
+	}
+	if len(body) > 1 {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: doc_ids and selector options require an explicit filter")}
+	}
+	if _, ok := body["doc_ids"]; ok {
+		opts["filter"] = "_doc_ids"
+	} else {
+		opts["filter"] = "_selector"
+	}
+	return body, nil
 }
 
 type continuousChangesParser struct{}
@@ -87,8 +153,9 @@ func newChangesRows(ctx context.Context, key string, r io.ReadCloser, etag strin
 		meta = &changesMeta{}
 	}
 	return &changesRows{
-		iter: newIter(ctx, meta, key, r, &continuousChangesParser{}),
-		etag: etag,
+		iter:        newIter(ctx, meta, key, r, &continuousChangesParser{}),
+		changesMeta: meta,
+		etag:        etag,
 	}
 }
 
@@ -106,11 +173,17 @@ func (r *changesRows) Next(row *driver.Change) error {
 
 // LastSeq returns the last sequence ID.
 func (r *changesRows) LastSeq() string {
+	if r.changesMeta == nil {
+		return ""
+	}
 	return string(r.lastSeq)
 }
 
 // Pending returns the pending count.
 func (r *changesRows) Pending() int64 {
+	if r.changesMeta == nil {
+		return 0
+	}
 	return r.pending
 }
 
@@ -3,6 +3,7 @@ package couchdb
 import (
 	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"testing"
@@ -10,6 +11,7 @@ import (
 
 	"gitlab.com/flimzy/testy"
 
+	"github.com/go-kivik/couchdb/v4/chttp"
 	"github.com/go-kivik/kivik/v4/driver"
 )
 
@@ -30,9 +32,10 @@ func TestChanges(t *testing.T) {
 		},
 		{
 			name:    "eventsource",
+			db:      newTestDB(nil, errors.New("net error")),
 			options: map[string]interface{}{"feed": "eventsource"},
-			status:  http.StatusBadRequest,
-			err:     "kivik: eventsource feed not supported, use 'continuous'",
+			status:  http.StatusBadGateway,
+			err:     `Get "?http://example.com/testdb/_changes\?feed=eventsource"?: net error`,
 		},
 		{
 			name:   "network error",
@@ -139,6 +142,25 @@ func TestChangesNext(t *testing.T) {
 	}
 }
 
+func TestChangesLastSeq(t *testing.T) {
+	t.Run("normal", func(t *testing.T) {
+		feed := newChangesRows(context.TODO(), "results", Body(`{"results":[],"last_seq":"3-abc","pending":2}`), "")
+		row := new(driver.Change)
+		if err := feed.Next(row); err != io.EOF {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if feed.LastSeq() != "3-abc" || feed.Pending() != 2 {
+			t.Errorf("Unexpected last_seq %s, pending %d", feed.LastSeq(), feed.Pending())
+		}
+	})
+	t.Run("continuous", func(t *testing.T) {
+		feed := newChangesRows(context.TODO(), "", Body(""), "")
+		if feed.LastSeq() != "" || feed.Pending() != 0 {
+			t.Errorf("Unexpected last_seq %s, pending %d", feed.LastSeq(), feed.Pending())
+		}
+	})
+}
+
 func TestChangesClose(t *testing.T) {
 	t.Run("normal", func(t *testing.T) {
 		body := &closeTracker{ReadCloser: Body("foo")}
@@ -166,3 +188,74 @@ func TestChangesClose(t *testing.T) {
 		}
 	})
 }
+
+func TestChangesPost(t *testing.T) {
+	type tt struct {
+		options map[string]interface{}
+		body    interface{}
+		query   string
+		status  int
+		err     string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("doc_ids", tt{
+		options: map[string]interface{}{"doc_ids": []string{"foo", "bar"}},
+		body:    map[string]interface{}{"doc_ids": []string{"foo", "bar"}},
+		query:   "filter=_doc_ids",
+	})
+	tests.Add("encoded doc_ids", tt{
+		options: map[string]interface{}{"doc_ids": `["foo"]`, "filter": "_doc_ids"},
+		body:    map[string]interface{}{"doc_ids": []string{"foo"}},
+		query:   "filter=_doc_ids",
+	})
+	tests.Add("selector", tt{
+		options: map[string]interface{}{
+			"selector": map[string]interface{}{"type": "tenant"},
+			"feed":     "continuous",
+		},
+		body:  map[string]interface{}{"selector": map[string]string{"type": "tenant"}},
+		query: "feed=continuous&filter=_selector",
+	})
+	tests.Add("both", tt{
+		options: map[string]interface{}{
+			"doc_ids":  []string{"foo"},
+			"selector": map[string]interface{}{"type": "tenant"},
+		},
+		status: http.StatusBadRequest,
+		err:    "kivik: doc_ids and selector options require an explicit filter",
+	})
+	tests.Add("custom filter", tt{
+		options: map[string]interface{}{
+			"doc_ids": []string{"foo"},
+			"filter":  "ddoc/tenant",
+		},
+		body:  map[string]interface{}{"doc_ids": []string{"foo"}},
+		query: "filter=ddoc%2Ftenant",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		db := newCustomDB(func(r *http.Request) (*http.Response, error) {
+			if r.Method != http.MethodPost {
+				t.Errorf("Unexpected method: %s", r.Method)
+			}
+			if _, ok := r.Header[chttp.HeaderIdempotencyKey]; !ok {
+				t.Error("Idempotency key header missing")
+			}
+			if q := r.URL.RawQuery; q != tt.query {
+				t.Errorf("Unexpected query: %s", q)
+			}
+			defer r.Body.Close() // nolint: errcheck
+			if d := testy.DiffAsJSON(tt.body, r.Body); d != nil {
+				t.Error(d)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       Body(`{"results":[],"last_seq":"1-x","pending":0}`),
+			}, nil
+		})
+		changes, err := db.Changes(context.Background(), tt.options)
+		testy.StatusError(t, tt.err, tt.status, err)
+		_ = changes.Close()
+	})
+}
@@ -1,7 +1,11 @@
 package chttp
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"net/http/cookiejar"
+	"sync"
 
 	"golang.org/x/net/publicsuffix"
 )
@@ -11,6 +15,128 @@ type Authenticator interface {
 	Authenticate(*Client) error
 }
 
+// transportWrapper is implemented by the authenticators in this package. It
+// allows them to be installed by SetAuth without modifying c.Transport while
+// requests may be in flight.
+type transportWrapper interface {
+	wrap(c *Client, rt http.RoundTripper) http.RoundTripper
+}
+
+// CredentialProvider supplies the username and password used by BasicAuth
+// or CookieAuth, so that credentials which change over time, such as a
+// password rotated by a secrets manager, can be used without replacing the
+// authenticator.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (username, password string, err error)
+}
+
+// CredentialFunc is an adaptor to allow the use of an ordinary function as a
+// CredentialProvider.
+type CredentialFunc func(ctx context.Context) (username, password string, err error)
+
+var _ CredentialProvider = CredentialFunc(nil)
+
+// Credentials calls f(ctx).
+func (f CredentialFunc) Credentials(ctx context.Context) (username, password string, err error) {
+	return f(ctx)
+}
+
+// authTransport routes requests through the active authenticator, or the
+// original transport if none is set.
+type authTransport struct {
+	base http.RoundTripper
+
+	mu      sync.RWMutex
+	current http.RoundTripper
+}
+
+var _ http.RoundTripper = &authTransport{}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	rt := t.current
+	t.mu.RUnlock()
+	if rt == nil || contextRequestAuth(req.Context()) != nil {
+		rt = t.baseTransport()
+	}
+	return rt.RoundTrip(req)
+}
+
+func (t *authTransport) redactedHeaders() []string {
+	t.mu.RLock()
+	rt := t.current
+	t.mu.RUnlock()
+	if r, ok := rt.(headerRedactor); ok {
+		return r.redactedHeaders()
+	}
+	return nil
+}
+
+func (t *authTransport) baseTransport() http.RoundTripper {
+	if t.base == nil {
+		return http.DefaultTransport
+	}
+	return t.base
+}
+
+func (t *authTransport) set(rt http.RoundTripper) {
+	t.mu.Lock()
+	t.current = rt
+	t.mu.Unlock()
+}
+
+// SetAuth replaces the active authenticator with a, which must be one of the
+// authenticators provided by this package. If a is nil, authentication is
+// removed, and requests are sent using the original transport. When a
+// CookieAuth is replaced or removed, its session cookie is discarded.
+//
+// The first call to Auth or SetAuth installs a transport and a cookie jar in
+// c, so it must not run concurrently with requests. Once an authenticator has
+// been set, it is safe to call SetAuth while requests are in flight; each
+// request uses either the previous or the new authenticator.
+//
+// The original transport is the one in use the first time Auth or SetAuth is
+// called. Setting c.Transport directly discards the active authenticator, and
+// the new transport is treated as the original transport by subsequent calls,
+// which again must not run concurrently with requests.
+func (c *Client) SetAuth(a Authenticator) error {
+	c.setAuthMU.Lock()
+	defer c.setAuthMU.Unlock()
+	return c.setAuth(a)
+}
+
+func (c *Client) setAuth(a Authenticator) error {
+	var w transportWrapper
+	if a != nil {
+		var ok bool
+		if w, ok = a.(transportWrapper); !ok {
+			return errors.New("authenticator cannot be replaced")
+		}
+	}
+	if c.authRT == nil || c.Transport != c.authRT {
+		c.authRT = &authTransport{base: c.Transport}
+		if c.Jar == nil {
+			// Set the jar now, as CookieAuth cannot safely do so later.
+			// cookiejar.New never returns an error
+			c.Jar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+		}
+		c.Transport = c.authRT
+	}
+	if old, ok := c.auth.(*CookieAuth); ok && a != c.auth {
+		// CouchDB accepts the session cookie before any other credentials,
+		// so it must not outlive the CookieAuth which obtained it.
+		old.discardSession()
+	}
+	if w == nil {
+		c.authRT.set(nil)
+		c.auth = nil
+		return nil
+	}
+	c.authRT.set(w.wrap(c, c.authRT.baseTransport()))
+	c.auth = a
+	return nil
+}
+
 func (a *CookieAuth) setCookieJar() {
 	// If a jar is already set, just use it
 	if a.client.Jar != nil {
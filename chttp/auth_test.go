@@ -3,10 +3,13 @@ package chttp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"sync"
 	"testing"
 
 	"gitlab.com/flimzy/testy"
@@ -151,3 +154,146 @@ func TestAuthenticate(t *testing.T) {
 		testy.StatusErrorRE(t, test.err, test.status, err)
 	})
 }
+
+type foreignAuth struct{}
+
+func (foreignAuth) Authenticate(_ *Client) error { return nil }
+
+func TestSetAuth(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		mu.Lock()
+		got = append(got, user)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	c, err := New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() {
+		if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := c.Auth(&BasicAuth{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if err := c.Auth(&BasicAuth{Username: "bob"}); err == nil {
+		t.Error("Expected Auth to fail when auth is already set")
+	}
+	if err := c.SetAuth(&BasicAuth{Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if err := c.SetAuth(nil); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if err := c.Auth(&BasicAuth{Username: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if d := testy.DiffInterface([]string{"alice", "bob", "", "carol"}, got); d != nil {
+		t.Error(d)
+	}
+	testy.Error(t, "authenticator cannot be replaced", c.SetAuth(foreignAuth{}))
+
+	// Replace the authenticator while requests are in flight.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			get()
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if err := c.SetAuth(&BasicAuth{Username: "user" + strconv.Itoa(i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSetAuthDiscardsSession(t *testing.T) {
+	var cookies []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_session" {
+			http.SetCookie(w, &http.Cookie{Name: kivik.SessionCookieName, Value: "alice", Path: "/"})
+			_, _ = w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		cookies = append(cookies, r.Header.Get("Cookie"))
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer s.Close()
+
+	c, err := New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() {
+		if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Auth(&CookieAuth{Username: "alice", Password: "abc123"}); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if err := c.SetAuth(nil); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if err := c.SetAuth(&BasicAuth{Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if d := testy.DiffInterface([]string{"AuthSession=alice", "", ""}, cookies); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestCredentialFunc(t *testing.T) {
+	var user string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	c, err := New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := "one"
+	err = c.Auth(&BasicAuth{Provider: CredentialFunc(func(_ context.Context) (string, string, error) {
+		return "user-" + password, password, nil
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password = range []string{"one", "two"} {
+		if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+		if user != "user-"+password {
+			t.Errorf("Unexpected user: %s", user)
+		}
+	}
+	err = c.SetAuth(&BasicAuth{Provider: CredentialFunc(func(_ context.Context) (string, string, error) {
+		return "", "", errors.New("vault sealed")
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.DoError(context.Background(), http.MethodGet, "/", nil)
+	testy.ErrorRE(t, "vault sealed", err)
+}
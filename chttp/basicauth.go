@@ -9,6 +9,10 @@ type BasicAuth struct {
 	Username string
 	Password string
 
+	// Provider, if set, is called for every request to obtain the current
+	// credentials, in place of Username and Password.
+	Provider CredentialProvider
+
 	// transport stores the original transport that is overridden by this auth
 	// mechanism
 	transport http.RoundTripper
@@ -19,16 +23,31 @@ var _ Authenticator = &BasicAuth{}
 // RoundTrip fulfills the http.RoundTripper interface. It sets HTTP Basic Auth
 // on outbound requests.
 func (a *BasicAuth) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.SetBasicAuth(a.Username, a.Password)
+	if contextRequestAuth(req.Context()) != nil {
+		return a.transport.RoundTrip(req)
+	}
+	username, password := a.Username, a.Password
+	if a.Provider != nil {
+		var err error
+		username, password, err = a.Provider.Credentials(req.Context())
+		if err != nil {
+			return nil, err
+		}
+	}
+	req.SetBasicAuth(username, password)
 	return a.transport.RoundTrip(req)
 }
 
 // Authenticate sets HTTP Basic Auth headers for the client.
 func (a *BasicAuth) Authenticate(c *Client) error {
-	a.transport = c.Transport
+	c.Transport = a.wrap(c, c.Transport)
+	return nil
+}
+
+func (a *BasicAuth) wrap(_ *Client, rt http.RoundTripper) http.RoundTripper {
+	a.transport = rt
 	if a.transport == nil {
 		a.transport = http.DefaultTransport
 	}
-	c.Transport = a
-	return nil
+	return a
 }
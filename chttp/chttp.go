@@ -16,7 +16,9 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	kivik "github.com/go-kivik/kivik/v4"
 )
@@ -39,10 +41,68 @@ type Client struct {
 
 	*http.Client
 
+	// RetryPolicy, if set, enables the automatic retry of requests which fail
+	// with a transient error. See RetryPolicy for details.
+	RetryPolicy *RetryPolicy
+
+	// ThrottleRetries is the maximum number of times a request is resubmitted
+	// after the server responds with 429 Too Many Requests. If zero,
+	// DefaultThrottleRetries is used. A negative value disables resubmission
+	// of throttled requests. The server's Retry-After delay is honored up to
+	// RetryPolicy.MaxThrottleDelay, or DefaultMaxThrottleDelay, and the total
+	// wait of a request is capped at RetryPolicy.MaxThrottleWait, or
+	// DefaultMaxThrottleWait.
+	ThrottleRetries int
+
+	// NodeStrategy selects how requests are distributed when the client is
+	// connected to multiple nodes. The default is RoundRobin.
+	NodeStrategy NodeStrategy
+
+	// HealthCheckInterval is the minimum time between health probes of a node
+	// which has been marked down. Defaults to DefaultHealthCheckInterval.
+	HealthCheckInterval time.Duration
+
+	// GzipThreshold, if positive, enables gzip compression of request bodies
+	// of at least this many bytes, which are sent with the header
+	// Content-Encoding: gzip. Bodies of a declared length, such as multipart
+	// uploads, are never compressed.
+	GzipThreshold int
+
+	// Metrics, if set, receives measurements of each request made with
+	// DoReq.
+	Metrics Metrics
+
+	// Tracer, if set, is used to create a span for each call to DoReq, which
+	// is identified to the server with the traceparent header.
+	Tracer Tracer
+
+	// Logger, if set, receives an entry for each request sent.
+	Logger Logger
+
+	// LogHeaders, if true, includes request and response headers in log
+	// entries. Credentials are redacted.
+	LogHeaders bool
+
+	// LogBodyLimit, if positive, includes up to this many bytes of request and
+	// response bodies in log entries. Response bodies are logged in a separate
+	// entry, when the body is closed. Passwords sent to /_session are
+	// redacted.
+	LogBodyLimit int
+
 	rawDSN string
 	dsn    *url.URL
 	auth   Authenticator
 	authMU sync.Mutex
+
+	// authRT is installed as the transport when the authenticator is set, so
+	// that it can later be replaced by SetAuth.
+	authRT    *authTransport
+	setAuthMU sync.Mutex
+
+	// nodes is only populated when connected to multiple nodes, in which case
+	// the first node's URL is also stored in dsn.
+	nodes    []*node
+	nextNode uint32
 }
 
 // New returns a connection to a remote CouchDB server. If credentials are
@@ -55,11 +115,50 @@ func New(dsn string) (*Client, error) {
 
 // NewWithClient works the same as New(), but allows providing a custom
 // *http.Client for all network connections.
+//
+// To connect to multiple nodes of a CouchDB cluster, provide a comma-separated
+// list of URLs, each with an explicit http:// or https:// scheme. Credentials
+// are taken from the first URL. Requests are distributed among the nodes
+// according to the client's NodeStrategy, and nodes which cannot be reached
+// are skipped until a health probe succeeds.
+//
+// TLS may be configured with the DSN query parameters DSNParamCAFile,
+// DSNParamCertFile, DSNParamKeyFile, DSNParamServerName and
+// DSNParamInsecureSkipVerify, in which case client.Transport must be nil or
+// an *http.Transport. client is not modified.
+//
+// To connect to a server listening on a Unix domain socket, use the
+// SchemeHTTPUnix scheme, with the URL-encoded path to the socket as the host.
+// Unix sockets are not supported with multiple nodes.
 func NewWithClient(client *http.Client, dsn string) (*Client, error) {
-	dsnURL, err := parseDSN(dsn)
+	dsns := splitDSN(dsn)
+	socket, firstDSN, err := unixDSN(dsns[0])
+	if err != nil {
+		return nil, err
+	}
+	if socket != "" && len(dsns) > 1 {
+		return nil, fullError(http.StatusBadRequest, ExitFailedToInitialize, errors.New("unix sockets are not supported with multiple nodes"))
+	}
+	dsnURL, err := parseDSN(firstDSN)
 	if err != nil {
 		return nil, err
 	}
+	tlsOpts, err := tlsOptionsFromDSN(dsnURL)
+	if err != nil {
+		return nil, err
+	}
+	if tlsOpts != nil {
+		if client, err = withTransport(client, tlsOpts.Transport); err != nil {
+			return nil, err
+		}
+	}
+	if socket != "" {
+		if client, err = withTransport(client, func(rt http.RoundTripper) (http.RoundTripper, error) {
+			return DialTransport(rt, UnixDialer(socket))
+		}); err != nil {
+			return nil, err
+		}
+	}
 	user := dsnURL.User
 	dsnURL.User = nil
 	c := &Client{
@@ -67,6 +166,11 @@ func NewWithClient(client *http.Client, dsn string) (*Client, error) {
 		dsn:    dsnURL,
 		rawDSN: dsn,
 	}
+	if len(dsns) > 1 {
+		if c.nodes, err = parseNodes(dsnURL, dsns[1:]); err != nil {
+			return nil, err
+		}
+	}
 	if user != nil {
 		password, _ := user.Password()
 		err := c.Auth(&CookieAuth{
@@ -80,6 +184,18 @@ func NewWithClient(client *http.Client, dsn string) (*Client, error) {
 	return c, nil
 }
 
+// withTransport returns a copy of c, with its transport replaced by the
+// result of fn.
+func withTransport(c *http.Client, fn func(http.RoundTripper) (http.RoundTripper, error)) (*http.Client, error) {
+	transport, err := fn(c.Transport)
+	if err != nil {
+		return nil, err
+	}
+	client := *c
+	client.Transport = transport
+	return &client, nil
+}
+
 func parseDSN(dsn string) (*url.URL, error) {
 	if dsn == "" {
 		return nil, &curlError{
@@ -106,11 +222,18 @@ func (c *Client) DSN() string {
 	return c.rawDSN
 }
 
-// Auth authenticates using the provided Authenticator.
+// Auth authenticates using the provided Authenticator. It returns an error if
+// an authenticator is already set; use SetAuth to replace it. Authenticators
+// wrap c.Transport, so a custom transport should be set before calling Auth.
 func (c *Client) Auth(a Authenticator) error {
+	c.setAuthMU.Lock()
+	defer c.setAuthMU.Unlock()
 	if c.auth != nil {
 		return errors.New("auth already set")
 	}
+	if _, ok := a.(transportWrapper); ok {
+		return c.setAuth(a)
+	}
 	if err := a.Authenticate(c); err != nil {
 		return err
 	}
@@ -159,6 +282,14 @@ type Options struct {
 
 	// Header is a list of default headers to be set on the request.
 	Header http.Header
+
+	// Gzip enables compression of the request body, if it is larger than the
+	// client's GzipThreshold, or DefaultGzipThreshold if that is unset.
+	Gzip bool
+
+	// GzipThreshold, if non-zero, overrides the client's GzipThreshold for
+	// this request. A negative value disables compression.
+	GzipThreshold int
 }
 
 // Response represents a response from a CouchDB server.
@@ -196,15 +327,16 @@ func (c *Client) DoJSON(ctx context.Context, method, path string, opts *Options,
 // NewRequest returns a new *http.Request to the CouchDB server, and the
 // specified path. The host, schema, etc, of the specified path are ignored.
 func (c *Client) NewRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	base := c.baseURL()
 	fullPath := path
-	if cPath := strings.TrimSuffix(c.dsn.Path, "/"); cPath != "" {
+	if cPath := strings.TrimSuffix(base.Path, "/"); cPath != "" {
 		fullPath = cPath + "/" + strings.TrimPrefix(path, "/")
 	}
 	reqPath, err := url.Parse(fullPath)
 	if err != nil {
 		return nil, fullError(http.StatusBadRequest, ExitStatusURLMalformed, err)
 	}
-	u := *c.dsn // Make a copy
+	u := *base // Make a copy
 	u.Path = reqPath.Path
 	u.RawQuery = reqPath.RawQuery
 	req, err := http.NewRequest(method, u.String(), body)
@@ -218,10 +350,73 @@ func (c *Client) NewRequest(ctx context.Context, method, path string, body io.Re
 // DoReq does an HTTP request. An error is returned only if there was an error
 // processing the request. In particular, an error status code, such as 400
 // or 500, does _not_ cause an error to be returned.
+//
+// If c.RetryPolicy is set, requests which fail with a transient error are
+// retried according to the policy.
+//
+// Requests which receive a 429 Too Many Requests response are resubmitted
+// after the delay indicated by the Retry-After header, provided the request
+// body (if any) can be regenerated with opts.GetBody, and the delay exceeds
+// neither the context's deadline nor the total wait allowed by
+// c.RetryPolicy. See c.ThrottleRetries.
 func (c *Client) DoReq(ctx context.Context, method, path string, opts *Options) (*http.Response, error) {
 	if method == "" {
 		return nil, errors.New("chttp: method required")
 	}
+	m := c.startMeter(method, path)
+	ctx, span := c.startRequestSpan(ctx, method, path)
+	res, retries, err := c.doAttempts(ctx, method, path, opts, m, span)
+	endRequestSpan(span, res, err, retries)
+	return m.finish(res, retries, err)
+}
+
+// doAttempts performs the request described by DoReq, retrying and
+// resubmitting it as necessary. It returns the final response, and the number
+// of retries and resubmissions.
+func (c *Client) doAttempts(ctx context.Context, method, path string, opts *Options, m *meter, span Span) (*http.Response, int, error) {
+	maxAttempts := 1
+	if replayable(method, opts) {
+		maxAttempts = c.RetryPolicy.maxAttempts()
+	}
+	maxThrottled := c.throttleRetries()
+	if !resubmittable(opts) {
+		maxThrottled = 0
+	}
+	var attempt, throttled, failovers, retries int
+	var waited time.Duration
+	for {
+		res, err := c.doReq(ctx, method, path, opts, m, span)
+		var delay time.Duration
+		switch {
+		case failovers+1 < len(c.nodes) && connectFailure(err) && resubmittable(opts):
+			// The request never reached the server, so it is always safe
+			// to send it to another node.
+			failovers++
+		case err == nil && res.StatusCode == http.StatusTooManyRequests && throttled < maxThrottled:
+			throttled++
+			delay = c.RetryPolicy.throttleDelay(res, throttled)
+			if waited += delay; waited > c.RetryPolicy.maxThrottleWait() || !beforeDeadline(ctx, delay) {
+				return res, retries, err
+			}
+			if trace := ContextClientTrace(ctx); trace != nil {
+				trace.throttled(res, delay)
+			}
+		case attempt+1 < maxAttempts && c.RetryPolicy.transient(res, err):
+			attempt++
+			delay = c.RetryPolicy.delay(attempt)
+		default:
+			return res, retries, err
+		}
+		if e := sleep(ctx, delay); e != nil {
+			return res, retries, err
+		}
+		discardResponse(res)
+		retries++
+	}
+}
+
+// doReq performs a single attempt of the request described by DoReq.
+func (c *Client) doReq(ctx context.Context, method, path string, opts *Options, m *meter, span Span) (*http.Response, error) {
 	var body io.Reader
 	if opts != nil {
 		if opts.GetBody != nil {
@@ -236,6 +431,16 @@ func (c *Client) DoReq(ctx context.Context, method, path string, opts *Options)
 			defer opts.Body.Close() // nolint: errcheck
 		}
 	}
+	var compressed bool
+	if threshold := c.gzipThreshold(opts); threshold > 0 && body != nil {
+		var err error
+		if body, compressed, err = gzipBody(body, threshold); err != nil {
+			return nil, err
+		}
+	}
+	if r, ok := c.Transport.(headerRedactor); ok {
+		ctx = withRedactedHeaders(ctx, r.redactedHeaders()...)
+	}
 	req, err := c.NewRequest(ctx, method, path, body)
 	if err != nil {
 		return nil, err
@@ -246,6 +451,27 @@ func (c *Client) DoReq(ctx context.Context, method, path string, opts *Options)
 	if opts != nil {
 		req.GetBody = opts.GetBody
 	}
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+		if req.GetBody != nil {
+			req.GetBody = gzipGetBody(req.GetBody)
+		}
+	}
+	m.countBody(req)
+	setTraceParent(req, span)
+	log := c.startLog(req)
+
+	client := c.Client
+	if auth := contextRequestAuth(ctx); auth != nil {
+		if err := auth(req); err != nil {
+			return nil, err
+		}
+		// Bypass the cookie jar, so that the client's session cookie is not
+		// sent.
+		noJar := *c.Client
+		noJar.Jar = nil
+		client = &noJar
+	}
 
 	trace := ContextClientTrace(ctx)
 	if trace != nil {
@@ -253,12 +479,24 @@ func (c *Client) DoReq(ctx context.Context, method, path string, opts *Options)
 		trace.httpRequestBody(req)
 	}
 
-	response, err := c.Do(req)
+	n := c.nodeFor(req.URL)
+	if n != nil {
+		atomic.AddInt64(&n.outstanding, 1)
+	}
+	response, err := client.Do(req)
+	err = netError(err)
+	if n != nil {
+		atomic.AddInt64(&n.outstanding, -1)
+		if connectFailure(err) {
+			n.setDown(true)
+		}
+	}
 	if trace != nil {
 		trace.httpResponse(response)
 		trace.httpResponseBody(response)
 	}
-	return response, netError(err)
+	log.finish(req, response, err)
+	return response, err
 }
 
 func netError(err error) error {
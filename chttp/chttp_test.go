@@ -85,7 +85,8 @@ func TestNew(t *testing.T) {
 				transport: http.DefaultTransport,
 			}
 			c.auth = auth
-			c.Client.Transport = auth
+			c.authRT = &authTransport{current: auth}
+			c.Client.Transport = c.authRT
 			return newTest{
 				name:     "auth success",
 				dsn:      authDSN.String(),
@@ -3,11 +3,16 @@ package chttp
 import (
 	"context"
 	"net/http"
+	"sync"
 	"time"
 
 	kivik "github.com/go-kivik/kivik/v4"
 )
 
+// DefaultCookieRefreshWindow is how long before its expiry a session is
+// renewed, when CookieAuth.RefreshWindow is unset.
+const DefaultCookieRefreshWindow = 10 * time.Second
+
 // CookieAuth provides CouchDB Cookie auth services as described at
 // http://docs.couchdb.org/en/2.0.0/api/server/authn.html#cookie-authentication
 //
@@ -16,68 +21,292 @@ type CookieAuth struct {
 	Username string `json:"name"`
 	Password string `json:"password"`
 
+	// Provider, if set, is called each time a session is established to
+	// obtain the current credentials, in place of Username and Password.
+	Provider CredentialProvider `json:"-"`
+
+	// MaxAge, if set, is the maximum age of a session before it is renewed.
+	// This is useful for servers which do not include an expiry time in the
+	// session cookie. A session cookie refreshed by the server resets the age.
+	MaxAge time.Duration `json:"-"`
+
+	// RefreshWindow is how long before the session cookie's expiry a new
+	// session is requested. Defaults to DefaultCookieRefreshWindow.
+	RefreshWindow time.Duration `json:"-"`
+
 	client *Client
 	// transport stores the original transport that is overridden by this auth
 	// mechanism
 	transport http.RoundTripper
+
+	mu sync.Mutex
+	// renewed is the time the session cookie was last set by the server.
+	renewed time.Time
+	// expires is the expiry time of the session cookie, as sent by the server.
+	expires time.Time
+	// invalid is set when the server rejects the session cookie.
+	invalid bool
 }
 
 var _ Authenticator = &CookieAuth{}
 
 // Authenticate initiates a session with the CouchDB server.
 func (a *CookieAuth) Authenticate(c *Client) error {
+	c.Transport = a.wrap(c, c.Transport)
+	return nil
+}
+
+func (a *CookieAuth) wrap(c *Client, rt http.RoundTripper) http.RoundTripper {
 	a.client = c
 	a.setCookieJar()
-	a.transport = c.Transport
+	a.transport = rt
 	if a.transport == nil {
 		a.transport = http.DefaultTransport
 	}
-	c.Transport = a
-	return nil
+	return a
 }
 
-// shouldAuth returns true if there is no cookie set, or if it has expired.
+// shouldAuth returns true if there is no cookie set, or if it has expired or
+// is due for renewal.
 func (a *CookieAuth) shouldAuth(req *http.Request) bool {
-	if _, err := req.Cookie(kivik.SessionCookieName); err == nil {
+	now := time.Now()
+	if _, err := req.Cookie(kivik.SessionCookieName); err == nil && !a.stale(now) {
 		return false
 	}
+	return a.current(now) == nil
+}
+
+// current returns the session cookie, if it is set and not due for renewal.
+func (a *CookieAuth) current(now time.Time) *http.Cookie {
+	if a.stale(now) {
+		return nil
+	}
 	cookie := a.Cookie()
 	if cookie == nil {
+		return nil
+	}
+	if !cookie.Expires.IsZero() && a.expiring(cookie.Expires, now) {
+		return nil
+	}
+	return cookie
+}
+
+// stale returns true if the session is known to be invalid, or is due for
+// renewal according to the expiry or age last reported by the server. If the
+// server did not include an expiry time in the session cookie, and MaxAge is
+// unset, the session is never considered stale, to avoid re-authenticating
+// for every request.
+func (a *CookieAuth) stale(now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.invalid {
 		return true
 	}
-	if !cookie.Expires.IsZero() {
-		return cookie.Expires.Before(time.Now())
+	if !a.expires.IsZero() && a.expiring(a.expires, now) {
+		return true
 	}
-	// If we get here, it means the server did not include an expiry time in
-	// the session cookie. Some CouchDB configurations do this, but rather than
-	// re-authenticating for every request, we'll let the session expire. A
-	// future change might be to make a client-configurable option to set the
-	// re-authentication timeout.
-	return false
+	return a.MaxAge > 0 && !a.renewed.IsZero() && now.Sub(a.renewed) >= a.MaxAge
 }
 
-// Cookie returns the current session cookie if found, or nil if not.
+func (a *CookieAuth) expiring(expires, now time.Time) bool {
+	window := a.RefreshWindow
+	if window <= 0 {
+		window = DefaultCookieRefreshWindow
+	}
+	return !now.Add(window).Before(expires)
+}
+
+// track records the renewal time and expiry of a session cookie set by the
+// server, either in response to POST /_session, or when CouchDB refreshes the
+// session of an authenticated request.
+func (a *CookieAuth) track(res *http.Response) {
+	for _, cookie := range res.Cookies() {
+		if cookie.Name != kivik.SessionCookieName {
+			continue
+		}
+		now := time.Now()
+		a.mu.Lock()
+		a.invalid = false
+		a.renewed, a.expires = now, time.Time{}
+		switch {
+		case cookie.Value == "":
+			// The session was ended by DELETE /_session
+			a.renewed = time.Time{}
+		case cookie.MaxAge > 0:
+			a.expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case !cookie.Expires.IsZero():
+			a.expires = cookie.Expires
+		}
+		a.mu.Unlock()
+	}
+}
+
+// invalidate forces re-authentication, unless the rejected session cookie
+// has already been replaced.
+func (a *CookieAuth) invalidate(rejected *http.Cookie) {
+	if c := a.Cookie(); c != nil && c.Value != rejected.Value {
+		return
+	}
+	a.mu.Lock()
+	a.invalid = true
+	a.mu.Unlock()
+}
+
+// Cookie returns the current session cookie if found, or nil if not. When
+// connected to multiple nodes, the cookie of any node may be returned, as
+// CouchDB cluster members share the secret used to sign sessions.
 func (a *CookieAuth) Cookie() *http.Cookie {
 	if a.client == nil {
 		return nil
 	}
-	for _, cookie := range a.client.Jar.Cookies(a.client.dsn) {
-		if cookie.Name == kivik.SessionCookieName {
-			return cookie
+	for _, u := range a.client.nodeURLs() {
+		for _, cookie := range a.client.Jar.Cookies(u) {
+			if cookie.Name == kivik.SessionCookieName {
+				return cookie
+			}
 		}
 	}
 	return nil
 }
 
+// Logout ends the session by issuing DELETE /_session, and discards the
+// session cookie. A subsequent request will establish a new session.
+func (a *CookieAuth) Logout(ctx context.Context) error {
+	if a.client == nil {
+		return nil
+	}
+	ctx = context.WithValue(ctx, authInProgress, true)
+	if _, err := a.client.DoError(ctx, http.MethodDelete, "/_session", nil); err != nil {
+		return err
+	}
+	a.discardSession()
+	return nil
+}
+
+// discardSession expires the session cookie for every node, without ending
+// the session on the server.
+func (a *CookieAuth) discardSession() {
+	if a.client == nil || a.client.Jar == nil {
+		return
+	}
+	for _, u := range a.client.nodeURLs() {
+		a.client.Jar.SetCookies(u, []*http.Cookie{{
+			Name:   kivik.SessionCookieName,
+			Path:   "/",
+			MaxAge: -1,
+		}})
+	}
+	a.mu.Lock()
+	a.renewed, a.expires = time.Time{}, time.Time{}
+	a.mu.Unlock()
+}
+
 var authInProgress = &struct{ name string }{"in progress"}
 
 // RoundTrip fulfills the http.RoundTripper interface. It sets
-// (re-)authenticates when the cookie has expired or is not yet set.
+// (re-)authenticates when the cookie has expired or is not yet set. If the
+// server rejects the session cookie with a 401 Unauthorized response, the
+// session is renewed, and the request is sent once more, provided its body
+// can be replayed.
 func (a *CookieAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	if contextRequestAuth(req.Context()) != nil {
+		return a.transport.RoundTrip(req)
+	}
 	if err := a.authenticate(req); err != nil {
 		return nil, err
 	}
-	return a.transport.RoundTrip(req)
+	res, err := a.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	a.track(res)
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+	if inProg, _ := req.Context().Value(authInProgress).(bool); inProg {
+		return res, nil
+	}
+	rejected, err := req.Cookie(kivik.SessionCookieName)
+	if err != nil {
+		return res, nil
+	}
+	retry, err := replayRequest(req)
+	if err != nil || retry == nil {
+		return res, nil
+	}
+	discardResponse(res)
+	a.invalidate(rejected)
+	if err := a.authenticate(retry); err != nil {
+		return nil, err
+	}
+	res, err = a.transport.RoundTrip(retry)
+	if err != nil {
+		return nil, err
+	}
+	a.track(res)
+	return res, nil
+}
+
+// replayRequest returns a copy of req with a fresh body, or nil if the body
+// cannot be replayed.
+func replayRequest(req *http.Request) (*http.Request, error) {
+	// A shallow copy, rather than req.Clone, which requires Go 1.13.
+	retry := *req
+	retry.Header = cloneHeader(req.Header)
+	if req.Body == nil || req.Body == http.NoBody {
+		return &retry, nil
+	}
+	if req.GetBody == nil {
+		return nil, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	retry.Body = body
+	return &retry, nil
+}
+
+// cloneHeader returns a deep copy of h.
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	clone := make(http.Header, len(h))
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
+
+// setSessionCookie sets the session cookie on req, replacing any existing
+// session cookie.
+func setSessionCookie(req *http.Request, cookie *http.Cookie) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != kivik.SessionCookieName {
+			req.AddCookie(c)
+		}
+	}
+	req.AddCookie(cookie)
+}
+
+// sessionCredentials is the body of a POST /_session request.
+type sessionCredentials struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+func (a *CookieAuth) credentials(ctx context.Context) (*sessionCredentials, error) {
+	if a.Provider == nil {
+		return &sessionCredentials{Name: a.Username, Password: a.Password}, nil
+	}
+	username, password, err := a.Provider.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionCredentials{Name: username, Password: password}, nil
 }
 
 func (a *CookieAuth) authenticate(req *http.Request) error {
@@ -86,18 +315,28 @@ func (a *CookieAuth) authenticate(req *http.Request) error {
 		return nil
 	}
 	if !a.shouldAuth(req) {
+		if _, err := req.Cookie(kivik.SessionCookieName); err != nil {
+			// The session was established with another node.
+			if c := a.Cookie(); c != nil {
+				setSessionCookie(req, c)
+			}
+		}
 		return nil
 	}
 	a.client.authMU.Lock()
 	defer a.client.authMU.Unlock()
-	if c := a.Cookie(); c != nil {
+	if c := a.current(time.Now()); c != nil {
 		// In case another simultaneous process authenticated successfully first
-		req.AddCookie(c)
+		setSessionCookie(req, c)
 		return nil
 	}
+	creds, err := a.credentials(ctx)
+	if err != nil {
+		return err
+	}
 	ctx = context.WithValue(ctx, authInProgress, true)
 	opts := &Options{
-		GetBody: BodyEncoder(a),
+		GetBody: BodyEncoder(creds),
 		Header: http.Header{
 			HeaderIdempotencyKey: []string{},
 		},
@@ -105,8 +344,9 @@ func (a *CookieAuth) authenticate(req *http.Request) error {
 	if _, err := a.client.DoError(ctx, http.MethodPost, "/_session", opts); err != nil {
 		return err
 	}
+	a.client.authRefreshed("cookie")
 	if c := a.Cookie(); c != nil {
-		req.AddCookie(c)
+		setSessionCookie(req, c)
 	}
 	return nil
 }
@@ -2,6 +2,9 @@ package chttp
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
@@ -235,3 +238,279 @@ func Test_shouldAuth(t *testing.T) {
 		}
 	})
 }
+
+func Test_shouldAuth_renewal(t *testing.T) {
+	type tt struct {
+		a    *CookieAuth
+		want bool
+	}
+
+	newAuth := func() *CookieAuth {
+		c, _ := New("http://example.com/")
+		c.Jar = &dummyJar{&http.Cookie{
+			Name:  kivik.SessionCookieName,
+			Value: "foo",
+		}}
+		return &CookieAuth{client: c}
+	}
+
+	tests := testy.NewTable()
+	tests.Add("max age exceeded", func() interface{} {
+		a := newAuth()
+		a.MaxAge = time.Minute
+		a.renewed = time.Now().Add(-2 * time.Minute)
+		return tt{a: a, want: true}
+	})
+	tests.Add("max age not exceeded", func() interface{} {
+		a := newAuth()
+		a.MaxAge = time.Minute
+		a.renewed = time.Now()
+		return tt{a: a, want: false}
+	})
+	tests.Add("tracked expiry within refresh window", func() interface{} {
+		a := newAuth()
+		a.expires = time.Now().Add(5 * time.Second)
+		return tt{a: a, want: true}
+	})
+	tests.Add("custom refresh window", func() interface{} {
+		a := newAuth()
+		a.RefreshWindow = time.Second
+		a.expires = time.Now().Add(5 * time.Second)
+		return tt{a: a, want: false}
+	})
+	tests.Add("invalidated session", func() interface{} {
+		a := newAuth()
+		a.invalid = true
+		return tt{a: a, want: true}
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: kivik.SessionCookieName, Value: "foo"})
+		got := tt.a.shouldAuth(req)
+		if got != tt.want {
+			t.Errorf("Want %t, got %t", tt.want, got)
+		}
+	})
+}
+
+func TestCookieAuthTrack(t *testing.T) {
+	a := &CookieAuth{}
+	res := &http.Response{Header: http.Header{
+		"Set-Cookie": []string{"AuthSession=foo; Version=1; Path=/; Max-Age=600; HttpOnly"},
+	}}
+	a.invalid = true
+	a.track(res)
+	if a.invalid {
+		t.Error("Expected session to be valid")
+	}
+	if d := time.Until(a.expires); d < 590*time.Second || d > 600*time.Second {
+		t.Errorf("Unexpected expiry: %s", a.expires)
+	}
+
+	res.Header.Set("Set-Cookie", "AuthSession=; Version=1; Path=/; HttpOnly")
+	a.track(res)
+	if !a.renewed.IsZero() || !a.expires.IsZero() {
+		t.Error("Expected session state to be cleared")
+	}
+}
+
+func TestReplayRequest(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "http://example.com/db/doc", Body("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.GetBody = func() (io.ReadCloser, error) {
+		return Body("foo"), nil
+	}
+	_, _ = ioutil.ReadAll(req.Body)
+
+	retry, err := replayRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry.Header.Set("Cookie", "AuthSession=bar")
+	if cookie := req.Header.Get("Cookie"); cookie != "" {
+		t.Errorf("Original request modified: %s", cookie)
+	}
+	body, _ := ioutil.ReadAll(retry.Body)
+	if string(body) != "foo" {
+		t.Errorf("Unexpected body: %s", body)
+	}
+
+	req.GetBody = nil
+	if retry, _ := replayRequest(req); retry != nil {
+		t.Error("Expected nil for a body which cannot be replayed")
+	}
+}
+
+func TestCookieAuthUnauthorized(t *testing.T) {
+	type tt struct {
+		method   string
+		opts     *Options
+		status   int
+		sessions int
+	}
+
+	tests := testy.NewTable()
+	tests.Add("GET", tt{
+		method:   http.MethodGet,
+		status:   http.StatusOK,
+		sessions: 2,
+	})
+	tests.Add("replayable body", tt{
+		method:   http.MethodPut,
+		opts:     &Options{GetBody: BodyEncoder(`{"foo":"bar"}`)},
+		status:   http.StatusOK,
+		sessions: 2,
+	})
+	tests.Add("body not replayable", tt{
+		method:   http.MethodPut,
+		opts:     &Options{Body: Body(`{"foo":"bar"}`)},
+		status:   http.StatusUnauthorized,
+		sessions: 1,
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		var sessions int
+		var valid string
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/_session" {
+				sessions++
+				valid = "session" + string(rune('0'+sessions))
+				http.SetCookie(w, &http.Cookie{Name: kivik.SessionCookieName, Value: valid, Path: "/"})
+				_, _ = w.Write([]byte(`{"ok":true}`))
+				return
+			}
+			if c, err := r.Cookie(kivik.SessionCookieName); err != nil || c.Value != valid {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Body != nil {
+				body, _ := ioutil.ReadAll(r.Body)
+				if r.Method == http.MethodPut && string(body) != `{"foo":"bar"}` {
+					t.Errorf("Unexpected body: %s", string(body))
+				}
+			}
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}))
+		defer s.Close()
+
+		c, err := New(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Auth(&CookieAuth{Username: "foo", Password: "bar"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+		// Simulate a server restart with a new secret
+		valid = ""
+		res, err := c.DoReq(context.Background(), tt.method, "/foo", tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("Unexpected status: %d", res.StatusCode)
+		}
+		if sessions != tt.sessions {
+			t.Errorf("Expected %d sessions, got %d", tt.sessions, sessions)
+		}
+	})
+}
+
+func TestCookieAuthLogout(t *testing.T) {
+	var sessions int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_session" {
+			_, _ = w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			sessions++
+			http.SetCookie(w, &http.Cookie{Name: kivik.SessionCookieName, Value: "foo", Path: "/"})
+		case http.MethodDelete:
+			if c, err := r.Cookie(kivik.SessionCookieName); err != nil || c.Value != "foo" {
+				t.Errorf("Expected session cookie on logout")
+			}
+			w.Header().Set("Set-Cookie", "AuthSession=; Version=1; Path=/; HttpOnly")
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer s.Close()
+
+	c, err := New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &CookieAuth{Username: "foo", Password: "bar"}
+	if err := c.Auth(auth); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.Logout(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c := auth.Cookie(); c != nil {
+		t.Errorf("Unexpected cookie after logout: %v", c)
+	}
+	if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 2 {
+		t.Errorf("Expected a new session after logout, got %d sessions", sessions)
+	}
+}
+
+func TestCookieAuthProvider(t *testing.T) {
+	password := "old"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_session" {
+			var creds struct {
+				Name     string `json:"name"`
+				Password string `json:"password"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&creds)
+			if creds.Name != "foo" || creds.Password != password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: kivik.SessionCookieName, Value: password, Path: "/"})
+			_, _ = w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		if c, err := r.Cookie(kivik.SessionCookieName); err != nil || c.Value != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer s.Close()
+
+	c, err := New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := "old"
+	err = c.Auth(&CookieAuth{Provider: CredentialFunc(func(_ context.Context) (string, string, error) {
+		return "foo", current, nil
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	// Rotate the password, invalidating the existing session
+	password, current = "new", "new"
+	if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+		t.Fatal(err)
+	}
+}
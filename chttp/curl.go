@@ -0,0 +1,132 @@
+package chttp
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CurlCommand returns a curl command line equivalent to req, including its
+// headers, query and body, which may be used to reproduce a request outside
+// of the client. If maskCredentials is true, the Authorization, Cookie and
+// proxy authentication headers, including those listed by RedactedHeaders for
+// the request's context, passwords in the URL, and passwords sent to
+// /_session, are replaced with Redacted.
+//
+// The body is obtained from req.GetBody, so that req.Body, which is typically
+// consumed already, is not needed. Bodies compressed by the client are
+// decompressed. If req has a body but req.GetBody is nil, the returned command
+// reads the body from standard input, and is accompanied by ErrBodyOmitted.
+//
+// To reproduce a failed request, pass the Request of an HTTPError's Response,
+// or use HTTPError.CurlCommand.
+func CurlCommand(req *http.Request, maskCredentials bool) (string, error) {
+	header := req.Header
+	if maskCredentials {
+		header = RedactHeader(header, RedactedHeaders(req.Context())...)
+	}
+	body, compressed, err := curlBody(req)
+	if err != nil && err != ErrBodyOmitted {
+		return "", err
+	}
+	if body != nil && maskCredentials {
+		body = RedactBody(req.URL.Path, body)
+	}
+
+	args := []string{"curl"}
+	switch req.Method {
+	case "", http.MethodGet:
+	case http.MethodHead:
+		args = append(args, "--head")
+	default:
+		args = append(args, "-X", req.Method)
+	}
+	args = append(args, shellQuote(curlURL(req, maskCredentials)))
+	if req.Host != "" && req.Host != req.URL.Host {
+		args = append(args, "-H", shellQuote("Host: "+req.Host))
+	}
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		if compressed && http.CanonicalHeaderKey(k) == "Content-Encoding" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+	switch {
+	case body != nil:
+		args = append(args, "--data-binary", shellQuote(string(body)))
+	case err == ErrBodyOmitted:
+		args = append(args, "--data-binary", "@-")
+	}
+	return strings.Join(args, " "), err
+}
+
+// CurlCommand returns a curl command line which reproduces the request that
+// caused the error. See the CurlCommand function for details. An error is
+// returned if the error carries no request.
+func (e *HTTPError) CurlCommand(maskCredentials bool) (string, error) {
+	if e.Response == nil || e.Response.Request == nil {
+		return "", errors.New("chttp: no request to reproduce")
+	}
+	return CurlCommand(e.Response.Request, maskCredentials)
+}
+
+// ErrBodyOmitted is returned by CurlCommand, along with a command which reads
+// the body from standard input, when the request body cannot be reproduced.
+var ErrBodyOmitted = errors.New("chttp: request body cannot be reproduced")
+
+// curlBody returns the body of req, decompressing it if it was compressed by
+// the client.
+func curlBody(req *http.Request) (body []byte, compressed bool, err error) {
+	if req.GetBody == nil {
+		if req.Body != nil && req.Body != http.NoBody {
+			return nil, false, ErrBodyOmitted
+		}
+		return nil, false, nil
+	}
+	r, err := req.GetBody()
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close() // nolint: errcheck
+	var src io.Reader = r
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, false, err
+		}
+		src, compressed = zr, true
+	}
+	body, err = ioutil.ReadAll(src)
+	return body, compressed, err
+}
+
+// curlURL returns the URL of req, with any password masked.
+func curlURL(req *http.Request, maskCredentials bool) string {
+	u := *req.URL
+	if u.User == nil || !maskCredentials {
+		return u.String()
+	}
+	userinfo := url.User(u.User.Username()).String()
+	if _, ok := u.User.Password(); ok {
+		userinfo += ":" + Redacted
+	}
+	u.User = nil
+	return strings.Replace(u.String(), "://", "://"+userinfo+"@", 1)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -26,6 +26,10 @@ const (
 	ExitPostError = 34
 	// When following redirects, curl hit the maximum amount.
 	ExitTooManyRedirects = 47
+	// Problem with the local certificate.
+	ExitSSLCertProblem = 58
+	// Problem with reading the SSL CA cert (path? access rights?).
+	ExitSSLCACertBadFile = 77
 
 /*
 5      Couldn't resolve proxy. The given proxy host could not be resolved.
@@ -42,7 +46,6 @@ const (
 54     Cannot set SSL crypto engine as default.
 55     Failed sending network data.
 56     Failure in receiving network data.
-58     Problem with the local certificate.
 59     Couldn't use specified SSL cipher.
 60     Peer certificate cannot be authenticated with known CA certificates.
 61     Unrecognized transfer encoding.
@@ -52,7 +55,6 @@ const (
 67     The user name, password, or similar was not accepted and curl failed to log in.
 75     Character conversion failed.
 76     Character conversion functions required.
-77     Problem with reading the SSL CA cert (path? access rights?).
 78     The resource referenced in the URL does not exist.
 80     Failed to shut down the SSL connection.
 82     Could not load CRL file, missing or wrong format (added in 7.19.0).
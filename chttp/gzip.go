@@ -0,0 +1,84 @@
+package chttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// DefaultGzipThreshold is the minimum size, in bytes, of request bodies to
+// compress, when compression is requested with Options.Gzip, and
+// Client.GzipThreshold is unset.
+const DefaultGzipThreshold = 1024
+
+// gzipThreshold returns the size above which the request body should be
+// compressed, or a non-positive value if it should not be compressed.
+func (c *Client) gzipThreshold(opts *Options) int {
+	if opts == nil || opts.ContentLength != 0 {
+		// The body size has been declared, and cannot be changed.
+		return 0
+	}
+	switch {
+	case opts.GzipThreshold != 0:
+		return opts.GzipThreshold
+	case c.GzipThreshold > 0:
+		return c.GzipThreshold
+	case opts.Gzip:
+		return DefaultGzipThreshold
+	}
+	return 0
+}
+
+// gzipBody reads up to threshold bytes from body. If body is shorter, its
+// content is returned uncompressed. Otherwise, the returned reader streams the
+// gzip-compressed content of body.
+func gzipBody(body io.Reader, threshold int) (_ io.Reader, compressed bool, _ error) {
+	buf := &bytes.Buffer{}
+	n, err := buf.ReadFrom(io.LimitReader(body, int64(threshold)))
+	if err != nil {
+		return nil, false, err
+	}
+	if n < int64(threshold) {
+		return buf, false, nil
+	}
+	return gzipReader(io.MultiReader(buf, body)), true, nil
+}
+
+// gzipReader returns a reader which streams the gzip-compressed content of
+// body.
+func gzipReader(body io.Reader) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(w)
+		_, err := io.Copy(gz, body)
+		if err == nil {
+			err = gz.Close()
+		}
+		_ = w.CloseWithError(err)
+	}()
+	return r
+}
+
+// gzipGetBody wraps getBody to return compressed bodies.
+func gzipGetBody(getBody func() (io.ReadCloser, error)) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		body, err := getBody()
+		if err != nil {
+			return nil, err
+		}
+		return &gzipReadCloser{ReadCloser: gzipReader(body), body: body}, nil
+	}
+}
+
+// gzipReadCloser closes the uncompressed body along with the compressed
+// stream.
+type gzipReadCloser struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (r *gzipReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	_ = r.body.Close()
+	return err
+}
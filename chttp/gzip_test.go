@@ -0,0 +1,141 @@
+package chttp
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestGzipBody(t *testing.T) {
+	type tt struct {
+		body       string
+		threshold  int
+		compressed bool
+	}
+
+	tests := testy.NewTable()
+	tests.Add("below threshold", tt{
+		body:      "foo",
+		threshold: 4,
+	})
+	tests.Add("at threshold", tt{
+		body:       "food",
+		threshold:  4,
+		compressed: true,
+	})
+	tests.Add("above threshold", tt{
+		body:       strings.Repeat("foo", 100),
+		threshold:  4,
+		compressed: true,
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		body, compressed, err := gzipBody(strings.NewReader(tt.body), tt.threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed != tt.compressed {
+			t.Errorf("Unexpected compressed: %t", compressed)
+		}
+		if compressed {
+			if body, err = gzip.NewReader(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		result, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(result) != tt.body {
+			t.Errorf("Unexpected body: %s", string(result))
+		}
+	})
+}
+
+func TestDoReqGzip(t *testing.T) {
+	type tt struct {
+		threshold  int
+		opts       *Options
+		compressed bool
+	}
+
+	large := strings.Repeat(`{"foo":"bar"}`, 100)
+	tests := testy.NewTable()
+	tests.Add("disabled", tt{
+		opts: &Options{GetBody: BodyEncoder(large)},
+	})
+	tests.Add("client threshold", tt{
+		threshold:  100,
+		opts:       &Options{GetBody: BodyEncoder(large)},
+		compressed: true,
+	})
+	tests.Add("small body", tt{
+		threshold: 100,
+		opts:      &Options{GetBody: BodyEncoder(`{}`)},
+	})
+	tests.Add("per-request threshold", tt{
+		opts:       &Options{GetBody: BodyEncoder(large), GzipThreshold: 100},
+		compressed: true,
+	})
+	tests.Add("per-request default threshold", tt{
+		opts:       &Options{GetBody: BodyEncoder(large), Gzip: true},
+		compressed: true,
+	})
+	tests.Add("disabled per-request", tt{
+		threshold: 100,
+		opts:      &Options{GetBody: BodyEncoder(large), GzipThreshold: -1},
+	})
+	tests.Add("declared length", tt{
+		threshold: 100,
+		opts:      &Options{Body: Body(large), ContentLength: int64(len(large))},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		var req *http.Request
+		var bodies []string
+		read := func(r *http.Request, body io.Reader) {
+			if r.Header.Get("Content-Encoding") == "gzip" {
+				var err error
+				if body, err = gzip.NewReader(body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			b, err := ioutil.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodies = append(bodies, string(b))
+		}
+		c := newCustomClient("http://example.com/", func(r *http.Request) (*http.Response, error) {
+			req = r
+			read(r, r.Body)
+			return &http.Response{StatusCode: http.StatusOK, Body: Body("")}, nil
+		})
+		c.GzipThreshold = tt.threshold
+		if _, err := c.DoError(context.Background(), http.MethodPost, "/", tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if compressed := req.Header.Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+			t.Errorf("Unexpected compression: %t", compressed)
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+			read(req, body)
+			_ = body.Close()
+		}
+		for _, body := range bodies {
+			if body != large && body != "{}" {
+				t.Errorf("Unexpected body: %s", body)
+			}
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package chttp
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenSource returns a JSON Web Token, and the time at which it expires. A
+// zero expiry indicates that the token does not expire.
+type TokenSource func(context.Context) (token string, expiry time.Time, err error)
+
+// DefaultJWTRefreshWindow is how long before its expiry a token is refreshed,
+// when JWTAuth.RefreshWindow is unset.
+const DefaultJWTRefreshWindow = 30 * time.Second
+
+// JWTAuth provides CouchDB JWT authentication, as described at
+// https://docs.couchdb.org/en/stable/api/server/authn.html#jwt-authentication
+//
+// The token is sent as a Bearer token in the Authorization header of every
+// request.
+type JWTAuth struct {
+	// Token is a static token. It is ignored if Source is set.
+	Token string
+
+	// Source, if set, is called to obtain a token. The token is cached, and
+	// Source is called again RefreshWindow before the token expires.
+	Source TokenSource
+
+	// RefreshWindow is how long before the token's expiry a new token is
+	// fetched from Source. Defaults to DefaultJWTRefreshWindow.
+	RefreshWindow time.Duration
+
+	// transport stores the original transport that is overridden by this auth
+	// mechanism
+	transport http.RoundTripper
+	client    *Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+var _ Authenticator = &JWTAuth{}
+
+// Authenticate sets JWT authentication for the client.
+func (a *JWTAuth) Authenticate(c *Client) error {
+	c.Transport = a.wrap(c, c.Transport)
+	return nil
+}
+
+func (a *JWTAuth) wrap(c *Client, rt http.RoundTripper) http.RoundTripper {
+	a.client = c
+	a.transport = rt
+	if a.transport == nil {
+		a.transport = http.DefaultTransport
+	}
+	return a
+}
+
+// RoundTrip fulfills the http.RoundTripper interface. It sets the
+// Authorization header on outbound requests, refreshing the token first if
+// necessary.
+func (a *JWTAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	if contextRequestAuth(req.Context()) != nil {
+		return a.transport.RoundTrip(req)
+	}
+	token, err := a.getToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.transport.RoundTrip(req)
+}
+
+func (a *JWTAuth) getToken(ctx context.Context) (string, error) {
+	if a.Source == nil {
+		return a.Token, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && !a.shouldRefresh() {
+		return a.token, nil
+	}
+	token, expiry, err := a.Source(ctx)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.expiry = token, expiry
+	a.client.authRefreshed("jwt")
+	return token, nil
+}
+
+func (a *JWTAuth) shouldRefresh() bool {
+	if a.expiry.IsZero() {
+		return false
+	}
+	window := a.RefreshWindow
+	if window <= 0 {
+		window = DefaultJWTRefreshWindow
+	}
+	return time.Now().Add(window).After(a.expiry)
+}
+
+// JWTClaims are the claims included in tokens signed locally by
+// HMACTokenSource or RSATokenSource.
+type JWTClaims struct {
+	// Subject is the CouchDB user name, sent as the "sub" claim.
+	Subject string
+
+	// Roles are the user's roles, sent in the RolesClaim claim.
+	Roles []string
+
+	// RolesClaim is the name of the claim used for roles. Defaults to
+	// "_couchdb.roles", the CouchDB default for jwt_auth/roles_claim_name.
+	RolesClaim string
+
+	// TTL is the validity period of each token, used to set the "exp" claim.
+	// If zero, tokens do not expire.
+	TTL time.Duration
+
+	// KeyID, if set, is sent as the "kid" header, to select among multiple
+	// keys configured on the server.
+	KeyID string
+
+	// Extra contains additional claims to include in each token.
+	Extra map[string]interface{}
+}
+
+// HMACTokenSource returns a TokenSource which signs tokens with key, using
+// the HS256 algorithm.
+func HMACTokenSource(key []byte, claims JWTClaims) TokenSource {
+	return claims.source("HS256", func(data []byte) ([]byte, error) {
+		h := hmac.New(sha256.New, key)
+		_, _ = h.Write(data)
+		return h.Sum(nil), nil
+	})
+}
+
+// RSATokenSource returns a TokenSource which signs tokens with key, using the
+// RS256 algorithm.
+func RSATokenSource(key *rsa.PrivateKey, claims JWTClaims) TokenSource {
+	return claims.source("RS256", func(data []byte) ([]byte, error) {
+		sum := sha256.Sum256(data)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	})
+}
+
+func (c JWTClaims) source(alg string, sign func([]byte) ([]byte, error)) TokenSource {
+	return func(_ context.Context) (string, time.Time, error) {
+		now := time.Now()
+		var expiry time.Time
+		if c.TTL > 0 {
+			expiry = now.Add(c.TTL)
+		}
+		token, err := signJWT(alg, c.KeyID, c.payload(now, expiry), sign)
+		return token, expiry, err
+	}
+}
+
+func (c JWTClaims) payload(now, expiry time.Time) map[string]interface{} {
+	payload := make(map[string]interface{}, len(c.Extra)+4)
+	for k, v := range c.Extra {
+		payload[k] = v
+	}
+	payload["sub"] = c.Subject
+	payload["iat"] = now.Unix()
+	if !expiry.IsZero() {
+		payload["exp"] = expiry.Unix()
+	}
+	if c.Roles != nil {
+		rolesClaim := c.RolesClaim
+		if rolesClaim == "" {
+			rolesClaim = "_couchdb.roles"
+		}
+		payload[rolesClaim] = c.Roles
+	}
+	return payload
+}
+
+func signJWT(alg, keyID string, payload map[string]interface{}, sign func([]byte) ([]byte, error)) (string, error) {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(headerJSON) + "." + enc.EncodeToString(payloadJSON)
+	sig, err := sign([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
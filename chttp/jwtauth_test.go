@@ -0,0 +1,173 @@
+package chttp
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestJWTAuthRoundTrip(t *testing.T) {
+	type tt struct {
+		auth     *JWTAuth
+		requests int
+		expected []string
+		err      string
+	}
+	tests := testy.NewTable()
+	tests.Add("static token", tt{
+		auth:     &JWTAuth{Token: "abc"},
+		requests: 2,
+		expected: []string{"Bearer abc", "Bearer abc"},
+	})
+	tests.Add("source without expiry", func() interface{} {
+		var calls int
+		return tt{
+			auth: &JWTAuth{Source: func(_ context.Context) (string, time.Time, error) {
+				calls++
+				return "token" + string(rune('0'+calls)), time.Time{}, nil
+			}},
+			requests: 2,
+			expected: []string{"Bearer token1", "Bearer token1"},
+		}
+	})
+	tests.Add("source with expiry", func() interface{} {
+		var calls int
+		return tt{
+			auth: &JWTAuth{
+				RefreshWindow: time.Minute,
+				Source: func(_ context.Context) (string, time.Time, error) {
+					calls++
+					// Always within the refresh window
+					return "token" + string(rune('0'+calls)), time.Now().Add(time.Second), nil
+				},
+			},
+			requests: 2,
+			expected: []string{"Bearer token1", "Bearer token2"},
+		}
+	})
+	tests.Add("source error", tt{
+		auth: &JWTAuth{Source: func(_ context.Context) (string, time.Time, error) {
+			return "", time.Time{}, errors.New("no token for you")
+		}},
+		requests: 1,
+		err:      "no token for you",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		var result []string
+		tt.auth.transport = customTransport(func(r *http.Request) (*http.Response, error) {
+			result = append(result, r.Header.Get("Authorization"))
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		})
+		for i := 0; i < tt.requests; i++ {
+			_, err := tt.auth.RoundTrip(httptest.NewRequest("GET", "/", nil))
+			testy.Error(t, tt.err, err)
+		}
+		if d := testy.DiffInterface(tt.expected, result); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func decodeJWT(t *testing.T, token string) (header, payload map[string]interface{}, signed string, sig []byte) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Malformed token: %s", token)
+	}
+	enc := base64.RawURLEncoding
+	for i, target := range []*map[string]interface{}{&header, &payload} {
+		data, err := enc.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header, payload, parts[0] + "." + parts[1], sig
+}
+
+func TestHMACTokenSource(t *testing.T) {
+	key := []byte("secret")
+	src := HMACTokenSource(key, JWTClaims{
+		Subject: "bob",
+		Roles:   []string{"users"},
+		TTL:     time.Hour,
+		KeyID:   "key1",
+	})
+	token, expiry, err := src(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiry) < 59*time.Minute {
+		t.Errorf("Unexpected expiry: %s", expiry)
+	}
+	header, payload, signed, sig := decodeJWT(t, token)
+	if d := testy.DiffInterface(map[string]interface{}{"alg": "HS256", "typ": "JWT", "kid": "key1"}, header); d != nil {
+		t.Error(d)
+	}
+	if payload["sub"] != "bob" {
+		t.Errorf("Unexpected sub claim: %v", payload["sub"])
+	}
+	if d := testy.DiffInterface([]interface{}{"users"}, payload["_couchdb.roles"]); d != nil {
+		t.Error(d)
+	}
+	if exp, _ := payload["exp"].(float64); int64(exp) != expiry.Unix() {
+		t.Errorf("Unexpected exp claim: %v", payload["exp"])
+	}
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(signed))
+	if !hmac.Equal(h.Sum(nil), sig) {
+		t.Error("Invalid signature")
+	}
+}
+
+func TestRSATokenSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := RSATokenSource(key, JWTClaims{
+		Subject:    "bob",
+		Roles:      []string{"admins"},
+		RolesClaim: "roles",
+	})
+	token, expiry, err := src(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expiry.IsZero() {
+		t.Errorf("Unexpected expiry: %s", expiry)
+	}
+	header, payload, signed, sig := decodeJWT(t, token)
+	if header["alg"] != "RS256" {
+		t.Errorf("Unexpected alg: %v", header["alg"])
+	}
+	if _, ok := payload["exp"]; ok {
+		t.Error("Unexpected exp claim")
+	}
+	if d := testy.DiffInterface([]interface{}{"admins"}, payload["roles"]); d != nil {
+		t.Error(d)
+	}
+	sum := sha256.Sum256([]byte(signed))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Error(err)
+	}
+}
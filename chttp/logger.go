@@ -0,0 +1,277 @@
+package chttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+// Log levels, in increasing order of severity.
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Logger receives structured log entries, and may be set as Client.Logger.
+// keyvals contains alternating keys, which are strings, and values. Methods
+// may be called concurrently.
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, msg string, keyvals ...interface{})
+}
+
+// LoggerFunc is an adaptor to allow the use of an ordinary function as a
+// Logger.
+type LoggerFunc func(ctx context.Context, level LogLevel, msg string, keyvals ...interface{})
+
+var _ Logger = LoggerFunc(nil)
+
+// Log calls f(ctx, level, msg, keyvals...).
+func (f LoggerFunc) Log(ctx context.Context, level LogLevel, msg string, keyvals ...interface{}) {
+	f(ctx, level, msg, keyvals...)
+}
+
+// Redacted replaces secrets in logged headers and bodies.
+const Redacted = "[REDACTED]"
+
+// redactedHeaders are the headers whose values are never logged.
+var redactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Auth-CouchDB-Token",
+}
+
+// headerRedactor is implemented by authenticators which send credentials in
+// headers other than those always redacted, such as a ProxyAuth with custom
+// header names.
+type headerRedactor interface {
+	redactedHeaders() []string
+}
+
+var redactedHeadersContextKey = &struct{ name string }{"redacted headers"}
+
+// withRedactedHeaders returns a context in which names are redacted, in
+// addition to those of any parent context.
+func withRedactedHeaders(ctx context.Context, names ...string) context.Context {
+	if len(names) == 0 {
+		return ctx
+	}
+	parent := RedactedHeaders(ctx)
+	all := make([]string, 0, len(parent)+len(names))
+	all = append(append(all, parent...), names...)
+	return context.WithValue(ctx, redactedHeadersContextKey, all)
+}
+
+// RedactedHeaders returns the names of the headers carrying the credentials
+// of the authenticator used for requests made with ctx, which are not among
+// the headers always redacted by RedactHeader, such as the token header of a
+// ProxyAuth with custom header names. The client redacts them in logs, and
+// CurlCommand redacts those of the request's context.
+func RedactedHeaders(ctx context.Context) []string {
+	names, _ := ctx.Value(redactedHeadersContextKey).([]string)
+	return names
+}
+
+// RedactHeader returns a copy of h, with the values of the Authorization,
+// Proxy-Authorization, Cookie, Set-Cookie and X-Auth-CouchDB-Token headers,
+// and of any headers listed in names, replaced by Redacted.
+func RedactHeader(h http.Header, names ...string) http.Header {
+	clone := make(http.Header, len(h))
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	for _, k := range append(redactedHeaders, names...) {
+		k = http.CanonicalHeaderKey(k)
+		if values := clone[k]; len(values) > 0 {
+			redacted := make([]string, len(values))
+			for i := range redacted {
+				redacted[i] = Redacted
+			}
+			clone[k] = redacted
+		}
+	}
+	return clone
+}
+
+var (
+	passwordJSONRE = regexp.MustCompile(`("password"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+	passwordFormRE = regexp.MustCompile(`(^|&)(password=)[^&]*`)
+)
+
+// RedactBody replaces the password in body, the JSON or form-encoded body of
+// a request to path, with Redacted, if path is the /_session endpoint. Other
+// bodies are returned unaltered.
+func RedactBody(path string, body []byte) []byte {
+	if !strings.HasSuffix(strings.TrimSuffix(path, "/"), "/_session") {
+		return body
+	}
+	body = passwordJSONRE.ReplaceAll(body, []byte(`$1"`+Redacted+`"`))
+	return passwordFormRE.ReplaceAll(body, []byte(`$1$2`+Redacted))
+}
+
+// bodyCapture retains up to limit bytes read from a body.
+type bodyCapture struct {
+	io.ReadCloser
+	limit     int
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func newBodyCapture(body io.ReadCloser, limit int) *bodyCapture {
+	return &bodyCapture{ReadCloser: body, limit: limit}
+}
+
+func (b *bodyCapture) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room < n {
+		_, _ = b.buf.Write(p[:room])
+		b.truncated = true
+	} else {
+		_, _ = b.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// String returns the captured body, with secrets redacted from requests to
+// path.
+func (b *bodyCapture) String(path string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	body := string(RedactBody(path, b.buf.Bytes()))
+	if b.truncated {
+		body += "... [truncated]"
+	}
+	return body
+}
+
+// requestLog accumulates the details of a single request, to be logged.
+type requestLog struct {
+	logger  Logger
+	headers bool
+	start   time.Time
+	reqBody *bodyCapture
+	limit   int
+}
+
+// startLog prepares to log req, capturing its body if configured.
+func (c *Client) startLog(req *http.Request) *requestLog {
+	if c.Logger == nil {
+		return nil
+	}
+	l := &requestLog{
+		logger:  c.Logger,
+		headers: c.LogHeaders,
+		limit:   c.LogBodyLimit,
+		start:   time.Now(),
+	}
+	// Compressed bodies are not captured, as they are not human readable.
+	if l.limit > 0 && req.Body != nil && req.Body != http.NoBody && req.Header.Get("Content-Encoding") == "" {
+		l.reqBody = newBodyCapture(req.Body, l.limit)
+		req.Body = l.reqBody
+	}
+	return l
+}
+
+// finish logs the result of req. If response bodies are logged, a second
+// entry is logged when the body of res is closed.
+func (l *requestLog) finish(req *http.Request, res *http.Response, err error) {
+	if l == nil {
+		return
+	}
+	if res != nil && res.Request != nil {
+		// Reflects headers set by the transport, such as authentication
+		req = res.Request
+	}
+	path := req.URL.Path
+	keyvals := []interface{}{
+		"method", req.Method,
+		"url", logURL(req),
+		"duration", time.Since(l.start),
+	}
+	level := LevelInfo
+	switch {
+	case err != nil:
+		level = LevelError
+		keyvals = append(keyvals, "error", err)
+	case res.StatusCode >= http.StatusInternalServerError:
+		level = LevelWarn
+	}
+	if res != nil {
+		keyvals = append(keyvals, "status", res.StatusCode)
+	}
+	if l.headers {
+		redacted := RedactedHeaders(req.Context())
+		keyvals = append(keyvals, "request_headers", RedactHeader(req.Header, redacted...))
+		if res != nil {
+			keyvals = append(keyvals, "response_headers", RedactHeader(res.Header, redacted...))
+		}
+	}
+	if l.reqBody != nil {
+		keyvals = append(keyvals, "request_body", l.reqBody.String(path))
+	}
+	l.logger.Log(req.Context(), level, "couchdb request", keyvals...)
+
+	if l.limit > 0 && res != nil && res.Body != nil && res.Body != http.NoBody {
+		res.Body = &loggedBody{
+			bodyCapture: newBodyCapture(res.Body, l.limit),
+			log: func(body string) {
+				l.logger.Log(req.Context(), LevelDebug, "couchdb response body",
+					"method", req.Method,
+					"url", logURL(req),
+					"status", res.StatusCode,
+					"response_body", body,
+				)
+			},
+		}
+	}
+}
+
+// logURL returns the URL of req, without any user info.
+func logURL(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	return u.String()
+}
+
+// loggedBody logs the captured body when it is closed.
+type loggedBody struct {
+	*bodyCapture
+	log  func(string)
+	once sync.Once
+}
+
+func (b *loggedBody) Close() error {
+	err := b.bodyCapture.Close()
+	b.once.Do(func() {
+		b.log(b.bodyCapture.String(""))
+	})
+	return err
+}
@@ -0,0 +1,194 @@
+package chttp
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives measurements of the requests made by a client, and may be
+// set as Client.Metrics. Methods may be called concurrently.
+type Metrics interface {
+	// RequestStarted is called when DoReq is called.
+	RequestStarted(method, endpoint string)
+
+	// RequestFinished is called when the request is complete. If a response
+	// was received, this is when its body is closed.
+	RequestFinished(RequestMetrics)
+
+	// AuthRefreshed is called when an authenticator obtains new credentials,
+	// such as a new session cookie or JSON Web Token. mechanism is "cookie"
+	// or "jwt".
+	AuthRefreshed(mechanism string)
+}
+
+// RequestMetrics describes a completed request. Retries and resubmissions of
+// a request are included in a single RequestMetrics.
+type RequestMetrics struct {
+	// Method is the HTTP method.
+	Method string
+
+	// Endpoint is the class of endpoint requested, as returned by
+	// EndpointClass.
+	Endpoint string
+
+	// Status is the HTTP status code of the final response, or 0 if no
+	// response was received.
+	Status int
+
+	// ExitStatus is the curl exit status of the error returned by DoReq, or 0
+	// on success.
+	ExitStatus int
+
+	// Duration is the time from the start of the request until the final
+	// response headers were received.
+	Duration time.Duration
+
+	// BytesSent is the number of request body bytes sent, including retries.
+	BytesSent int64
+
+	// BytesReceived is the number of response body bytes read by the caller.
+	BytesReceived int64
+
+	// Retries is the number of times the request was retried or resubmitted.
+	Retries int
+}
+
+// EndpointClass returns a low-cardinality description of the endpoint
+// requested by path, which is relative to the client's DSN. Special endpoints,
+// such as "_bulk_docs", "_find", "_changes" or "_view", are returned as is.
+// Otherwise, the result is one of "root", "db", "doc" or "attachment".
+func EndpointClass(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "root"
+	}
+	segments := strings.Split(path, "/")
+	if strings.HasPrefix(segments[0], "_") {
+		return segments[0]
+	}
+	if len(segments) == 1 {
+		return "db"
+	}
+	rest := segments[1:]
+	if rest[0] == "_design" || rest[0] == "_local" {
+		if len(rest) < 2 {
+			return rest[0]
+		}
+		// Treat the prefix and ID as a single segment
+		rest = rest[1:]
+	} else if strings.HasPrefix(rest[0], "_") {
+		return rest[0]
+	}
+	switch {
+	case len(rest) == 1:
+		return "doc"
+	case strings.HasPrefix(rest[1], "_"):
+		return rest[1]
+	}
+	return "attachment"
+}
+
+// meter accumulates the metrics of a single call to DoReq.
+type meter struct {
+	metrics Metrics
+	start   time.Time
+	m       RequestMetrics
+	sent    int64 // accessed atomically
+}
+
+func (c *Client) startMeter(method, path string) *meter {
+	if c.Metrics == nil {
+		return nil
+	}
+	m := &meter{
+		metrics: c.Metrics,
+		start:   time.Now(),
+		m: RequestMetrics{
+			Method:   method,
+			Endpoint: EndpointClass(path),
+		},
+	}
+	c.Metrics.RequestStarted(m.m.Method, m.m.Endpoint)
+	return m
+}
+
+// countBody wraps the body of req to count the bytes sent.
+func (m *meter) countBody(req *http.Request) {
+	if m == nil || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	req.Body = &countingReader{ReadCloser: req.Body, n: &m.sent}
+}
+
+// finish records the result of the request. If res has a body, the metrics
+// are reported when it is closed.
+func (m *meter) finish(res *http.Response, retries int, err error) (*http.Response, error) {
+	if m == nil {
+		return res, err
+	}
+	m.m.Duration = time.Since(m.start)
+	m.m.Retries = retries
+	m.m.ExitStatus = ExitStatus(err)
+	if res != nil {
+		m.m.Status = res.StatusCode
+	}
+	if err != nil || res == nil || res.Body == nil {
+		m.report(0)
+		return res, err
+	}
+	res.Body = &meteredBody{ReadCloser: res.Body, meter: m}
+	return res, nil
+}
+
+func (m *meter) report(received int64) {
+	m.m.BytesSent = atomic.LoadInt64(&m.sent)
+	m.m.BytesReceived = received
+	m.metrics.RequestFinished(m.m)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// meteredBody reports the request metrics when the response body is closed.
+type meteredBody struct {
+	io.ReadCloser
+	meter    *meter
+	received int64
+	once     sync.Once
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.received, int64(n))
+	return n, err
+}
+
+func (b *meteredBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.meter.report(atomic.LoadInt64(&b.received))
+	})
+	return err
+}
+
+// authRefreshed reports that an authenticator obtained new credentials.
+func (c *Client) authRefreshed(mechanism string) {
+	if c != nil && c.Metrics != nil {
+		c.Metrics.AuthRefreshed(mechanism)
+	}
+}
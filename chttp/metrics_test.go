@@ -0,0 +1,183 @@
+package chttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+type testMetrics struct {
+	mu       sync.Mutex
+	started  []string
+	finished []RequestMetrics
+	auth     []string
+}
+
+var _ Metrics = &testMetrics{}
+
+func (m *testMetrics) RequestStarted(method, endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = append(m.started, method+" "+endpoint)
+}
+
+func (m *testMetrics) RequestFinished(r RequestMetrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.Duration = 0
+	m.finished = append(m.finished, r)
+}
+
+func (m *testMetrics) AuthRefreshed(mechanism string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auth = append(m.auth, mechanism)
+}
+
+func TestEndpointClass(t *testing.T) {
+	tests := map[string]string{
+		"":                              "root",
+		"/":                             "root",
+		"/_all_dbs":                     "_all_dbs",
+		"/_session?basic=true":          "_session",
+		"/_node/_local/_config":         "_node",
+		"/db":                           "db",
+		"db/":                           "db",
+		"/db/_bulk_docs":                "_bulk_docs",
+		"/db/_find":                     "_find",
+		"/db/_changes?feed=continuous":  "_changes",
+		"/db/_design":                   "_design",
+		"/db/doc":                       "doc",
+		"/db/doc/att.txt":               "attachment",
+		"/db/doc/foo/bar.txt":           "attachment",
+		"/db/_design/foo":               "doc",
+		"/db/_design/foo/_view/bar":     "_view",
+		"/db/_design/foo/att.txt":       "attachment",
+		"/db/_local/checkpoint":         "doc",
+		"/db/_design/foo/_update/bar/x": "_update",
+	}
+	for path, expected := range tests {
+		if result := EndpointClass(path); result != expected {
+			t.Errorf("%q: expected %q, got %q", path, expected, result)
+		}
+	}
+}
+
+func TestDoReqMetrics(t *testing.T) {
+	type tt struct {
+		client   *Client
+		method   string
+		path     string
+		opts     *Options
+		read     bool
+		expected []RequestMetrics
+	}
+
+	tests := testy.NewTable()
+	tests.Add("success", tt{
+		client: newCustomClient("", func(r *http.Request) (*http.Response, error) {
+			_, _ = ioutil.ReadAll(r.Body)
+			return &http.Response{StatusCode: http.StatusCreated, Body: Body(`{"ok":true}`)}, nil
+		}),
+		method: http.MethodPost,
+		path:   "/db/_bulk_docs",
+		opts:   &Options{GetBody: BodyEncoder(map[string]interface{}{"docs": []string{}})},
+		read:   true,
+		expected: []RequestMetrics{{
+			Method:        http.MethodPost,
+			Endpoint:      "_bulk_docs",
+			Status:        http.StatusCreated,
+			BytesSent:     12,
+			BytesReceived: 11,
+		}},
+	})
+	tests.Add("retried", func() interface{} {
+		var calls int
+		c := newCustomClient("", func(r *http.Request) (*http.Response, error) {
+			calls++
+			if calls < 3 {
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: Body("")}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: Body("")}, nil
+		})
+		c.RetryPolicy = &RetryPolicy{MinDelay: time.Millisecond}
+		return tt{
+			client: c,
+			method: http.MethodGet,
+			path:   "/db/doc",
+			expected: []RequestMetrics{{
+				Method:   http.MethodGet,
+				Endpoint: "doc",
+				Status:   http.StatusOK,
+				Retries:  2,
+			}},
+		}
+	})
+	tests.Add("network error", tt{
+		client: newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+		}),
+		method: http.MethodGet,
+		path:   "/db/_changes",
+		expected: []RequestMetrics{{
+			Method:     http.MethodGet,
+			Endpoint:   "_changes",
+			ExitStatus: ExitFailedToConnect,
+		}},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		m := &testMetrics{}
+		tt.client.Metrics = m
+		res, err := tt.client.DoReq(context.Background(), tt.method, tt.path, tt.opts)
+		if err == nil {
+			if len(m.finished) != 0 {
+				t.Error("Metrics reported before the response body was closed")
+			}
+			if tt.read {
+				_, _ = ioutil.ReadAll(res.Body)
+			}
+			_ = res.Body.Close()
+			_ = res.Body.Close()
+		}
+		if d := testy.DiffInterface([]string{tt.method + " " + tt.expected[0].Endpoint}, m.started); d != nil {
+			t.Error(d)
+		}
+		if d := testy.DiffInterface(tt.expected, m.finished); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestAuthRefreshedMetrics(t *testing.T) {
+	m := &testMetrics{}
+	c := newCustomClient("", func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: Body("")}, nil
+	})
+	c.Metrics = m
+	auth := &JWTAuth{
+		RefreshWindow: time.Minute,
+		Source: func(_ context.Context) (string, time.Time, error) {
+			return "token", time.Now().Add(time.Second), nil
+		},
+	}
+	if err := auth.Authenticate(c); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := testy.DiffInterface([]string{"jwt", "jwt"}, m.auth); d != nil {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,198 @@
+package chttp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NodeStrategy determines how requests are distributed among the nodes of a
+// client connected to multiple CouchDB cluster members.
+type NodeStrategy int
+
+// Supported node selection strategies.
+const (
+	// RoundRobin sends each request to the next available node in turn.
+	RoundRobin NodeStrategy = iota
+	// LeastOutstanding sends each request to the available node with the
+	// fewest requests in flight.
+	LeastOutstanding
+)
+
+// DefaultHealthCheckInterval is the minimum time between health probes of a
+// node which has been marked down, when Client.HealthCheckInterval is unset.
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// node is a single member of a CouchDB cluster.
+type node struct {
+	url *url.URL
+
+	// outstanding is the number of requests in flight, and must be accessed
+	// atomically.
+	outstanding int64
+
+	mu      sync.Mutex
+	down    bool
+	checked time.Time
+	probing bool
+}
+
+// splitDSN splits a comma-separated list of node URLs. A comma is only
+// treated as a separator when it is followed by an explicit http:// or
+// https:// scheme, so that commas within a single URL, such as in a
+// password, are preserved.
+func splitDSN(dsn string) []string {
+	parts := strings.Split(dsn, ",")
+	result := parts[:1]
+	for _, part := range parts[1:] {
+		if strings.HasPrefix(part, "http://") || strings.HasPrefix(part, "https://") {
+			result = append(result, part)
+			continue
+		}
+		result[len(result)-1] += "," + part
+	}
+	return result
+}
+
+// parseNodes parses the additional node URLs of a multi-node DSN. The primary
+// node is the first element of the returned slice.
+func parseNodes(primary *url.URL, dsns []string) ([]*node, error) {
+	nodes := []*node{{url: primary}}
+	for _, dsn := range dsns {
+		u, err := parseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		u.User = nil
+		nodes = append(nodes, &node{url: u})
+	}
+	return nodes, nil
+}
+
+// nodeURLs returns the base URL of each node known to the client.
+func (c *Client) nodeURLs() []*url.URL {
+	if len(c.nodes) == 0 {
+		return []*url.URL{c.dsn}
+	}
+	urls := make([]*url.URL, len(c.nodes))
+	for i, n := range c.nodes {
+		urls[i] = n.url
+	}
+	return urls
+}
+
+// baseURL returns the base URL of the node to which the next request should be
+// sent.
+func (c *Client) baseURL() *url.URL {
+	if n := c.pickNode(); n != nil {
+		return n.url
+	}
+	return c.dsn
+}
+
+// pickNode selects an available node according to c.NodeStrategy. If all
+// nodes are down, one is chosen anyway, so that the caller receives a
+// meaningful error. It returns nil if the client has only one node.
+func (c *Client) pickNode() *node {
+	count := len(c.nodes)
+	if count < 2 {
+		return nil
+	}
+	now := time.Now()
+	start := int(atomic.AddUint32(&c.nextNode, 1) - 1)
+	var best *node
+	for i := 0; i < count; i++ {
+		n := c.nodes[(start+i)%count]
+		if !c.available(n, now) {
+			continue
+		}
+		if c.NodeStrategy != LeastOutstanding {
+			return n
+		}
+		if best == nil || atomic.LoadInt64(&n.outstanding) < atomic.LoadInt64(&best.outstanding) {
+			best = n
+		}
+	}
+	if best == nil {
+		return c.nodes[start%count]
+	}
+	return best
+}
+
+// nodeFor returns the node whose URL matches u, or nil.
+func (c *Client) nodeFor(u *url.URL) *node {
+	for _, n := range c.nodes {
+		if n.url.Host == u.Host && n.url.Scheme == u.Scheme {
+			return n
+		}
+	}
+	return nil
+}
+
+func (c *Client) healthCheckInterval() time.Duration {
+	if c.HealthCheckInterval > 0 {
+		return c.HealthCheckInterval
+	}
+	return DefaultHealthCheckInterval
+}
+
+// available returns true if n is not marked down. If it is down, and has not
+// been checked recently, a health probe is started in the background.
+func (c *Client) available(n *node, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.down {
+		return true
+	}
+	if !n.probing && now.Sub(n.checked) >= c.healthCheckInterval() {
+		n.probing = true
+		go c.probe(n)
+	}
+	return false
+}
+
+func (n *node) setDown(down bool) {
+	n.mu.Lock()
+	n.down = down
+	n.checked = time.Now()
+	n.mu.Unlock()
+}
+
+// probe issues HEAD /_up to n, the same request used by Ping, and marks the
+// node up if it responds without a network error or 5xx status.
+func (c *Client) probe(n *node) {
+	defer func() {
+		n.mu.Lock()
+		n.probing = false
+		n.mu.Unlock()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), c.healthCheckInterval())
+	defer cancel()
+	// The probe is never authenticated, to avoid recursing into CookieAuth.
+	ctx = context.WithValue(ctx, authInProgress, true)
+	u := *n.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/_up"
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Add("User-Agent", c.userAgent())
+	res, err := c.Do(req.WithContext(ctx))
+	up := err == nil && res.StatusCode < http.StatusInternalServerError
+	discardResponse(res)
+	n.setDown(!up)
+}
+
+// connectFailure returns true if err indicates that the request never reached
+// the server, so the node should be marked down.
+func connectFailure(err error) bool {
+	switch ExitStatus(err) {
+	case ExitFailedToConnect, ExitHostNotResolved:
+		return true
+	}
+	return false
+}
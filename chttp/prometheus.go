@@ -0,0 +1,211 @@
+package chttp
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultPrometheusBuckets are the upper bounds, in seconds, of the request
+// duration histogram exported by PrometheusMetrics.
+var DefaultPrometheusBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics is a Metrics implementation which aggregates request
+// metrics in memory, and serves them in the Prometheus text exposition format.
+// The zero value is ready to use.
+//
+// Example:
+//
+//     metrics := &chttp.PrometheusMetrics{}
+//     client.Metrics = metrics
+//     http.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	// Namespace is prepended to each metric name. Defaults to "couchdb".
+	Namespace string
+
+	// Buckets are the upper bounds, in seconds, of the request duration
+	// histogram. Defaults to DefaultPrometheusBuckets. Buckets are read when
+	// the first request finishes; later changes are ignored.
+	Buckets []float64
+
+	mu       sync.Mutex
+	bounds   []float64
+	inFlight map[promLabels]float64
+	requests map[promLabels]float64
+	errors   map[promLabels]float64
+	sent     map[promLabels]float64
+	received map[promLabels]float64
+	retries  map[promLabels]float64
+	auth     map[promLabels]float64
+	duration map[promLabels]*promHistogram
+}
+
+var (
+	_ Metrics      = &PrometheusMetrics{}
+	_ http.Handler = &PrometheusMetrics{}
+)
+
+// promLabels holds a set of label pairs, already formatted for output.
+type promLabels string
+
+func newPromLabels(pairs ...string) promLabels {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	return promLabels(strings.Join(parts, ","))
+}
+
+// labelEscaper escapes label values as required by the text exposition
+// format, which unlike Go string literals only escapes backslash, double
+// quote and line feed.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type promHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func inc(m *map[promLabels]float64, l promLabels, v float64) {
+	if *m == nil {
+		*m = make(map[promLabels]float64)
+	}
+	(*m)[l] += v
+}
+
+// RequestStarted satisfies the Metrics interface.
+func (p *PrometheusMetrics) RequestStarted(method, endpoint string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	inc(&p.inFlight, newPromLabels("method", method, "endpoint", endpoint), 1)
+}
+
+// RequestFinished satisfies the Metrics interface.
+func (p *PrometheusMetrics) RequestFinished(m RequestMetrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	labels := newPromLabels("method", m.Method, "endpoint", m.Endpoint)
+	inc(&p.inFlight, labels, -1)
+	code := "none"
+	if m.Status != 0 {
+		code = strconv.Itoa(m.Status)
+	}
+	inc(&p.requests, newPromLabels("method", m.Method, "endpoint", m.Endpoint, "code", code), 1)
+	if m.ExitStatus != 0 {
+		inc(&p.errors, newPromLabels("method", m.Method, "endpoint", m.Endpoint, "exit_status", strconv.Itoa(m.ExitStatus)), 1)
+	}
+	inc(&p.sent, labels, float64(m.BytesSent))
+	inc(&p.received, labels, float64(m.BytesReceived))
+	inc(&p.retries, labels, float64(m.Retries))
+
+	if p.duration == nil {
+		p.duration = make(map[promLabels]*promHistogram)
+	}
+	h, ok := p.duration[labels]
+	if !ok {
+		h = &promHistogram{counts: make([]uint64, len(p.buckets()))}
+		p.duration[labels] = h
+	}
+	seconds := m.Duration.Seconds()
+	for i, le := range p.buckets() {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// AuthRefreshed satisfies the Metrics interface.
+func (p *PrometheusMetrics) AuthRefreshed(mechanism string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	inc(&p.auth, newPromLabels("mechanism", mechanism), 1)
+}
+
+// buckets returns the histogram bounds, copying them on first use, so that
+// changes to p.Buckets cannot invalidate existing histograms. p.mu must be
+// held.
+func (p *PrometheusMetrics) buckets() []float64 {
+	if p.bounds == nil {
+		bounds := p.Buckets
+		if len(bounds) == 0 {
+			bounds = DefaultPrometheusBuckets
+		}
+		p.bounds = append([]float64(nil), bounds...)
+	}
+	return p.bounds
+}
+
+func (p *PrometheusMetrics) name(suffix string) string {
+	ns := p.Namespace
+	if ns == "" {
+		ns = "couchdb"
+	}
+	return ns + "_" + suffix
+}
+
+// ServeHTTP serves the collected metrics in the Prometheus text exposition
+// format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf := bufio.NewWriter(w)
+	p.writeTo(buf)
+	_ = buf.Flush()
+}
+
+func (p *PrometheusMetrics) writeTo(w *bufio.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	writeFamily(w, p.name("requests_in_flight"), "gauge", "Number of requests in progress.", p.inFlight)
+	writeFamily(w, p.name("requests_total"), "counter", "Number of completed requests, by HTTP status code.", p.requests)
+	writeFamily(w, p.name("request_errors_total"), "counter", "Number of failed requests, by curl exit status.", p.errors)
+	writeFamily(w, p.name("request_bytes_sent_total"), "counter", "Request body bytes sent, including retries.", p.sent)
+	writeFamily(w, p.name("response_bytes_received_total"), "counter", "Response body bytes read.", p.received)
+	writeFamily(w, p.name("request_retries_total"), "counter", "Number of request retries and resubmissions.", p.retries)
+	writeFamily(w, p.name("auth_refreshes_total"), "counter", "Number of times new credentials were obtained.", p.auth)
+
+	name := p.name("request_duration_seconds")
+	fmt.Fprintf(w, "# HELP %s Time until response headers were received.\n# TYPE %s histogram\n", name, name)
+	for _, l := range sortedLabels(p.duration) {
+		h := p.duration[l]
+		for i, le := range p.buckets() {
+			fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, l, formatFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, l, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, l, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, l, h.count)
+	}
+}
+
+func writeFamily(w *bufio.Writer, name, kind, help string, values map[promLabels]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, l := range sortedLabels(values) {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, l, formatFloat(values[l]))
+	}
+}
+
+func sortedLabels(m interface{}) []promLabels {
+	var labels []promLabels
+	switch t := m.(type) {
+	case map[promLabels]float64:
+		for l := range t {
+			labels = append(labels, l)
+		}
+	case map[promLabels]*promHistogram:
+		for l := range t {
+			labels = append(labels, l)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i] < labels[j] })
+	return labels
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
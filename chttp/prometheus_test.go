@@ -0,0 +1,95 @@
+package chttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	p := &PrometheusMetrics{Buckets: []float64{0.1, 1}}
+	p.RequestStarted(http.MethodGet, "doc")
+	p.RequestStarted(http.MethodPost, "_bulk_docs")
+	p.RequestFinished(RequestMetrics{
+		Method:        http.MethodPost,
+		Endpoint:      "_bulk_docs",
+		Status:        http.StatusCreated,
+		Duration:      500 * time.Millisecond,
+		BytesSent:     100,
+		BytesReceived: 20,
+		Retries:       1,
+	})
+	p.RequestStarted(http.MethodPost, "_bulk_docs")
+	p.RequestFinished(RequestMetrics{
+		Method:     http.MethodPost,
+		Endpoint:   "_bulk_docs",
+		ExitStatus: ExitFailedToConnect,
+		Duration:   50 * time.Millisecond,
+	})
+	p.AuthRefreshed("cookie")
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Unexpected Content-Type: %s", ct)
+	}
+	expected := `# HELP couchdb_requests_in_flight Number of requests in progress.
+# TYPE couchdb_requests_in_flight gauge
+couchdb_requests_in_flight{method="GET",endpoint="doc"} 1
+couchdb_requests_in_flight{method="POST",endpoint="_bulk_docs"} 0
+# HELP couchdb_requests_total Number of completed requests, by HTTP status code.
+# TYPE couchdb_requests_total counter
+couchdb_requests_total{method="POST",endpoint="_bulk_docs",code="201"} 1
+couchdb_requests_total{method="POST",endpoint="_bulk_docs",code="none"} 1
+# HELP couchdb_request_errors_total Number of failed requests, by curl exit status.
+# TYPE couchdb_request_errors_total counter
+couchdb_request_errors_total{method="POST",endpoint="_bulk_docs",exit_status="7"} 1
+# HELP couchdb_request_bytes_sent_total Request body bytes sent, including retries.
+# TYPE couchdb_request_bytes_sent_total counter
+couchdb_request_bytes_sent_total{method="POST",endpoint="_bulk_docs"} 100
+# HELP couchdb_response_bytes_received_total Response body bytes read.
+# TYPE couchdb_response_bytes_received_total counter
+couchdb_response_bytes_received_total{method="POST",endpoint="_bulk_docs"} 20
+# HELP couchdb_request_retries_total Number of request retries and resubmissions.
+# TYPE couchdb_request_retries_total counter
+couchdb_request_retries_total{method="POST",endpoint="_bulk_docs"} 1
+# HELP couchdb_auth_refreshes_total Number of times new credentials were obtained.
+# TYPE couchdb_auth_refreshes_total counter
+couchdb_auth_refreshes_total{mechanism="cookie"} 1
+# HELP couchdb_request_duration_seconds Time until response headers were received.
+# TYPE couchdb_request_duration_seconds histogram
+couchdb_request_duration_seconds_bucket{method="POST",endpoint="_bulk_docs",le="0.1"} 1
+couchdb_request_duration_seconds_bucket{method="POST",endpoint="_bulk_docs",le="1"} 2
+couchdb_request_duration_seconds_bucket{method="POST",endpoint="_bulk_docs",le="+Inf"} 2
+couchdb_request_duration_seconds_sum{method="POST",endpoint="_bulk_docs"} 0.55
+couchdb_request_duration_seconds_count{method="POST",endpoint="_bulk_docs"} 2
+`
+	if d := testy.DiffText(expected, rec.Body.String()); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestPrometheusMetricsLabels(t *testing.T) {
+	buckets := []float64{1}
+	p := &PrometheusMetrics{Buckets: buckets}
+	p.RequestStarted("GET", "a\"b\\c\nd é")
+	p.RequestFinished(RequestMetrics{Method: "GET", Endpoint: "a\"b\\c\nd é"})
+	buckets[0] = 2
+	p.Buckets = []float64{1, 2, 3}
+	p.RequestFinished(RequestMetrics{Method: "GET", Endpoint: "doc"})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`couchdb_requests_in_flight{method="GET",endpoint="a\"b\\c\nd é"} 0` + "\n",
+		`couchdb_request_duration_seconds_bucket{method="GET",endpoint="doc",le="1"} 1` + "\n",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("Expected output to contain:\n%s\nGot:\n%s", want, rec.Body.String())
+		}
+	}
+}
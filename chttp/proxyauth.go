@@ -1,11 +1,18 @@
 package chttp
 
 import (
+	"crypto"
 	"crypto/hmac"
-	"crypto/sha1"
+	_ "crypto/sha1"   // Register SHA-1 for ProxyAuth tokens
+	_ "crypto/sha256" // Register SHA-256 for ProxyAuth tokens
+	_ "crypto/sha512" // Register SHA-384 and SHA-512 for ProxyAuth tokens
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"strings"
+	"sync"
+
+	kivik "github.com/go-kivik/kivik/v4"
 )
 
 type ProxyAuth struct {
@@ -14,12 +21,30 @@ type ProxyAuth struct {
 	Roles    []string
 	Headers  http.Header
 
+	// Hash is the hash algorithm used to generate the HMAC token, which must
+	// match the server's chttpd_auth/hash_algorithms setting. Defaults to
+	// crypto.SHA1. crypto.SHA256, crypto.SHA384 and crypto.SHA512 are also
+	// supported.
+	Hash crypto.Hash
+
 	transport http.RoundTripper
-	token     string
+
+	mu sync.Mutex
+	// token is cached, along with the inputs used to generate it.
+	token    string
+	tokenFor proxyTokenInput
+}
+
+type proxyTokenInput struct {
+	username, secret string
+	hash             crypto.Hash
 }
 
 var _ Authenticator = &ProxyAuth{}
 
+// proxyTokenHeader is the default name of the header carrying the token.
+const proxyTokenHeader = "X-Auth-CouchDB-Token"
+
 func (a *ProxyAuth) header(header string) string {
 	if h := a.Headers.Get(header); h != "" {
 		return http.CanonicalHeaderKey(h)
@@ -27,37 +52,87 @@ func (a *ProxyAuth) header(header string) string {
 	return header
 }
 
-func (a *ProxyAuth) genToken() string {
+func (a *ProxyAuth) hash() crypto.Hash {
+	if a.Hash == 0 {
+		return crypto.SHA1
+	}
+	return a.Hash
+}
+
+func (a *ProxyAuth) genToken() (string, error) {
 	if a.Secret == "" {
-		return ""
+		return "", nil
+	}
+	if err := a.validate(); err != nil {
+		return "", err
 	}
-	if a.token != "" {
-		return a.token
+	input := proxyTokenInput{username: a.Username, secret: a.Secret, hash: a.hash()}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && a.tokenFor == input {
+		return a.token, nil
 	}
 	// Generate auth token
 	// https://docs.couchdb.org/en/stable/config/auth.html#couch_httpd_auth/x_auth_token
-	h := hmac.New(sha1.New, []byte(a.Secret))
+	h := hmac.New(input.hash.New, []byte(a.Secret))
 	_, _ = h.Write([]byte(a.Username))
-	a.token = hex.EncodeToString(h.Sum(nil))
-	return a.token
+	a.token, a.tokenFor = hex.EncodeToString(h.Sum(nil)), input
+	return a.token, nil
 }
 
-func (a *ProxyAuth) RoundTrip(req *http.Request) (*http.Response, error) {
-	if token := a.genToken(); token != "" {
-		req.Header.Set(a.header("X-Auth-CouchDB-Token"), token)
+func (a *ProxyAuth) validate() error {
+	switch a.hash() {
+	case crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512:
+		return nil
 	}
+	return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("chttp: unsupported proxy auth hash algorithm")}
+}
 
-	req.Header.Set(a.header("X-Auth-CouchDB-UserName"), a.Username)
-	req.Header.Set(a.header("X-Auth-CouchDB-Roles"), strings.Join(a.Roles, ","))
+// redactedHeaders returns the name of the token header, when it has been
+// renamed.
+func (a *ProxyAuth) redactedHeaders() []string {
+	if h := a.header(proxyTokenHeader); h != proxyTokenHeader {
+		return []string{h}
+	}
+	return nil
+}
 
+func (a *ProxyAuth) setHeaders(h http.Header) error {
+	token, err := a.genToken()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		h.Set(a.header(proxyTokenHeader), token)
+	}
+
+	h.Set(a.header("X-Auth-CouchDB-UserName"), a.Username)
+	h.Set(a.header("X-Auth-CouchDB-Roles"), strings.Join(a.Roles, ","))
+	return nil
+}
+
+func (a *ProxyAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	if contextRequestAuth(req.Context()) != nil {
+		return a.transport.RoundTrip(req)
+	}
+	if err := a.setHeaders(req.Header); err != nil {
+		return nil, err
+	}
 	return a.transport.RoundTrip(req)
 }
 
 func (a *ProxyAuth) Authenticate(c *Client) error {
-	a.transport = c.Transport
+	if err := a.validate(); err != nil {
+		return err
+	}
+	c.Transport = a.wrap(c, c.Transport)
+	return nil
+}
+
+func (a *ProxyAuth) wrap(_ *Client, rt http.RoundTripper) http.RoundTripper {
+	a.transport = rt
 	if a.transport == nil {
 		a.transport = http.DefaultTransport
 	}
-	c.Transport = a
-	return nil
+	return a
 }
@@ -1,6 +1,7 @@
 package chttp
 
 import (
+	"crypto"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -163,3 +164,66 @@ func TestProxyAuthRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyAuthToken(t *testing.T) {
+	type tt struct {
+		auth   *ProxyAuth
+		token  string
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("default hash", tt{
+		auth:  &ProxyAuth{Username: usernameTest, Secret: secretTest},
+		token: tokenTest,
+	})
+	tests.Add("sha256", tt{
+		auth:  &ProxyAuth{Username: usernameTest, Secret: secretTest, Hash: crypto.SHA256},
+		token: "14abffc9d8d7a47f312f51651d30fde4bb4a1a4a747a9c1e0db75936da2e501a",
+	})
+	tests.Add("sha512", tt{
+		auth:  &ProxyAuth{Username: usernameTest, Secret: secretTest, Hash: crypto.SHA512},
+		token: "cfb051d9358d92c43b3e3c6e34b8fb69c6fc04dd10b6dd0ed442f25c58dfb56d73920113e5eec5f4c06686074949b0a636d26c0ffdb90c16e28447f688723c56",
+	})
+	tests.Add("unsupported hash", tt{
+		auth:   &ProxyAuth{Username: usernameTest, Secret: secretTest, Hash: crypto.MD5},
+		status: http.StatusBadRequest,
+		err:    "chttp: unsupported proxy auth hash algorithm",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		token, err := tt.auth.genToken()
+		testy.StatusError(t, tt.err, tt.status, err)
+		if token != tt.token {
+			t.Errorf("Unexpected token: %s", token)
+		}
+	})
+}
+
+func TestProxyAuthTokenInvalidation(t *testing.T) {
+	auth := &ProxyAuth{Username: usernameTest, Secret: secretTest}
+	for _, step := range []struct {
+		update func()
+		token  string
+	}{
+		{func() {}, tokenTest},
+		{func() { auth.Username = "alice" }, "08e5c9451587d216a823f95e7c7ba5c07bca7010"},
+		{func() { auth.Secret = "xyz" }, "d9a9030c63ca33f91421ae745286797880cc3759"},
+	} {
+		step.update()
+		token, err := auth.genToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != step.token {
+			t.Errorf("Unexpected token: %s", token)
+		}
+	}
+}
+
+func TestProxyAuthAuthenticate(t *testing.T) {
+	c := &Client{Client: &http.Client{}}
+	err := (&ProxyAuth{Username: usernameTest, Secret: secretTest, Hash: crypto.MD5}).Authenticate(c)
+	testy.StatusError(t, "chttp: unsupported proxy auth hash algorithm", http.StatusBadRequest, err)
+}
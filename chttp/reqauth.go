@@ -0,0 +1,72 @@
+package chttp
+
+import (
+	"context"
+	"net/http"
+)
+
+var requestAuthContextKey = &struct{ name string }{"request auth"}
+
+// requestAuth applies per-request credentials to an outbound request.
+type requestAuth func(*http.Request) error
+
+func contextRequestAuth(ctx context.Context) requestAuth {
+	auth, _ := ctx.Value(requestAuthContextKey).(requestAuth)
+	return auth
+}
+
+func withRequestAuth(ctx context.Context, auth requestAuth) context.Context {
+	return context.WithValue(ctx, requestAuthContextKey, auth)
+}
+
+// WithBasicAuth returns a new context based on ctx. Requests made with the
+// returned context use HTTP Basic Auth with the provided credentials, in
+// place of the client's Authenticator and session cookie.
+//
+// Credentials attached to a context override only the authenticators
+// provided by this package. Credentials attached to a parent context are
+// replaced.
+func WithBasicAuth(ctx context.Context, username, password string) context.Context {
+	return withRequestAuth(ctx, func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// WithSessionCookie returns a new context based on ctx. Requests made with
+// the returned context send cookie, typically the AuthSession cookie of an end
+// user, in place of the client's Authenticator and session cookie.
+func WithSessionCookie(ctx context.Context, cookie *http.Cookie) context.Context {
+	return withRequestAuth(ctx, func(req *http.Request) error {
+		req.AddCookie(cookie)
+		return nil
+	})
+}
+
+// WithBearerToken returns a new context based on ctx. Requests made with the
+// returned context send token in the Authorization header, as used by JWT
+// authentication, in place of the client's Authenticator and session cookie.
+func WithBearerToken(ctx context.Context, token string) context.Context {
+	return withRequestAuth(ctx, func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// WithProxyAuth returns a new context based on ctx. Requests made with the
+// returned context send the proxy authentication headers of auth, in place of
+// the client's Authenticator and session cookie.
+func WithProxyAuth(ctx context.Context, auth *ProxyAuth) context.Context {
+	header := http.Header{}
+	err := auth.setHeaders(header)
+	ctx = withRedactedHeaders(ctx, auth.redactedHeaders()...)
+	return withRequestAuth(ctx, func(req *http.Request) error {
+		if err != nil {
+			return err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return nil
+	})
+}
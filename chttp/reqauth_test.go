@@ -0,0 +1,97 @@
+package chttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+func TestRequestAuth(t *testing.T) {
+	type tt struct {
+		ctx      context.Context
+		auth     Authenticator
+		expected http.Header
+	}
+
+	tests := testy.NewTable()
+	tests.Add("no request auth", tt{
+		ctx:  context.Background(),
+		auth: &BasicAuth{Username: "admin", Password: "abc123"},
+		expected: http.Header{
+			"Authorization": {"Basic YWRtaW46YWJjMTIz"},
+		},
+	})
+	tests.Add("basic auth overrides client auth", tt{
+		ctx:  WithBasicAuth(context.Background(), "bob", "secret"),
+		auth: &BasicAuth{Username: "admin", Password: "abc123"},
+		expected: http.Header{
+			"Authorization": {"Basic Ym9iOnNlY3JldA=="},
+		},
+	})
+	tests.Add("bearer token overrides cookie auth", tt{
+		ctx:  WithBearerToken(context.Background(), "xyz"),
+		auth: &CookieAuth{Username: "admin", Password: "abc123"},
+		expected: http.Header{
+			"Authorization": {"Bearer xyz"},
+		},
+	})
+	tests.Add("session cookie overrides cookie auth", tt{
+		ctx:  WithSessionCookie(context.Background(), &http.Cookie{Name: kivik.SessionCookieName, Value: "bob-session"}),
+		auth: &CookieAuth{Username: "admin", Password: "abc123"},
+		expected: http.Header{
+			"Cookie": {"AuthSession=bob-session"},
+		},
+	})
+	tests.Add("proxy auth overrides jwt auth", tt{
+		ctx: WithProxyAuth(context.Background(), &ProxyAuth{
+			Username: "bob",
+			Secret:   "abc123",
+			Roles:    []string{"users"},
+		}),
+		auth: &JWTAuth{Token: "admin-token"},
+		expected: http.Header{
+			"X-Auth-Couchdb-Roles":    {"users"},
+			"X-Auth-Couchdb-Token":    {tokenTest},
+			"X-Auth-Couchdb-Username": {"bob"},
+		},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		var got http.Header
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/_session" {
+				http.SetCookie(w, &http.Cookie{Name: kivik.SessionCookieName, Value: "admin-session", Path: "/"})
+				return
+			}
+			got = http.Header{}
+			for _, k := range []string{"Authorization", "Cookie", "X-Auth-Couchdb-Roles", "X-Auth-Couchdb-Token", "X-Auth-Couchdb-Username"} {
+				if v, ok := r.Header[k]; ok {
+					got[k] = v
+				}
+			}
+		}))
+		defer s.Close()
+		c, err := New(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Auth(tt.auth); err != nil {
+			t.Fatal(err)
+		}
+		// Establish a client-wide session, if using cookie auth
+		if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.DoError(tt.ctx, http.MethodGet, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface(tt.expected, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package chttp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default values used by a RetryPolicy when the corresponding field is unset.
+const (
+	DefaultMaxAttempts = 3
+	DefaultMinDelay    = 100 * time.Millisecond
+	DefaultMaxDelay    = 5 * time.Second
+)
+
+// DefaultMaxThrottleDelay is the longest a throttled request waits before it
+// is resubmitted, when a RetryPolicy does not specify its own.
+const DefaultMaxThrottleDelay = time.Minute
+
+// DefaultMaxThrottleWait is the longest total time a request spends waiting
+// to be resubmitted after 429 responses, when a RetryPolicy does not specify
+// its own.
+const DefaultMaxThrottleWait = time.Minute
+
+// DefaultThrottleRetries is the number of times a request is resubmitted after
+// a 429 Too Many Requests response, when Client.ThrottleRetries is unset.
+const DefaultThrottleRetries = 5
+
+// DefaultRetryStatus is the list of HTTP status codes considered transient
+// when a RetryPolicy does not specify its own.
+var DefaultRetryStatus = []int{
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// DefaultRetryExitStatus is the list of curl exit statuses considered
+// transient when a RetryPolicy does not specify its own.
+var DefaultRetryExitStatus = []int{
+	ExitFailedToConnect,
+	ExitOperationTimeout,
+}
+
+// RetryPolicy controls the automatic retry of failed requests by DoReq.
+//
+// Only requests which can be safely replayed are retried: GET and HEAD
+// requests, and requests which include the X-Idempotency-Key header. In
+// either case, a request with a body is only retried if Options.GetBody is
+// set, so that the body can be regenerated.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made for a single request,
+	// including the first. Defaults to DefaultMaxAttempts.
+	MaxAttempts int
+
+	// MinDelay is the delay before the first retry. Each subsequent retry
+	// doubles the delay, up to MaxDelay. A random jitter of up to half of the
+	// delay is subtracted from each wait. Defaults to DefaultMinDelay and
+	// DefaultMaxDelay respectively.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// MaxThrottleDelay is the longest time a throttled request waits before
+	// it is resubmitted, regardless of the server-supplied Retry-After value.
+	// Defaults to DefaultMaxThrottleDelay.
+	MaxThrottleDelay time.Duration
+
+	// MaxThrottleWait is the longest total time a request spends waiting to
+	// be resubmitted after 429 responses. Once the next delay would exceed
+	// it, the 429 response is returned. Defaults to DefaultMaxThrottleWait.
+	MaxThrottleWait time.Duration
+
+	// RetryStatus is the list of HTTP status codes which are considered
+	// transient. Defaults to DefaultRetryStatus.
+	RetryStatus []int
+
+	// RetryExitStatus is the list of curl exit statuses (see ExitStatus)
+	// which are considered transient. Defaults to DefaultRetryExitStatus.
+	RetryExitStatus []int
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil {
+		return 1
+	}
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+// delay returns the time to wait before the given retry attempt, where
+// attempt 1 is the first retry.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	if p == nil {
+		return Backoff(attempt, 0, 0)
+	}
+	return Backoff(attempt, p.MinDelay, p.MaxDelay)
+}
+
+// Backoff returns the time to wait before the given retry, where attempt 1 is
+// the first retry. The delay starts at minDelay, and doubles with each attempt
+// up to maxDelay, less a random jitter of up to half of the delay. A minDelay
+// or maxDelay of 0 selects DefaultMinDelay or DefaultMaxDelay respectively.
+func Backoff(attempt int, minDelay, maxDelay time.Duration) time.Duration {
+	if minDelay <= 0 {
+		minDelay = DefaultMinDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+	d := minDelay
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	if half := int64(d / 2); half > 0 {
+		d -= time.Duration(rand.Int63n(half)) // nolint: gosec
+	}
+	return d
+}
+
+// throttleDelay returns the time to wait before resubmitting a request which
+// received a 429 response. The server-supplied Retry-After value is used when
+// present, up to MaxThrottleDelay, otherwise the policy's backoff is applied.
+func (p *RetryPolicy) throttleDelay(res *http.Response, attempt int) time.Duration {
+	d, ok := RetryAfter(res)
+	if !ok {
+		return p.delay(attempt)
+	}
+	maxDelay := DefaultMaxThrottleDelay
+	if p != nil && p.MaxThrottleDelay > 0 {
+		maxDelay = p.MaxThrottleDelay
+	}
+	if d > maxDelay {
+		return maxDelay
+	}
+	return d
+}
+
+func (p *RetryPolicy) maxThrottleWait() time.Duration {
+	if p != nil && p.MaxThrottleWait > 0 {
+		return p.MaxThrottleWait
+	}
+	return DefaultMaxThrottleWait
+}
+
+// transient returns true if the response or error of a single attempt
+// indicates a failure which may succeed if retried.
+func (p *RetryPolicy) transient(res *http.Response, err error) bool {
+	if p == nil {
+		return false
+	}
+	if err != nil {
+		statuses := p.RetryExitStatus
+		if statuses == nil {
+			statuses = DefaultRetryExitStatus
+		}
+		return containsInt(statuses, ExitStatus(err))
+	}
+	statuses := p.RetryStatus
+	if statuses == nil {
+		statuses = DefaultRetryStatus
+	}
+	return containsInt(statuses, res.StatusCode)
+}
+
+func containsInt(list []int, i int) bool {
+	for _, v := range list {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+// replayable returns true if the request described by method and opts may be
+// safely sent more than once.
+func replayable(method string, opts *Options) bool {
+	if opts == nil {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	if opts.Body != nil && opts.GetBody == nil {
+		return false
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return true
+	}
+	_, ok := opts.Header[HeaderIdempotencyKey]
+	return ok
+}
+
+// resubmittable returns true if the request body described by opts, if any,
+// can be regenerated for another attempt.
+func resubmittable(opts *Options) bool {
+	return opts == nil || opts.Body == nil || opts.GetBody != nil
+}
+
+func (c *Client) throttleRetries() int {
+	switch {
+	case c.ThrottleRetries < 0:
+		return 0
+	case c.ThrottleRetries == 0:
+		return DefaultThrottleRetries
+	}
+	return c.ThrottleRetries
+}
+
+// RetryAfter returns the delay requested by the server in the Retry-After
+// header of resp, and true, or false if the header is absent or invalid. Both
+// the delay-seconds and HTTP-date forms of the header are supported.
+func RetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := time.Until(date); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// beforeDeadline returns true if waiting for d would not exceed ctx's
+// deadline.
+func beforeDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Now().Add(d).Before(deadline)
+}
+
+// discardResponse consumes and closes the body of a response which will not
+// be returned to the caller, so that the connection may be re-used.
+func discardResponse(res *http.Response) {
+	if res == nil || res.Body == nil {
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+}
+
+// sleep waits for d, or until ctx is cancelled, whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,455 @@
+package chttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := &RetryPolicy{MinDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{attempt: 3, min: 150 * time.Millisecond, max: 300 * time.Millisecond},
+		{attempt: 10, min: 150 * time.Millisecond, max: 300 * time.Millisecond},
+	}
+	for _, test := range tests {
+		d := p.delay(test.attempt)
+		if d < test.min || d > test.max {
+			t.Errorf("Attempt %d: delay %s outside of [%s, %s]", test.attempt, d, test.min, test.max)
+		}
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	if d := Backoff(1, 0, 0); d < DefaultMinDelay/2 || d > DefaultMinDelay {
+		t.Errorf("Unexpected first delay: %s", d)
+	}
+	if d := Backoff(100, 0, 0); d < DefaultMaxDelay/2 || d > DefaultMaxDelay {
+		t.Errorf("Unexpected capped delay: %s", d)
+	}
+}
+
+func TestReplayable(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		opts     *Options
+		expected bool
+	}{
+		{
+			name:     "GET without options",
+			method:   http.MethodGet,
+			expected: true,
+		},
+		{
+			name:     "POST without options",
+			method:   http.MethodPost,
+			expected: false,
+		},
+		{
+			name:   "POST with idempotency key",
+			method: http.MethodPost,
+			opts: &Options{
+				GetBody: BodyEncoder("foo"),
+				Header:  http.Header{HeaderIdempotencyKey: []string{}},
+			},
+			expected: true,
+		},
+		{
+			name:   "idempotency key, but body can't be regenerated",
+			method: http.MethodPost,
+			opts: &Options{
+				Body:   Body("foo"),
+				Header: http.Header{HeaderIdempotencyKey: []string{}},
+			},
+			expected: false,
+		},
+		{
+			name:     "PUT without idempotency key",
+			method:   http.MethodPut,
+			opts:     &Options{GetBody: BodyEncoder("foo")},
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := replayable(test.method, test.opts); result != test.expected {
+				t.Errorf("Unexpected result: %t", result)
+			}
+		})
+	}
+}
+
+func TestDoReqRetry(t *testing.T) {
+	type tt struct {
+		client        *Client
+		method        string
+		opts          *Options
+		ctx           context.Context
+		cancel        func()
+		status        int
+		err           string
+		expectedCalls int
+	}
+	policy := &RetryPolicy{MinDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+	tests := testy.NewTable()
+	tests.Add("no policy", func(t *testing.T) interface{} {
+		return tt{
+			client: newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: Body("")}, nil
+			}),
+			method:        http.MethodGet,
+			expectedCalls: 1,
+		}
+	})
+	tests.Add("transient status, then success", func(t *testing.T) interface{} {
+		var calls int
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			calls++
+			if calls < 3 {
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: Body("")}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: Body("")}, nil
+		})
+		c.RetryPolicy = policy
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			expectedCalls: 3,
+		}
+	})
+	tests.Add("attempts exhausted", func(t *testing.T) interface{} {
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusBadGateway, Body: Body("")}, nil
+		})
+		c.RetryPolicy = &RetryPolicy{MaxAttempts: 5, MinDelay: time.Millisecond}
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			expectedCalls: 5,
+		}
+	})
+	tests.Add("non-transient status", func(t *testing.T) interface{} {
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: Body("")}, nil
+		})
+		c.RetryPolicy = policy
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			expectedCalls: 1,
+		}
+	})
+	tests.Add("connection refused", func(t *testing.T) interface{} {
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return nil, refused
+		})
+		c.RetryPolicy = policy
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			status:        http.StatusBadGateway,
+			err:           "connection refused",
+			expectedCalls: 3,
+		}
+	})
+	tests.Add("non-idempotent POST", func(t *testing.T) interface{} {
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: Body("")}, nil
+		})
+		c.RetryPolicy = policy
+		return tt{
+			client:        c,
+			method:        http.MethodPost,
+			opts:          &Options{GetBody: BodyEncoder("foo")},
+			expectedCalls: 1,
+		}
+	})
+	tests.Add("idempotent POST, body replayed", func(t *testing.T) interface{} {
+		var calls int
+		c := newCustomClient("", func(r *http.Request) (*http.Response, error) {
+			calls++
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != "foo" {
+				t.Errorf("Unexpected body on attempt %d: %s", calls, body)
+			}
+			if calls == 1 {
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: Body("")}, nil
+			}
+			return &http.Response{StatusCode: http.StatusCreated, Body: Body("")}, nil
+		})
+		c.RetryPolicy = policy
+		return tt{
+			client: c,
+			method: http.MethodPost,
+			opts: &Options{
+				GetBody: BodyEncoder("foo"),
+				Header:  http.Header{HeaderIdempotencyKey: []string{}},
+			},
+			expectedCalls: 2,
+		}
+	})
+	tests.Add("context cancelled during backoff", func(t *testing.T) interface{} {
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: Body("")}, nil
+		})
+		c.RetryPolicy = &RetryPolicy{MinDelay: time.Hour}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			ctx:           ctx,
+			cancel:        cancel,
+			expectedCalls: 1,
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		var calls int
+		xport := tt.client.Transport.(customTransport)
+		tt.client.Transport = customTransport(func(r *http.Request) (*http.Response, error) {
+			calls++
+			return xport(r)
+		})
+		ctx := tt.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if tt.cancel != nil {
+			defer tt.cancel()
+		}
+		_, err := tt.client.DoReq(ctx, tt.method, "/foo", tt.opts)
+		if calls != tt.expectedCalls {
+			t.Errorf("Expected %d calls, got %d", tt.expectedCalls, calls)
+		}
+		testy.StatusErrorRE(t, tt.err, tt.status, err)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{
+			name: "no header",
+		},
+		{
+			name:     "seconds",
+			value:    "3",
+			expected: 3 * time.Second,
+			ok:       true,
+		},
+		{
+			name:  "negative",
+			value: "-3",
+		},
+		{
+			name:  "garbage",
+			value: "soon",
+		},
+		{
+			name:     "date in the past",
+			value:    "Wed, 01 Nov 2017 19:32:41 GMT",
+			expected: 0,
+			ok:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := &http.Response{Header: http.Header{}}
+			if test.value != "" {
+				res.Header.Set("Retry-After", test.value)
+			}
+			d, ok := RetryAfter(res)
+			if d != test.expected || ok != test.ok {
+				t.Errorf("Unexpected result: %s, %t", d, ok)
+			}
+		})
+	}
+	t.Run("future date", func(t *testing.T) {
+		res := &http.Response{Header: http.Header{
+			"Retry-After": {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)},
+		}}
+		d, ok := RetryAfter(res)
+		if !ok || d <= 0 || d > time.Minute {
+			t.Errorf("Unexpected result: %s, %t", d, ok)
+		}
+	})
+}
+
+func TestDoReqThrottle(t *testing.T) {
+	type tt struct {
+		client        *Client
+		method        string
+		opts          *Options
+		ctx           context.Context
+		cancel        func()
+		expectedCalls int
+		expectedHooks int
+		status        int
+	}
+	throttled := func(retryAfter string) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": {retryAfter}},
+			Body:       Body(""),
+		}
+	}
+
+	tests := testy.NewTable()
+	tests.Add("throttled, then success", func(t *testing.T) interface{} {
+		var calls int
+		return tt{
+			client: newCustomClient("", func(r *http.Request) (*http.Response, error) {
+				calls++
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != `{"docs":[]}` {
+					t.Errorf("Unexpected body on attempt %d: %s", calls, body)
+				}
+				if calls < 3 {
+					return throttled("0"), nil
+				}
+				return &http.Response{StatusCode: http.StatusCreated, Body: Body("")}, nil
+			}),
+			method:        http.MethodPost,
+			opts:          &Options{GetBody: BodyEncoder(`{"docs":[]}`)},
+			expectedCalls: 3,
+			expectedHooks: 2,
+			status:        http.StatusCreated,
+		}
+	})
+	tests.Add("body cannot be regenerated", func(t *testing.T) interface{} {
+		return tt{
+			client: newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+				return throttled("0"), nil
+			}),
+			method:        http.MethodPost,
+			opts:          &Options{Body: Body("foo")},
+			expectedCalls: 1,
+			status:        http.StatusTooManyRequests,
+		}
+	})
+	tests.Add("retries exhausted", func(t *testing.T) interface{} {
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return throttled("0"), nil
+		})
+		c.ThrottleRetries = 2
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			expectedCalls: 3,
+			expectedHooks: 2,
+			status:        http.StatusTooManyRequests,
+		}
+	})
+	tests.Add("disabled", func(t *testing.T) interface{} {
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return throttled("0"), nil
+		})
+		c.ThrottleRetries = -1
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			expectedCalls: 1,
+			status:        http.StatusTooManyRequests,
+		}
+	})
+	tests.Add("retry-after capped", func(t *testing.T) interface{} {
+		var calls int
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			calls++
+			if calls < 2 {
+				return throttled("3600"), nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: Body("")}, nil
+		})
+		c.RetryPolicy = &RetryPolicy{MaxThrottleDelay: time.Millisecond}
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			expectedCalls: 2,
+			expectedHooks: 1,
+			status:        http.StatusOK,
+		}
+	})
+	tests.Add("total wait capped", func(t *testing.T) interface{} {
+		c := newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+			return throttled("3600"), nil
+		})
+		c.RetryPolicy = &RetryPolicy{MaxThrottleDelay: 10 * time.Millisecond, MaxThrottleWait: 25 * time.Millisecond}
+		return tt{
+			client:        c,
+			method:        http.MethodGet,
+			expectedCalls: 3,
+			expectedHooks: 2,
+			status:        http.StatusTooManyRequests,
+		}
+	})
+	tests.Add("delay exceeds deadline", func(t *testing.T) interface{} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		return tt{
+			client: newCustomClient("", func(_ *http.Request) (*http.Response, error) {
+				return throttled("60"), nil
+			}),
+			method:        http.MethodGet,
+			ctx:           ctx,
+			cancel:        cancel,
+			expectedCalls: 1,
+			status:        http.StatusTooManyRequests,
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		var calls, hooks int
+		xport := tt.client.Transport.(customTransport)
+		tt.client.Transport = customTransport(func(r *http.Request) (*http.Response, error) {
+			calls++
+			return xport(r)
+		})
+		ctx := tt.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if tt.cancel != nil {
+			defer tt.cancel()
+		}
+		ctx = WithClientTrace(ctx, &ClientTrace{
+			Throttled: func(r *http.Response, _ time.Duration) {
+				hooks++
+				if r.StatusCode != http.StatusTooManyRequests {
+					t.Errorf("Unexpected status in throttle hook: %d", r.StatusCode)
+				}
+			},
+		})
+		res, err := tt.client.DoReq(ctx, tt.method, "/foo", tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("Unexpected status: %d", res.StatusCode)
+		}
+		if calls != tt.expectedCalls {
+			t.Errorf("Expected %d calls, got %d", tt.expectedCalls, calls)
+		}
+		if hooks != tt.expectedHooks {
+			t.Errorf("Expected %d throttle hooks, got %d", tt.expectedHooks, hooks)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package chttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DSN query parameters used to configure TLS. They are removed from the DSN
+// before it is used.
+const (
+	// DSNParamCAFile is the path to a PEM-encoded CA bundle used to verify the
+	// server's certificate.
+	DSNParamCAFile = "tls_ca_file"
+	// DSNParamCertFile is the path to a PEM-encoded client certificate.
+	DSNParamCertFile = "tls_cert_file"
+	// DSNParamKeyFile is the path to the PEM-encoded private key of the client
+	// certificate.
+	DSNParamKeyFile = "tls_key_file"
+	// DSNParamServerName overrides the server name used to verify the server's
+	// certificate.
+	DSNParamServerName = "tls_server_name"
+	// DSNParamInsecureSkipVerify disables verification of the server's
+	// certificate when set to true. It should only be used for development.
+	DSNParamInsecureSkipVerify = "tls_insecure_skip_verify"
+)
+
+// TLSOptions configures the TLS connections made by a client.
+type TLSOptions struct {
+	// CAFile is the path to a PEM-encoded CA bundle used to verify the
+	// server's certificate. If unset, the system roots are used.
+	CAFile string
+
+	// CertFile and KeyFile are the paths to a PEM-encoded client certificate
+	// and its private key, used for mutual TLS.
+	CertFile string
+	KeyFile  string
+
+	// ServerName, if set, is used to verify the server's certificate in place
+	// of the host name in the DSN.
+	ServerName string
+
+	// InsecureSkipVerify disables verification of the server's certificate.
+	// It should only be used for development.
+	InsecureSkipVerify bool
+}
+
+// tlsOptionsFromDSN returns the TLS options set in the query of dsn, which
+// are removed from dsn, or nil if none are set.
+func tlsOptionsFromDSN(dsn *url.URL) (*TLSOptions, error) {
+	query := dsn.Query()
+	opts := &TLSOptions{}
+	var found bool
+	for param, target := range map[string]*string{
+		DSNParamCAFile:     &opts.CAFile,
+		DSNParamCertFile:   &opts.CertFile,
+		DSNParamKeyFile:    &opts.KeyFile,
+		DSNParamServerName: &opts.ServerName,
+	} {
+		if _, ok := query[param]; ok {
+			*target = query.Get(param)
+			query.Del(param)
+			found = true
+		}
+	}
+	if _, ok := query[DSNParamInsecureSkipVerify]; ok {
+		skip, err := strconv.ParseBool(query.Get(DSNParamInsecureSkipVerify))
+		if err != nil {
+			return nil, fullError(http.StatusBadRequest, ExitStatusURLMalformed, err)
+		}
+		opts.InsecureSkipVerify = skip
+		query.Del(DSNParamInsecureSkipVerify)
+		found = true
+	}
+	if !found {
+		return nil, nil
+	}
+	dsn.RawQuery = query.Encode()
+	return opts, nil
+}
+
+// Transport returns a copy of base, which must be an *http.Transport, with
+// its TLS configuration updated according to o. Options which are unset in o
+// do not modify the existing configuration. If base is nil,
+// http.DefaultTransport is used.
+func (o *TLSOptions) Transport(base http.RoundTripper) (http.RoundTripper, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fullError(http.StatusBadRequest, ExitFailedToInitialize, errors.New("TLS options require an *http.Transport"))
+	}
+	t = cloneTransport(t)
+	cfg, err := o.config(t.TLSClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = cfg
+	return t, nil
+}
+
+func (o *TLSOptions) config(base *tls.Config) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fullError(http.StatusBadRequest, ExitSSLCACertBadFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fullError(http.StatusBadRequest, ExitSSLCACertBadFile, errors.New("no certificates found in "+o.CAFile))
+		}
+		cfg.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fullError(http.StatusBadRequest, ExitSSLCertProblem, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if o.ServerName != "" {
+		cfg.ServerName = o.ServerName
+	}
+	if o.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
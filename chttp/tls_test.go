@@ -0,0 +1,236 @@
+package chttp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestTLSOptionsFromDSN(t *testing.T) {
+	type tt struct {
+		dsn      string
+		expected *TLSOptions
+		query    string
+		status   int
+		err      string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("none", tt{
+		dsn:   "https://example.com/?foo=bar",
+		query: "foo=bar",
+	})
+	tests.Add("all", tt{
+		dsn: "https://example.com/?tls_ca_file=%2Fca.pem&tls_cert_file=%2Fcert.pem&tls_key_file=%2Fkey.pem&tls_server_name=couch&tls_insecure_skip_verify=true&foo=bar",
+		expected: &TLSOptions{
+			CAFile:             "/ca.pem",
+			CertFile:           "/cert.pem",
+			KeyFile:            "/key.pem",
+			ServerName:         "couch",
+			InsecureSkipVerify: true,
+		},
+		query: "foo=bar",
+	})
+	tests.Add("invalid bool", tt{
+		dsn:    "https://example.com/?tls_insecure_skip_verify=maybe",
+		status: http.StatusBadRequest,
+		err:    `strconv.ParseBool: parsing "maybe": invalid syntax`,
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		dsn, err := url.Parse(tt.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts, err := tlsOptionsFromDSN(dsn)
+		testy.StatusError(t, tt.err, tt.status, err)
+		if d := testy.DiffInterface(tt.expected, opts); d != nil {
+			t.Error(d)
+		}
+		if dsn.RawQuery != tt.query {
+			t.Errorf("Unexpected query: %s", dsn.RawQuery)
+		}
+	})
+}
+
+func TestTLSOptionsTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chttp-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type tt struct {
+		opts       *TLSOptions
+		base       http.RoundTripper
+		status     int
+		curlStatus int
+		err        string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("not an *http.Transport", tt{
+		opts:       &TLSOptions{InsecureSkipVerify: true},
+		base:       customTransport(nil),
+		status:     http.StatusBadRequest,
+		curlStatus: ExitFailedToInitialize,
+		err:        `TLS options require an \*http\.Transport`,
+	})
+	tests.Add("missing CA file", tt{
+		opts:       &TLSOptions{CAFile: filepath.Join(dir, "missing.pem")},
+		status:     http.StatusBadRequest,
+		curlStatus: ExitSSLCACertBadFile,
+		err:        "no such file or directory",
+	})
+	tests.Add("empty CA file", tt{
+		opts:       &TLSOptions{CAFile: empty},
+		status:     http.StatusBadRequest,
+		curlStatus: ExitSSLCACertBadFile,
+		err:        "no certificates found",
+	})
+	tests.Add("invalid client cert", tt{
+		opts:       &TLSOptions{CertFile: empty, KeyFile: empty},
+		status:     http.StatusBadRequest,
+		curlStatus: ExitSSLCertProblem,
+		err:        "failed to find any PEM data",
+	})
+	tests.Add("insecure", tt{
+		opts: &TLSOptions{InsecureSkipVerify: true, ServerName: "couch"},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		rt, err := tt.opts.Transport(tt.base)
+		curlStatusErrorRE(t, tt.err, tt.status, tt.curlStatus, err)
+		if err != nil {
+			return
+		}
+		cfg := rt.(*http.Transport).TLSClientConfig
+		if !cfg.InsecureSkipVerify || cfg.ServerName != "couch" {
+			t.Errorf("Unexpected TLS config: %v", cfg)
+		}
+		if rt == http.DefaultTransport {
+			t.Error("Expected a copy of the default transport")
+		}
+	})
+}
+
+// writeClientCert generates a self-signed client certificate, and writes it
+// and its key to dir.
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return cert, certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chttp-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	clientCert, certFile, keyFile := writeClientCert(t, dir)
+
+	var sessions int
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_session" {
+			sessions++
+			http.SetCookie(w, &http.Cookie{Name: "AuthSession", Value: "foo", Path: "/"})
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	s.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.StartTLS()
+	defer s.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", s.Certificate().Raw)
+
+	u, _ := url.Parse(s.URL)
+	u.User = url.UserPassword("admin", "abc123")
+	u.RawQuery = url.Values{
+		DSNParamCAFile:     {caFile},
+		DSNParamCertFile:   {certFile},
+		DSNParamKeyFile:    {keyFile},
+		DSNParamServerName: {"example.com"},
+	}.Encode()
+
+	c, err := New(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 1 {
+		t.Errorf("Expected a session to be established over TLS, got %d", sessions)
+	}
+
+	// Without a client certificate, the handshake fails
+	u.RawQuery = url.Values{DSNParamCAFile: {caFile}}.Encode()
+	c, err = New(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DoError(context.Background(), http.MethodGet, "/", nil); err == nil {
+		t.Error("Expected an error without a client certificate")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 var clientTraceContextKey = &struct{ name string }{"client trace"}
@@ -41,6 +42,12 @@ type ClientTrace struct {
 	// with the body cloned, if it is set. This can be expensive for requests
 	// with large bodies.
 	HTTPRequestBody func(*http.Request)
+
+	// Throttled is called when the server responds with 429 Too Many
+	// Requests, and the request is about to be resubmitted. It receives a
+	// clone of the *http.Response, with the body set to nil, and the delay
+	// that will be observed before the request is resubmitted.
+	Throttled func(*http.Response, time.Duration)
 }
 
 // WithClientTrace returns a new context based on the provided parent
@@ -79,6 +86,16 @@ func (t *ClientTrace) httpResponseBody(r *http.Response) {
 	t.HTTPResponseBody(clone)
 }
 
+func (t *ClientTrace) throttled(r *http.Response, delay time.Duration) {
+	if t.Throttled == nil {
+		return
+	}
+	clone := new(http.Response)
+	*clone = *r
+	clone.Body = nil
+	t.Throttled(clone, delay)
+}
+
 func (t *ClientTrace) httpRequest(r *http.Request) {
 	if t.HTTPRequest == nil {
 		return
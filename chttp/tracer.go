@@ -0,0 +1,155 @@
+package chttp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Span attribute keys set by the driver.
+const (
+	// AttrDBSystem is always set to "couchdb".
+	AttrDBSystem = "db.system"
+	// AttrDBName is the name of the database, if any.
+	AttrDBName = "db.name"
+	// AttrDBOperation is the name of the driver operation, such as "db.Find".
+	AttrDBOperation = "db.operation"
+	// AttrDocID is the document ID, if any.
+	AttrDocID = "couchdb.doc_id"
+	// AttrHTTPMethod is the HTTP method of the request.
+	AttrHTTPMethod = "http.method"
+	// AttrHTTPTarget is the path of the request, relative to the DSN.
+	AttrHTTPTarget = "http.target"
+	// AttrHTTPStatusCode is the status code of the final response.
+	AttrHTTPStatusCode = "http.status_code"
+	// AttrRequestID is the value of the X-Couch-Request-ID response header.
+	AttrRequestID = "couchdb.request_id"
+	// AttrRetries is the number of times the request was retried or
+	// resubmitted.
+	AttrRetries = "couchdb.retries"
+)
+
+// HeaderTraceParent is the W3C Trace Context header sent with each request,
+// when a Tracer is configured.
+const HeaderTraceParent = "traceparent"
+
+// Tracer creates spans, which may be set as Client.Tracer to trace requests.
+// It is typically an adaptor for a tracing library such as OpenTelemetry.
+type Tracer interface {
+	// StartSpan starts a new span called name, as a child of the span carried
+	// by ctx, if any. The returned context must carry the new span.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single operation within a trace.
+type Span interface {
+	// SetAttribute annotates the span. See the Attr constants for the keys
+	// used by the driver.
+	SetAttribute(key string, value interface{})
+
+	// TraceParent returns the W3C traceparent header value which identifies
+	// the span, such as
+	// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01". If it returns
+	// "", no traceparent header is sent.
+	TraceParent() string
+
+	// End completes the span. err is the error returned by the operation, if
+	// any. The span of an operation which returns a streamed result, such as
+	// a rows iterator, ends when the result is closed, with the first error
+	// encountered while reading the response body.
+	End(err error)
+}
+
+// StartSpan starts a span for the driver operation op, such as "db.Find",
+// using the client's Tracer. dbName and docID, if not empty, annotate the
+// span. If the client has no Tracer, it returns ctx and a nil Span.
+func (c *Client) StartSpan(ctx context.Context, op, dbName, docID string) (context.Context, Span) {
+	if c.Tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := c.Tracer.StartSpan(ctx, op)
+	span.SetAttribute(AttrDBSystem, "couchdb")
+	span.SetAttribute(AttrDBOperation, op)
+	if dbName != "" {
+		span.SetAttribute(AttrDBName, dbName)
+	}
+	if docID != "" {
+		span.SetAttribute(AttrDocID, docID)
+	}
+	return ctx, span
+}
+
+// startRequestSpan starts the child span for a call to DoReq.
+func (c *Client) startRequestSpan(ctx context.Context, method, path string) (context.Context, Span) {
+	if c.Tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := c.Tracer.StartSpan(ctx, "HTTP "+method)
+	span.SetAttribute(AttrDBSystem, "couchdb")
+	span.SetAttribute(AttrHTTPMethod, method)
+	span.SetAttribute(AttrHTTPTarget, path)
+	dbName, docID := pathIDs(path)
+	if dbName != "" {
+		span.SetAttribute(AttrDBName, dbName)
+	}
+	if docID != "" {
+		span.SetAttribute(AttrDocID, docID)
+	}
+	return ctx, span
+}
+
+// endRequestSpan annotates span with the result of the request, and ends it.
+func endRequestSpan(span Span, res *http.Response, err error, retries int) {
+	if span == nil {
+		return
+	}
+	if res != nil {
+		span.SetAttribute(AttrHTTPStatusCode, res.StatusCode)
+		if id := res.Header.Get("X-Couch-Request-ID"); id != "" {
+			span.SetAttribute(AttrRequestID, id)
+		}
+	}
+	if retries > 0 {
+		span.SetAttribute(AttrRetries, retries)
+	}
+	span.End(err)
+}
+
+// setTraceParent sets the traceparent header of req to identify span.
+func setTraceParent(req *http.Request, span Span) {
+	if span == nil {
+		return
+	}
+	if tp := span.TraceParent(); tp != "" {
+		req.Header.Set(HeaderTraceParent, tp)
+	}
+}
+
+// pathIDs returns the database name and document ID addressed by path, if
+// any.
+func pathIDs(path string) (dbName, docID string) {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if segments[0] == "" || strings.HasPrefix(segments[0], "_") {
+		return "", ""
+	}
+	dbName, _ = url.PathUnescape(segments[0])
+	if len(segments) < 2 {
+		return dbName, ""
+	}
+	id := segments[1]
+	switch {
+	case id == "_design" || id == "_local":
+		if len(segments) < 3 {
+			return dbName, ""
+		}
+		id += "/" + segments[2]
+	case strings.HasPrefix(id, "_"):
+		return dbName, ""
+	}
+	docID, _ = url.PathUnescape(id)
+	return dbName, docID
+}
@@ -0,0 +1,169 @@
+package chttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+var memSpanContextKey = &struct{ name string }{"mem span"}
+
+// memTracer is an in-memory Tracer.
+type memTracer struct {
+	mu    sync.Mutex
+	spans []*memSpan
+}
+
+type memSpan struct {
+	id     int
+	name   string
+	parent *memSpan
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+var _ Tracer = &memTracer{}
+
+func (t *memTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent, _ := ctx.Value(memSpanContextKey).(*memSpan)
+	span := &memSpan{
+		id:     len(t.spans) + 1,
+		name:   name,
+		parent: parent,
+		attrs:  map[string]interface{}{},
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, memSpanContextKey, span), span
+}
+
+func (s *memSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *memSpan) TraceParent() string {
+	return fmt.Sprintf("00-%032x-%016x-01", 1, s.id)
+}
+
+func (s *memSpan) End(err error) {
+	s.err = err
+	s.ended = true
+}
+
+func TestPathIDs(t *testing.T) {
+	tests := []struct {
+		path          string
+		dbName, docID string
+	}{
+		{path: "/"},
+		{path: "/_all_dbs"},
+		{path: "/db", dbName: "db"},
+		{path: "/db/_find", dbName: "db"},
+		{path: "/db/_design", dbName: "db"},
+		{path: "/db/doc?rev=1-abc", dbName: "db", docID: "doc"},
+		{path: "/db/doc/att.txt", dbName: "db", docID: "doc"},
+		{path: "/some%2Fdb/foo%2Fbar", dbName: "some/db", docID: "foo/bar"},
+		{path: "/db/_design/foo/_view/bar", dbName: "db", docID: "_design/foo"},
+		{path: "db/_local/foo", dbName: "db", docID: "_local/foo"},
+	}
+	for _, test := range tests {
+		dbName, docID := pathIDs(test.path)
+		if dbName != test.dbName || docID != test.docID {
+			t.Errorf("%s: expected %q/%q, got %q/%q", test.path, test.dbName, test.docID, dbName, docID)
+		}
+	}
+}
+
+func TestDoReqTracing(t *testing.T) {
+	tracer := &memTracer{}
+	var traceParents []string
+	var calls int
+	c := newCustomClient("", func(r *http.Request) (*http.Response, error) {
+		calls++
+		traceParents = append(traceParents, r.Header.Get(HeaderTraceParent))
+		status := http.StatusOK
+		if calls == 1 {
+			status = http.StatusServiceUnavailable
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"X-Couch-Request-Id": []string{"abc123"}},
+			Body:       Body(""),
+		}, nil
+	})
+	c.Tracer = tracer
+	c.RetryPolicy = &RetryPolicy{MinDelay: 1}
+
+	ctx, parent := c.StartSpan(context.Background(), "db.Get", "db", "_design/foo")
+	if _, err := c.DoReq(ctx, http.MethodGet, "/db/_design/foo", nil); err != nil {
+		t.Fatal(err)
+	}
+	parent.End(nil)
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(tracer.spans))
+	}
+	op, req := tracer.spans[0], tracer.spans[1]
+	if d := testy.DiffInterface(map[string]interface{}{
+		AttrDBSystem:    "couchdb",
+		AttrDBOperation: "db.Get",
+		AttrDBName:      "db",
+		AttrDocID:       "_design/foo",
+	}, op.attrs); d != nil {
+		t.Error(d)
+	}
+	if req.name != "HTTP GET" || req.parent != op || !req.ended {
+		t.Errorf("Unexpected request span: %s, parent %v, ended %t", req.name, req.parent, req.ended)
+	}
+	if d := testy.DiffInterface(map[string]interface{}{
+		AttrDBSystem:       "couchdb",
+		AttrHTTPMethod:     http.MethodGet,
+		AttrHTTPTarget:     "/db/_design/foo",
+		AttrDBName:         "db",
+		AttrDocID:          "_design/foo",
+		AttrHTTPStatusCode: http.StatusOK,
+		AttrRequestID:      "abc123",
+		AttrRetries:        1,
+	}, req.attrs); d != nil {
+		t.Error(d)
+	}
+	expected := []string{req.TraceParent(), req.TraceParent()}
+	if d := testy.DiffInterface(expected, traceParents); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestDoReqTracingError(t *testing.T) {
+	tracer := &memTracer{}
+	c := newCustomClient("", func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("boom")
+	})
+	c.Tracer = tracer
+	_, err := c.DoReq(context.Background(), http.MethodGet, "/", nil)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	span := tracer.spans[0]
+	if span.err != err {
+		t.Errorf("Unexpected span error: %v", span.err)
+	}
+	if _, ok := span.attrs[AttrHTTPStatusCode]; ok {
+		t.Error("Unexpected status code attribute")
+	}
+}
+
+func TestStartSpanNoTracer(t *testing.T) {
+	c := &Client{}
+	ctx := context.Background()
+	newCtx, span := c.StartSpan(ctx, "db.Get", "db", "doc")
+	if newCtx != ctx || span != nil {
+		t.Error("Expected no span without a tracer")
+	}
+}
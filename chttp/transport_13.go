@@ -0,0 +1,10 @@
+// +build go1.13
+
+package chttp
+
+import "net/http"
+
+// cloneTransport returns a copy of t.
+func cloneTransport(t *http.Transport) *http.Transport {
+	return t.Clone()
+}
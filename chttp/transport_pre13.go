@@ -0,0 +1,42 @@
+// +build !go1.13
+
+package chttp
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// cloneTransport returns a copy of t. http.Transport.Clone requires Go 1.13,
+// so the fields are copied by hand.
+func cloneTransport(t *http.Transport) *http.Transport {
+	clone := &http.Transport{
+		Proxy:                  t.Proxy,
+		DialContext:            t.DialContext,
+		Dial:                   t.Dial, // nolint: staticcheck
+		DialTLS:                t.DialTLS,
+		TLSHandshakeTimeout:    t.TLSHandshakeTimeout,
+		DisableKeepAlives:      t.DisableKeepAlives,
+		DisableCompression:     t.DisableCompression,
+		MaxIdleConns:           t.MaxIdleConns,
+		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        t.MaxConnsPerHost,
+		IdleConnTimeout:        t.IdleConnTimeout,
+		ResponseHeaderTimeout:  t.ResponseHeaderTimeout,
+		ExpectContinueTimeout:  t.ExpectContinueTimeout,
+		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
+	}
+	if t.TLSClientConfig != nil {
+		clone.TLSClientConfig = t.TLSClientConfig.Clone()
+	}
+	if t.ProxyConnectHeader != nil {
+		clone.ProxyConnectHeader = cloneHeader(t.ProxyConnectHeader)
+	}
+	if t.TLSNextProto != nil {
+		clone.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper, len(t.TLSNextProto))
+		for k, v := range t.TLSNextProto {
+			clone.TLSNextProto[k] = v
+		}
+	}
+	return clone
+}
@@ -0,0 +1,76 @@
+package chttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SchemeHTTPUnix is the DSN scheme used to connect to a CouchDB server
+// listening on a Unix domain socket. The host portion of the DSN is the
+// URL-encoded path to the socket. For example:
+//
+//	http+unix://%2Fvar%2Frun%2Fcouchdb.sock/
+const SchemeHTTPUnix = "http+unix"
+
+// unixHost is the host used in requests sent over a Unix domain socket.
+const unixHost = "localhost"
+
+// DialFunc dials a network connection. It has the signature of
+// http.Transport's DialContext field.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// UnixDialer returns a DialFunc which connects to the Unix domain socket at
+// path, regardless of the requested address.
+func UnixDialer(path string) DialFunc {
+	var d net.Dialer
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+// DialTransport returns a copy of base, which must be an *http.Transport,
+// which uses dial to establish all connections. If base is nil,
+// http.DefaultTransport is used.
+func DialTransport(base http.RoundTripper, dial DialFunc) (http.RoundTripper, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fullError(http.StatusBadRequest, ExitFailedToInitialize, errors.New("a custom dialer requires an *http.Transport"))
+	}
+	t = cloneTransport(t)
+	t.DialContext = dial
+	return t, nil
+}
+
+// unixDSN converts a DSN using the http+unix scheme to an http DSN, and
+// returns it along with the path to the socket. Other DSNs are returned
+// unaltered, with an empty socket path.
+func unixDSN(dsn string) (socket, httpDSN string, err error) {
+	prefix := SchemeHTTPUnix + "://"
+	if !strings.HasPrefix(dsn, prefix) {
+		return "", dsn, nil
+	}
+	rest := dsn[len(prefix):]
+	host, path := rest, "/"
+	if i := strings.Index(rest, "/"); i >= 0 {
+		host, path = rest[:i], rest[i:]
+	}
+	var userinfo string
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		userinfo, host = host[:i+1], host[i+1:]
+	}
+	socket, err = url.PathUnescape(host)
+	if err != nil {
+		return "", "", fullError(http.StatusBadRequest, ExitStatusURLMalformed, err)
+	}
+	if socket == "" {
+		return "", "", fullError(http.StatusBadRequest, ExitStatusURLMalformed, errors.New("no socket path specified"))
+	}
+	return socket, "http://" + userinfo + unixHost + path, nil
+}
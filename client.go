@@ -12,7 +12,9 @@ import (
 	"github.com/go-kivik/kivik/v4/driver"
 )
 
-func (c *client) AllDBs(ctx context.Context, opts map[string]interface{}) ([]string, error) {
+func (c *client) AllDBs(ctx context.Context, opts map[string]interface{}) (_ []string, err error) {
+	ctx, span := c.startSpan(ctx, "client.AllDBs", "")
+	defer endSpan(span, &err)
 	query, err := optionsToParams(opts)
 	if err != nil {
 		return nil, err
@@ -22,18 +24,22 @@ func (c *client) AllDBs(ctx context.Context, opts map[string]interface{}) ([]str
 	return allDBs, err
 }
 
-func (c *client) DBExists(ctx context.Context, dbName string, _ map[string]interface{}) (bool, error) {
+func (c *client) DBExists(ctx context.Context, dbName string, _ map[string]interface{}) (_ bool, err error) {
+	ctx, span := c.startSpan(ctx, "client.DBExists", dbName)
+	defer endSpan(span, &err)
 	if dbName == "" {
 		return false, missingArg("dbName")
 	}
-	_, err := c.DoError(ctx, http.MethodHead, dbName, nil)
+	_, err = c.DoError(ctx, http.MethodHead, dbName, nil)
 	if kivik.StatusCode(err) == http.StatusNotFound {
 		return false, nil
 	}
 	return err == nil, err
 }
 
-func (c *client) CreateDB(ctx context.Context, dbName string, opts map[string]interface{}) error {
+func (c *client) CreateDB(ctx context.Context, dbName string, opts map[string]interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "client.CreateDB", dbName)
+	defer endSpan(span, &err)
 	if dbName == "" {
 		return missingArg("dbName")
 	}
@@ -45,53 +51,150 @@ func (c *client) CreateDB(ctx context.Context, dbName string, opts map[string]in
 	return err
 }
 
-func (c *client) DestroyDB(ctx context.Context, dbName string, _ map[string]interface{}) error {
+func (c *client) DestroyDB(ctx context.Context, dbName string, _ map[string]interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "client.DestroyDB", dbName)
+	defer endSpan(span, &err)
 	if dbName == "" {
 		return missingArg("dbName")
 	}
-	_, err := c.DoError(ctx, http.MethodDelete, dbName, nil)
+	_, err = c.DoError(ctx, http.MethodDelete, dbName, nil)
 	return err
 }
 
+var dbUpdatesOptionsKey = &struct{ name string }{"db updates options"}
+
+// WithDBUpdatesOptions returns a new context based on ctx. DBUpdates called
+// with the returned context passes opts to the /_db_updates endpoint, such as
+// feed, since, timeout and heartbeat. The OptionReconnect option is also
+// supported, for continuous feeds. Without options, DBUpdates follows a
+// continuous feed of the updates from now on.
+//
+// Options are carried by the context only because the driver.DBUpdater
+// interface, through which kivik calls DBUpdates, takes no options. This is a
+// workaround, which will be removed once the interface accepts options.
+func WithDBUpdatesOptions(ctx context.Context, opts map[string]interface{}) context.Context {
+	return context.WithValue(ctx, dbUpdatesOptionsKey, opts)
+}
+
+func dbUpdatesOptions(ctx context.Context) map[string]interface{} {
+	opts := map[string]interface{}{}
+	ctxOpts, ok := ctx.Value(dbUpdatesOptionsKey).(map[string]interface{})
+	if !ok {
+		opts["feed"] = "continuous"
+		opts["since"] = "now"
+		return opts
+	}
+	for k, v := range ctxOpts {
+		opts[k] = v
+	}
+	return opts
+}
+
 func (c *client) DBUpdates(ctx context.Context) (updates driver.DBUpdates, err error) {
-	resp, err := c.DoReq(ctx, http.MethodGet, "/_db_updates?feed=continuous&since=now", nil)
+	ctx, span := c.startSpan(ctx, "client.DBUpdates", "")
+	defer endSpanOnError(span, &err)
+	opts := dbUpdatesOptions(ctx)
+	key := "results"
+	feed := opts["feed"]
+	if feed == "continuous" {
+		key = ""
+	}
+	policy, err := reconnectPolicy(opts)
 	if err != nil {
 		return nil, err
 	}
-	if err := chttp.ResponseError(resp); err != nil {
+	query, err := optionsToParams(opts)
+	if err != nil {
 		return nil, err
 	}
-	return newUpdates(ctx, resp.Body), nil
+	resp, err := openFeed(ctx, &feedRequest{
+		client:  c.Client,
+		method:  http.MethodGet,
+		path:    "/_db_updates",
+		options: &chttp.Options{Query: query},
+	}, policy)
+	if err != nil {
+		return nil, err
+	}
+	return newUpdates(ctx, key, endSpanOnClose(span, resp.Body)), nil
 }
 
 type couchUpdates struct {
 	*iter
+	meta *updatesMeta
 }
 
 var _ driver.DBUpdates = &couchUpdates{}
 
+type updatesMeta struct {
+	lastSeq sequenceID
+}
+
 type updatesParser struct{}
 
 var _ parser = &updatesParser{}
 
+func (p *updatesParser) parseMeta(i interface{}, dec *json.Decoder, key string) error {
+	meta := i.(*updatesMeta)
+	if key == "last_seq" {
+		return dec.Decode(&meta.lastSeq)
+	}
+	// Ignore other metadata, such as pending
+	var discard json.RawMessage
+	return dec.Decode(&discard)
+}
+
+// updateRow is a single row of the feed. The final row of a continuous feed
+// carries only last_seq.
+type updateRow struct {
+	*driver.DBUpdate
+	LastSeq *sequenceID `json:"last_seq"`
+}
+
 func (p *updatesParser) decodeItem(i interface{}, dec *json.Decoder) error {
 	return dec.Decode(i)
 }
 
-func newUpdates(ctx context.Context, body io.ReadCloser) *couchUpdates {
+// newUpdates returns an iterator over a _db_updates feed. key is "" for a
+// continuous feed.
+func newUpdates(ctx context.Context, key string, body io.ReadCloser) *couchUpdates {
+	meta := &updatesMeta{}
 	return &couchUpdates{
-		iter: newIter(ctx, nil, "", body, &updatesParser{}),
+		iter: newIter(ctx, meta, key, body, &updatesParser{}),
+		meta: meta,
 	}
 }
 
 func (u *couchUpdates) Next(update *driver.DBUpdate) error {
-	return u.iter.next(update)
+	for {
+		row := &updateRow{DBUpdate: update}
+		if err := u.iter.next(row); err != nil {
+			return err
+		}
+		if row.LastSeq != nil {
+			u.meta.lastSeq = *row.LastSeq
+			continue
+		}
+		if update.Seq != "" {
+			u.meta.lastSeq = sequenceID(update.Seq)
+		}
+		return nil
+	}
+}
+
+// LastSeq returns the sequence ID of the last update read, or the last_seq
+// reported by the server, which may be passed as the since option to resume
+// the feed.
+func (u *couchUpdates) LastSeq() string {
+	return string(u.meta.lastSeq)
 }
 
 // Ping queries the /_up endpoint, and returns true if there are no errors, or
 // if a 400 (Bad Request) is returned, and the Server: header indicates a server
 // version prior to 2.x.
-func (c *client) Ping(ctx context.Context) (bool, error) {
+func (c *client) Ping(ctx context.Context) (_ bool, err error) {
+	ctx, span := c.startSpan(ctx, "client.Ping", "")
+	defer endSpan(span, &err)
 	resp, err := c.DoError(ctx, http.MethodHead, "/_up", nil)
 	if kivik.StatusCode(err) == http.StatusBadRequest {
 		return strings.HasPrefix(resp.Header.Get("Server"), "CouchDB/1."), nil
@@ -3,6 +3,7 @@ package couchdb
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"testing"
 
@@ -283,14 +284,14 @@ func TestUpdatesNext(t *testing.T) {
 	}{
 		{
 			name:     "consumed feed",
-			updates:  newUpdates(context.TODO(), Body("")),
+			updates:  newUpdates(context.TODO(), "", Body("")),
 			expected: &driver.DBUpdate{},
 			status:   http.StatusInternalServerError,
 			err:      "EOF",
 		},
 		{
 			name:    "read feed",
-			updates: newUpdates(context.TODO(), Body(`{"db_name":"mailbox","type":"created","seq":"1-g1AAAAFReJzLYWBg4MhgTmHgzcvPy09JdcjLz8gvLskBCjMlMiTJ____PyuDOZExFyjAnmJhkWaeaIquGIf2JAUgmWQPMiGRAZcaB5CaePxqEkBq6vGqyWMBkgwNQAqobD4h"},`)),
+			updates: newUpdates(context.TODO(), "", Body(`{"db_name":"mailbox","type":"created","seq":"1-g1AAAAFReJzLYWBg4MhgTmHgzcvPy09JdcjLz8gvLskBCjMlMiTJ____PyuDOZExFyjAnmJhkWaeaIquGIf2JAUgmWQPMiGRAZcaB5CaePxqEkBq6vGqyWMBkgwNQAqobD4h"},`)),
 			expected: &driver.DBUpdate{
 				DBName: "mailbox",
 				Type:   "created",
@@ -312,7 +313,7 @@ func TestUpdatesNext(t *testing.T) {
 
 func TestUpdatesClose(t *testing.T) {
 	body := &closeTracker{ReadCloser: Body("")}
-	u := newUpdates(context.TODO(), body)
+	u := newUpdates(context.TODO(), "", body)
 	if err := u.Close(); err != nil {
 		t.Fatal(err)
 	}
@@ -380,3 +381,102 @@ func TestPing(t *testing.T) {
 		})
 	}
 }
+
+func TestDBUpdatesOptions(t *testing.T) {
+	type tt struct {
+		opts   map[string]interface{}
+		query  string
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("no options", tt{
+		query: "feed=continuous&since=now",
+	})
+	tests.Add("normal feed", tt{
+		opts:  map[string]interface{}{"since": "3-x"},
+		query: "since=3-x",
+	})
+	tests.Add("longpoll", tt{
+		opts:  map[string]interface{}{"feed": "longpoll", "since": "3-x", "timeout": 1000},
+		query: "feed=longpoll&since=3-x&timeout=1000",
+	})
+	tests.Add("reconnect", tt{
+		opts:  map[string]interface{}{"feed": "continuous", OptionReconnect: true},
+		query: "feed=continuous&heartbeat=10000",
+	})
+	tests.Add("reconnect normal feed", tt{
+		opts:   map[string]interface{}{OptionReconnect: true},
+		status: http.StatusBadRequest,
+		err:    "kivik: option 'kivik:reconnect' requires a continuous feed",
+	})
+	tests.Add("invalid option", tt{
+		opts:   map[string]interface{}{"since": 1.5},
+		status: http.StatusBadRequest,
+		err:    "kivik: invalid type float64 for options",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		client := newCustomClient(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path != "/_db_updates" {
+				t.Errorf("Unexpected path: %s", r.URL.Path)
+			}
+			if q := r.URL.RawQuery; q != tt.query {
+				t.Errorf("Unexpected query: %s", q)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       Body(`{"results":[],"last_seq":"3-x"}`),
+			}, nil
+		})
+		ctx := context.Background()
+		if tt.opts != nil {
+			ctx = WithDBUpdatesOptions(ctx, tt.opts)
+		}
+		updates, err := client.DBUpdates(ctx)
+		testy.StatusError(t, tt.err, tt.status, err)
+		_ = updates.Close()
+	})
+}
+
+func TestUpdatesLastSeq(t *testing.T) {
+	u := newUpdates(context.TODO(), "results", Body(`{"results":[
+{"db_name":"foo","type":"created","seq":"1-x"}
+],
+"last_seq":"2-x"}`))
+	var names []string
+	for {
+		update := new(driver.DBUpdate)
+		if err := u.Next(update); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		names = append(names, update.DBName)
+		if u.LastSeq() != "1-x" {
+			t.Errorf("Unexpected LastSeq: %s", u.LastSeq())
+		}
+	}
+	if d := testy.DiffInterface([]string{"foo"}, names); d != nil {
+		t.Error(d)
+	}
+	if u.LastSeq() != "2-x" {
+		t.Errorf("Unexpected LastSeq: %s", u.LastSeq())
+	}
+
+	u = newUpdates(context.TODO(), "", Body(`{"db_name":"foo","type":"created","seq":"1-x"}
+
+{"last_seq":"3-x"}`))
+	update := new(driver.DBUpdate)
+	if err := u.Next(update); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Next(update); err != io.EOF {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if u.LastSeq() != "3-x" {
+		t.Errorf("Unexpected LastSeq: %s", u.LastSeq())
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"github.com/go-kivik/couchdb/v4/chttp"
 )
 
-func (c *client) ClusterStatus(ctx context.Context, opts map[string]interface{}) (string, error) {
+func (c *client) ClusterStatus(ctx context.Context, opts map[string]interface{}) (_ string, err error) {
+	ctx, span := c.startSpan(ctx, "client.ClusterStatus", "")
+	defer endSpan(span, &err)
 	var result struct {
 		State string `json:"state"`
 	}
@@ -19,10 +21,12 @@ func (c *client) ClusterStatus(ctx context.Context, opts map[string]interface{})
 	return result.State, err
 }
 
-func (c *client) ClusterSetup(ctx context.Context, action interface{}) error {
+func (c *client) ClusterSetup(ctx context.Context, action interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "client.ClusterSetup", "")
+	defer endSpan(span, &err)
 	options := &chttp.Options{
 		Body: chttp.EncodeBody(action),
 	}
-	_, err := c.DoError(ctx, http.MethodPost, "/_cluster_setup", options)
+	_, err = c.DoError(ctx, http.MethodPost, "/_cluster_setup", options)
 	return err
 }
@@ -32,36 +32,46 @@ func configURL(node string, parts ...string) string {
 	return "/" + strings.Join(components, "/")
 }
 
-func (c *client) Config(ctx context.Context, node string) (driver.Config, error) {
+func (c *client) Config(ctx context.Context, node string) (_ driver.Config, err error) {
+	ctx, span := c.startSpan(ctx, "client.Config", "")
+	defer endSpan(span, &err)
 	cf := driver.Config{}
-	_, err := c.Client.DoJSON(ctx, http.MethodGet, configURL(node), nil, &cf)
+	_, err = c.Client.DoJSON(ctx, http.MethodGet, configURL(node), nil, &cf)
 	return cf, err
 }
 
-func (c *client) ConfigSection(ctx context.Context, node, section string) (driver.ConfigSection, error) {
+func (c *client) ConfigSection(ctx context.Context, node, section string) (_ driver.ConfigSection, err error) {
+	ctx, span := c.startSpan(ctx, "client.ConfigSection", "")
+	defer endSpan(span, &err)
 	sec := driver.ConfigSection{}
-	_, err := c.Client.DoJSON(ctx, http.MethodGet, configURL(node, section), nil, &sec)
+	_, err = c.Client.DoJSON(ctx, http.MethodGet, configURL(node, section), nil, &sec)
 	return sec, err
 }
 
-func (c *client) ConfigValue(ctx context.Context, node, section, key string) (string, error) {
+func (c *client) ConfigValue(ctx context.Context, node, section, key string) (_ string, err error) {
+	ctx, span := c.startSpan(ctx, "client.ConfigValue", "")
+	defer endSpan(span, &err)
 	var value string
-	_, err := c.Client.DoJSON(ctx, http.MethodGet, configURL(node, section, key), nil, &value)
+	_, err = c.Client.DoJSON(ctx, http.MethodGet, configURL(node, section, key), nil, &value)
 	return value, err
 }
 
-func (c *client) SetConfigValue(ctx context.Context, node, section, key, value string) (string, error) {
+func (c *client) SetConfigValue(ctx context.Context, node, section, key, value string) (_ string, err error) {
+	ctx, span := c.startSpan(ctx, "client.SetConfigValue", "")
+	defer endSpan(span, &err)
 	body, _ := json.Marshal(value) // Strings never cause JSON marshaling errors
 	var old string
 	opts := &chttp.Options{
 		Body: ioutil.NopCloser(bytes.NewReader(body)),
 	}
-	_, err := c.Client.DoJSON(ctx, http.MethodPut, configURL(node, section, key), opts, &old)
+	_, err = c.Client.DoJSON(ctx, http.MethodPut, configURL(node, section, key), opts, &old)
 	return old, err
 }
 
-func (c *client) DeleteConfigKey(ctx context.Context, node, section, key string) (string, error) {
+func (c *client) DeleteConfigKey(ctx context.Context, node, section, key string) (_ string, err error) {
+	ctx, span := c.startSpan(ctx, "client.DeleteConfigKey", "")
+	defer endSpan(span, &err)
 	var value string
-	_, err := c.Client.DoJSON(ctx, http.MethodDelete, configURL(node, section, key), nil, &value)
+	_, err = c.Client.DoJSON(ctx, http.MethodDelete, configURL(node, section, key), nil, &value)
 	return value, err
 }
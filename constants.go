@@ -12,6 +12,18 @@ const (
 	//    db.Put(ctx, "doc_id", doc, kivik.Options{couchdb.OptionFullCommit: true})
 	OptionFullCommit = "X-Couch-Full-Commit"
 
+	// OptionGzipRequest is the option key used to control gzip compression of
+	// the request body of BulkDocs, Put, CreateDoc and PutAttachment. A value
+	// of true enables compression of bodies larger than the client's
+	// GzipThreshold, or chttp.DefaultGzipThreshold if unset. An int sets the
+	// threshold, in bytes, for this request. A value of false disables
+	// compression.
+	//
+	// Example:
+	//
+	//    db.BulkDocs(ctx, docs, kivik.Options{couchdb.OptionGzipRequest: true})
+	OptionGzipRequest = "kivik:gzip-request"
+
 	// OptionIfNoneMatch is an option key to set the If-None-Match header on
 	// the request.
 	//
@@ -20,6 +32,22 @@ const (
 	//    row, err := db.Get(ctx, "doc_id", kivik.Options{couchdb.OptionIfNoneMatch: "1-xxx"})
 	OptionIfNoneMatch = "If-None-Match"
 
+	// OptionReconnect is the option key used to make a continuous changes or
+	// _db_updates feed recover from dropped or stalled connections, by
+	// reconnecting after the last sequence ID received. A value of true uses
+	// the default ReconnectPolicy, or a *ReconnectPolicy may be given. A since
+	// value of "now" is resolved to the current sequence ID before the feed is
+	// opened, at the cost of an extra request. See WithDBUpdatesOptions to pass
+	// options to DBUpdates.
+	//
+	// Example:
+	//
+	//    changes, err := db.Changes(ctx, kivik.Options{
+	//        "feed":                   "continuous",
+	//        couchdb.OptionReconnect: true,
+	//    })
+	OptionReconnect = "kivik:reconnect"
+
 	// NoMultipartPut instructs the Put() method not to use CouchDB's
 	// multipart/related upload capabilities. This only affects PUT requests that
 	// also include attachments.
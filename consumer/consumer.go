@@ -0,0 +1,335 @@
+// Package consumer provides a durable consumer of a CouchDB changes feed,
+// which passes batches of changes to a handler, and records its progress in a
+// _local document, so that processing resumes where it left off after a
+// restart.
+//
+// Example:
+//
+//     c := &consumer.Consumer{
+//         DB: db,
+//         ID: "search-indexer",
+//         Handler: func(ctx context.Context, batch []consumer.Change) error {
+//             return index(ctx, batch)
+//         },
+//         Options: kivik.Options{"include_docs": true},
+//     }
+//     go func() {
+//         <-sigterm
+//         _ = c.Shutdown(context.Background())
+//     }()
+//     if err := c.Run(ctx); err != nil {
+//         log.Fatal(err)
+//     }
+//
+// Delivery is at-least-once: a batch is acknowledged only when the handler
+// returns nil, and changes received since the last checkpoint are delivered
+// again after a restart.
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kivik/couchdb/v4"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// Default values used by a Consumer when the corresponding field is unset.
+const (
+	DefaultBatchSize    = 100
+	DefaultBatchTimeout = time.Second
+)
+
+// Change is a single change delivered to a Handler.
+type Change struct {
+	ID      string
+	Seq     string
+	Deleted bool
+	Changes []string
+
+	// Doc is the document, if the include_docs option was set.
+	Doc json.RawMessage
+}
+
+// Handler processes a batch of changes. Returning nil acknowledges the whole
+// batch. Returning an error stops the consumer, and the batch is delivered
+// again when it is restarted.
+type Handler func(ctx context.Context, batch []Change) error
+
+// Consumer follows the changes feed of a database, and checkpoints the
+// sequence ID of the last acknowledged change in the _local/<ID> document.
+type Consumer struct {
+	// DB is the database to follow.
+	DB *kivik.DB
+
+	// ID identifies the consumer, and names its checkpoint document.
+	ID string
+
+	// Handler processes changes.
+	Handler Handler
+
+	// BatchSize is the maximum number of changes passed to the handler at
+	// once. Defaults to DefaultBatchSize.
+	BatchSize int
+
+	// BatchTimeout is the time after which a partial batch is passed to the
+	// handler, rather than waiting for more changes. Defaults to
+	// DefaultBatchTimeout.
+	BatchTimeout time.Duration
+
+	// CheckpointInterval is the minimum time between two checkpoints. Zero
+	// means a checkpoint is written after every batch. A final checkpoint is
+	// always written when the consumer stops cleanly.
+	CheckpointInterval time.Duration
+
+	// Options are passed to db.Changes, for instance to include documents or
+	// to filter the feed. The since option applies only when no checkpoint
+	// exists yet. The feed is always continuous, and reconnects
+	// automatically, unless couchdb.OptionReconnect is set explicitly.
+	Options kivik.Options
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// checkpoint is the content of a consumer's _local document.
+type checkpoint struct {
+	Rev     string    `json:"_rev,omitempty"`
+	LastSeq string    `json:"last_seq"`
+	Updated time.Time `json:"updated"`
+}
+
+func (c *Consumer) docID() string {
+	return "_local/" + c.ID
+}
+
+func (c *Consumer) batchSize() int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+func (c *Consumer) batchTimeout() time.Duration {
+	if c.BatchTimeout > 0 {
+		return c.BatchTimeout
+	}
+	return DefaultBatchTimeout
+}
+
+// LastSeq returns the checkpointed sequence ID, or an empty string if the
+// consumer has not written a checkpoint yet.
+func (c *Consumer) LastSeq(ctx context.Context) (string, error) {
+	cp, err := c.load(ctx)
+	if err != nil {
+		return "", err
+	}
+	return cp.LastSeq, nil
+}
+
+func (c *Consumer) load(ctx context.Context) (*checkpoint, error) {
+	cp := &checkpoint{}
+	err := c.DB.Get(ctx, c.docID()).ScanDoc(cp)
+	if kivik.StatusCode(err) == http.StatusNotFound {
+		return &checkpoint{}, nil
+	}
+	return cp, err
+}
+
+func (c *Consumer) save(ctx context.Context, cp *checkpoint) error {
+	cp.Updated = time.Now().UTC()
+	rev, err := c.DB.Put(ctx, c.docID(), cp)
+	if err != nil {
+		return err
+	}
+	cp.Rev = rev
+	return nil
+}
+
+func (c *Consumer) start() (stop <-chan struct{}, done chan<- struct{}, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done != nil {
+		return nil, nil, errors.New("consumer: already running")
+	}
+	if c.stop == nil {
+		c.stop = make(chan struct{})
+	}
+	c.done = make(chan struct{})
+	return c.stop, c.done, nil
+}
+
+// Run consumes the changes feed, starting after the last checkpoint. It
+// returns nil after Shutdown is called or the feed ends, or the first error
+// from the feed, the handler or a checkpoint. If ctx is cancelled, Run
+// returns immediately, without writing a final checkpoint.
+func (c *Consumer) Run(ctx context.Context) error {
+	if c.ID == "" || c.Handler == nil {
+		return errors.New("consumer: ID and Handler are required")
+	}
+	stop, done, err := c.start()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.mu.Lock()
+		c.done = nil
+		c.mu.Unlock()
+		close(done)
+	}()
+	cp, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	feedCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts := kivik.Options{}
+	for k, v := range c.Options {
+		opts[k] = v
+	}
+	opts["feed"] = "continuous"
+	if cp.LastSeq != "" {
+		opts["since"] = cp.LastSeq
+	}
+	if _, ok := opts[couchdb.OptionReconnect]; !ok {
+		opts[couchdb.OptionReconnect] = true
+	}
+	changes, err := c.DB.Changes(feedCtx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Cancel first, to unblock a pending read.
+		cancel()
+		_ = changes.Close()
+	}()
+
+	rows := make(chan Change, c.batchSize())
+	feedErr := make(chan error, 1)
+	go func() {
+		defer close(rows)
+		for changes.Next() {
+			if changes.ID() == "" && changes.Seq() == "" {
+				// The final last_seq line of the feed
+				continue
+			}
+			row := Change{
+				ID:      changes.ID(),
+				Seq:     changes.Seq(),
+				Deleted: changes.Deleted(),
+				Changes: changes.Changes(),
+			}
+			_ = changes.ScanDoc(&row.Doc)
+			select {
+			case rows <- row:
+			case <-feedCtx.Done():
+				return
+			}
+		}
+		feedErr <- changes.Err()
+	}()
+
+	b := &batcher{Consumer: c, cp: cp, lastCheckpoint: time.Now()}
+	timer := time.NewTimer(c.batchTimeout())
+	defer timer.Stop()
+	for {
+		select {
+		case row, ok := <-rows:
+			if !ok {
+				if err := b.flush(ctx, true); err != nil {
+					return err
+				}
+				return <-feedErr
+			}
+			b.batch = append(b.batch, row)
+			if len(b.batch) < c.batchSize() {
+				continue
+			}
+		case <-timer.C:
+		case <-stop:
+			return b.flush(ctx, true)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := b.flush(ctx, false); err != nil {
+			return err
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(c.batchTimeout())
+	}
+}
+
+// batcher holds the state of a running consumer.
+type batcher struct {
+	*Consumer
+	batch          []Change
+	cp             *checkpoint
+	lastSeq        string
+	lastCheckpoint time.Time
+}
+
+// flush passes the current batch to the handler, and writes a checkpoint if
+// one is due, or if final is true.
+func (b *batcher) flush(ctx context.Context, final bool) error {
+	if len(b.batch) > 0 {
+		if err := b.Handler(ctx, b.batch); err != nil {
+			return err
+		}
+		b.lastSeq = b.batch[len(b.batch)-1].Seq
+		b.batch = nil
+	}
+	if !final && time.Since(b.lastCheckpoint) < b.CheckpointInterval {
+		return nil
+	}
+	return b.checkpoint(ctx)
+}
+
+func (b *batcher) checkpoint(ctx context.Context) error {
+	if b.lastSeq == "" || b.lastSeq == b.cp.LastSeq {
+		return nil
+	}
+	b.cp.LastSeq = b.lastSeq
+	if err := b.save(ctx, b.cp); err != nil {
+		return err
+	}
+	b.lastCheckpoint = time.Now()
+	return nil
+}
+
+// Shutdown stops the consumer gracefully: no more changes are read, the
+// changes already batched are passed to the handler, and a final checkpoint
+// is written. Shutdown waits for Run to return, or for ctx to be done. Once
+// shut down, a Consumer cannot be run again.
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if c.stop == nil {
+		c.stop = make(chan struct{})
+	}
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	done := c.done
+	c.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
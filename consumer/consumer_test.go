@@ -0,0 +1,158 @@
+package consumer_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	_ "github.com/go-kivik/couchdb/v4"
+	"github.com/go-kivik/couchdb/v4/consumer"
+	"github.com/go-kivik/couchdb/v4/couchdbtest"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// collector is a handler which records the batches it receives, and shuts
+// the consumer down once it has seen want changes.
+type collector struct {
+	c    *consumer.Consumer
+	want int
+
+	mu      sync.Mutex
+	batches [][]string
+	seen    int
+	fail    error
+}
+
+func (h *collector) handle(_ context.Context, batch []consumer.Change) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.fail != nil {
+		return h.fail
+	}
+	ids := make([]string, len(batch))
+	for i, change := range batch {
+		ids[i] = change.ID
+	}
+	h.batches = append(h.batches, ids)
+	if h.seen += len(batch); h.seen >= h.want {
+		go h.c.Shutdown(context.Background()) // nolint: errcheck
+	}
+	return nil
+}
+
+func newConsumer(db *kivik.DB, want int) (*consumer.Consumer, *collector) {
+	h := &collector{want: want}
+	h.c = &consumer.Consumer{
+		DB:           db,
+		ID:           "test-consumer",
+		Handler:      h.handle,
+		BatchSize:    2,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	return h.c, h
+}
+
+func run(t *testing.T, c *consumer.Consumer) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.Run(ctx)
+}
+
+func TestConsumerResume(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a", "b", "c")
+
+	c, h := newConsumer(db, 3)
+	if err := run(t, c); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([][]string{{"a", "b"}, {"c"}}, h.batches); d != nil {
+		t.Error(d)
+	}
+	seq, err := c.LastSeq(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(seq, "3-") {
+		t.Errorf("Unexpected checkpoint: %s", seq)
+	}
+
+	couchdbtest.PutDocs(t, db, "d")
+	c, h = newConsumer(db, 1)
+	if err := run(t, c); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([][]string{{"d"}}, h.batches); d != nil {
+		t.Error(d)
+	}
+	if seq, _ := c.LastSeq(context.Background()); !strings.HasPrefix(seq, "4-") {
+		t.Errorf("Unexpected checkpoint: %s", seq)
+	}
+}
+
+func TestConsumerRedelivery(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a", "b")
+
+	c, h := newConsumer(db, 2)
+	if err := run(t, c); err != nil {
+		t.Fatal(err)
+	}
+
+	couchdbtest.PutDocs(t, db, "c")
+	c, h = newConsumer(db, 1)
+	h.fail = errors.New("index unavailable")
+	if err := run(t, c); err == nil || err.Error() != "index unavailable" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	c, h = newConsumer(db, 1)
+	if err := run(t, c); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([][]string{{"c"}}, h.batches); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestConsumerShutdown(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a")
+
+	c, h := newConsumer(db, 100)
+	c.BatchTimeout = time.Hour
+	errc := make(chan error)
+	go func() {
+		errc <- run(t, c)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([][]string{{"a"}}, h.batches); d != nil {
+		t.Error(d)
+	}
+	if seq, _ := c.LastSeq(context.Background()); !strings.HasPrefix(seq, "1-") {
+		t.Errorf("Unexpected checkpoint: %s", seq)
+	}
+}
+
+func TestConsumerRequiredFields(t *testing.T) {
+	c := &consumer.Consumer{}
+	testy.Error(t, "consumer: ID and Handler are required", c.Run(context.Background()))
+}
@@ -19,6 +19,35 @@ type Couch struct {
 
 	// If provided, HTTPClient will be used for requests to the CouchDB server.
 	HTTPClient *http.Client
+
+	// If provided, TLS configures the TLS connections to the CouchDB server.
+	// The transport of HTTPClient, if set, must be an *http.Transport. TLS
+	// options may also be set with DSN query parameters, which take
+	// precedence. See chttp.NewWithClient for details.
+	TLS *chttp.TLSOptions
+
+	// If provided, Dial is used to establish all connections to the CouchDB
+	// server, for instance to connect through a Unix domain socket with
+	// chttp.UnixDialer. The transport of HTTPClient, if set, must be an
+	// *http.Transport. A Unix domain socket may also be specified in the DSN;
+	// see chttp.NewWithClient for details.
+	Dial chttp.DialFunc
+
+	// If provided, Metrics receives measurements of each request made to the
+	// CouchDB server. chttp.PrometheusMetrics provides an implementation which
+	// can be served to Prometheus.
+	Metrics chttp.Metrics
+
+	// If provided, Tracer is used to create a span for each driver operation,
+	// and a child span for each HTTP request.
+	Tracer chttp.Tracer
+
+	// If provided, Logger receives an entry for each request made to the
+	// CouchDB server. LogHeaders and LogBodyLimit control the detail logged;
+	// see chttp.Client for details.
+	Logger       chttp.Logger
+	LogHeaders   bool
+	LogBodyLimit int
 }
 
 var _ driver.Driver = &Couch{}
@@ -50,11 +79,24 @@ var _ driver.DBUpdater = &client{}
 // CookieAuth (or BasicAuth if compiled with GopherJS). If you wish to use a
 // different auth mechanism, do not specify credentials here, and instead call
 // Authenticate() later.
+//
+// The DSN may be a comma-separated list of node URLs, to distribute requests
+// among, and fail over between, the members of a CouchDB cluster. See
+// chttp.NewWithClient for details.
 func (d *Couch) NewClient(dsn string) (driver.Client, error) {
 	httpClient := d.HTTPClient
 	if httpClient == nil {
 		httpClient = &http.Client{}
 	}
+	if d.TLS != nil || d.Dial != nil {
+		transport, err := d.transport(httpClient.Transport)
+		if err != nil {
+			return nil, err
+		}
+		client := *httpClient
+		client.Transport = transport
+		httpClient = &client
+	}
 	chttpClient, err := chttp.NewWithClient(httpClient, dsn)
 	if err != nil {
 		return nil, err
@@ -66,11 +108,32 @@ func (d *Couch) NewClient(dsn string) (driver.Client, error) {
 	if d.UserAgent != "" {
 		chttpClient.UserAgents = append(chttpClient.UserAgents, d.UserAgent)
 	}
+	chttpClient.Metrics = d.Metrics
+	chttpClient.Tracer = d.Tracer
+	chttpClient.Logger = d.Logger
+	chttpClient.LogHeaders = d.LogHeaders
+	chttpClient.LogBodyLimit = d.LogBodyLimit
 	return &client{
 		Client: chttpClient,
 	}, nil
 }
 
+// transport returns a copy of base, configured according to d.TLS and d.Dial.
+func (d *Couch) transport(base http.RoundTripper) (http.RoundTripper, error) {
+	var err error
+	if d.TLS != nil {
+		if base, err = d.TLS.Transport(base); err != nil {
+			return nil, err
+		}
+	}
+	if d.Dial != nil {
+		if base, err = chttp.DialTransport(base, d.Dial); err != nil {
+			return nil, err
+		}
+	}
+	return base, nil
+}
+
 func (c *client) DB(_ context.Context, dbName string, _ map[string]interface{}) (driver.DB, error) {
 	if dbName == "" {
 		return nil, missingArg("dbName")
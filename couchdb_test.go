@@ -2,12 +2,15 @@ package couchdb
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"testing"
 	"time"
 
 	"gitlab.com/flimzy/testy"
 
+	"github.com/go-kivik/couchdb/v4/chttp"
 	kivik "github.com/go-kivik/kivik/v4"
 )
 
@@ -76,6 +79,52 @@ func TestNewClient(t *testing.T) {
 			t.Error("Unexpected *http.Client returned")
 		}
 	})
+	t.Run("TLS options", func(t *testing.T) {
+		httpClient := &http.Client{Timeout: time.Millisecond}
+		custom := &Couch{
+			HTTPClient: httpClient,
+			TLS:        &chttp.TLSOptions{ServerName: "couch"},
+		}
+		c, err := custom.NewClient("https://example.com/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport, ok := c.(*client).Client.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("Unexpected transport: %T", c.(*client).Client.Transport)
+		}
+		if transport.TLSClientConfig.ServerName != "couch" {
+			t.Errorf("Unexpected server name: %s", transport.TLSClientConfig.ServerName)
+		}
+		if httpClient.Transport != nil {
+			t.Error("HTTPClient was modified")
+		}
+	})
+	t.Run("custom dialer", func(t *testing.T) {
+		var dialed string
+		custom := &Couch{
+			Dial: func(_ context.Context, _, addr string) (net.Conn, error) {
+				dialed = addr
+				return nil, errors.New("dial failure")
+			},
+		}
+		c, err := custom.NewClient("http://example.com/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.(*client).DoError(context.Background(), http.MethodGet, "/", nil)
+		testy.ErrorRE(t, "dial failure", err)
+		if dialed != "example.com:80" {
+			t.Errorf("Unexpected address dialed: %s", dialed)
+		}
+	})
+	t.Run("invalid TLS options", func(t *testing.T) {
+		custom := &Couch{
+			TLS: &chttp.TLSOptions{CAFile: "/does/not/exist.pem"},
+		}
+		_, err := custom.NewClient("https://example.com/")
+		testy.StatusError(t, "open /does/not/exist.pem: no such file or directory", http.StatusBadRequest, err)
+	})
 }
 
 func TestDB(t *testing.T) {
@@ -0,0 +1,396 @@
+package couchdbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultFeedTimeout is the time after which an idle longpoll or continuous
+// feed without heartbeats is closed, as in CouchDB.
+const defaultFeedTimeout = 60 * time.Second
+
+type changesOptions struct {
+	feed        string
+	since       int64
+	sinceNow    bool
+	limit       int
+	descending  bool
+	includeDocs bool
+	conflicts   bool
+	allLeaves   bool
+	heartbeat   time.Duration
+	timeout     time.Duration
+	designOnly  bool
+	docIDs      map[string]bool
+}
+
+func parseChangesOptions(r *http.Request) (*changesOptions, error) {
+	q, err := parseQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	v := q.values
+	o := &changesOptions{
+		feed:        v.Get("feed"),
+		limit:       q.limit,
+		descending:  q.descending,
+		includeDocs: q.includeDocs,
+		conflicts:   q.conflicts,
+		allLeaves:   v.Get("style") == "all_docs",
+	}
+	since := v.Get("since")
+	if id := r.Header.Get("Last-Event-ID"); id != "" && o.feed == "eventsource" {
+		// A reconnecting EventSource client resumes after the last event.
+		since = id
+	}
+	switch since {
+	case "", "0":
+	case "now":
+		o.sinceNow = true
+	default:
+		if o.since, err = parseSeq(since); err != nil {
+			return nil, err
+		}
+	}
+	if hb := v.Get("heartbeat"); hb != "" {
+		if hb == "true" {
+			o.heartbeat = defaultFeedTimeout
+		} else if o.heartbeat, err = millis("heartbeat", hb); err != nil {
+			return nil, err
+		}
+	}
+	if t := v.Get("timeout"); t != "" {
+		if o.timeout, err = millis("timeout", t); err != nil {
+			return nil, err
+		}
+	} else if o.heartbeat == 0 {
+		o.timeout = defaultFeedTimeout
+	}
+
+	var body struct {
+		DocIDs   []string    `json:"doc_ids"`
+		Selector interface{} `json:"selector"`
+	}
+	if r.Method == http.MethodPost {
+		if err := readJSON(r, &body); err != nil {
+			return nil, err
+		}
+	}
+	switch filter := v.Get("filter"); filter {
+	case "":
+	case "_doc_ids":
+		if ids := v.Get("doc_ids"); ids != "" {
+			if err := json.Unmarshal([]byte(ids), &body.DocIDs); err != nil {
+				return nil, badRequest("`doc_ids` filter parameter is not a list of doc ids.")
+			}
+		}
+		if body.DocIDs == nil {
+			return nil, badRequest("`doc_ids` filter parameter is not a list of doc ids.")
+		}
+		o.docIDs = make(map[string]bool, len(body.DocIDs))
+		for _, id := range body.DocIDs {
+			o.docIDs[id] = true
+		}
+	case "_design":
+		o.designOnly = true
+	case "_selector":
+		return nil, notImplemented("The _selector filter")
+	default:
+		return nil, notImplemented("Filter " + filter)
+	}
+	return o, nil
+}
+
+func millis(name, value string) (time.Duration, error) {
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return 0, badRequest(fmt.Sprintf("Invalid value for %s: %q", name, value))
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+type changeRow struct {
+	seq int64
+	row map[string]interface{}
+}
+
+// changes returns the changes since the update sequence since.
+func (db *database) changes(since int64, o *changesOptions) []changeRow {
+	var docs []*document
+	for id, d := range db.docs {
+		if d.seq <= since ||
+			o.docIDs != nil && !o.docIDs[id] ||
+			o.designOnly && !isDesign(id) {
+			continue
+		}
+		docs = append(docs, d)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].seq < docs[j].seq
+	})
+	rows := make([]changeRow, len(docs))
+	for i, d := range docs {
+		leaves := d.leaves()
+		if !o.allLeaves {
+			leaves = leaves[:1]
+		}
+		changes := make([]map[string]string, len(leaves))
+		for i, leaf := range leaves {
+			changes[i] = map[string]string{"rev": leaf.id}
+		}
+		row := map[string]interface{}{
+			"seq":     formatSeq(d.seq),
+			"id":      d.id,
+			"changes": changes,
+		}
+		winner := leaves[0]
+		if winner.deleted {
+			row["deleted"] = true
+		}
+		if o.includeDocs {
+			row["doc"] = d.render(winner, &docOptions{conflicts: o.conflicts})
+		}
+		rows[i] = changeRow{seq: d.seq, row: row}
+	}
+	if o.descending {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return rows
+}
+
+func isDesign(id string) bool {
+	return len(id) > 8 && id[:8] == "_design/"
+}
+
+// feedState is a snapshot of a database, taken to serve a feed.
+type feedState struct {
+	rows    []changeRow
+	seq     int64
+	updated <-chan struct{}
+	deleted bool
+}
+
+func (s *Server) feedState(db *database, since int64, o *changesOptions) *feedState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &feedState{
+		rows:    db.changes(since, o),
+		seq:     db.seq,
+		updated: db.updated,
+		deleted: s.dbs[db.name] != db,
+	}
+}
+
+func (s *Server) serveChanges(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodHead {
+		return errBadMethod
+	}
+	o, err := parseChangesOptions(r)
+	if err != nil {
+		return err
+	}
+	db, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	if o.sinceNow {
+		o.since = db.seq
+	}
+	s.mu.Unlock()
+	switch o.feed {
+	case "", "normal":
+		return s.pollChanges(w, r, db, o, false)
+	case "longpoll":
+		return s.pollChanges(w, r, db, o, true)
+	case "continuous", "live":
+		return s.continuousChanges(w, r, db, o, false)
+	case "eventsource":
+		return s.continuousChanges(w, r, db, o, true)
+	}
+	return badRequest("Supported `feed` types: normal, continuous, live, longpoll, eventsource")
+}
+
+// feedTimers returns channels for heartbeats and the feed timeout, and a
+// function to reset the timeout.
+type feedTimers struct {
+	heartbeat *time.Ticker
+	timeout   *time.Timer
+	duration  time.Duration
+}
+
+func newFeedTimers(o *changesOptions) *feedTimers {
+	t := &feedTimers{duration: o.timeout}
+	if o.heartbeat > 0 {
+		t.heartbeat = time.NewTicker(o.heartbeat)
+	}
+	if o.timeout > 0 {
+		t.timeout = time.NewTimer(o.timeout)
+	}
+	return t
+}
+
+func (t *feedTimers) beats() <-chan time.Time {
+	if t.heartbeat == nil {
+		return nil
+	}
+	return t.heartbeat.C
+}
+
+func (t *feedTimers) expired() <-chan time.Time {
+	if t.timeout == nil {
+		return nil
+	}
+	return t.timeout.C
+}
+
+// reset restarts the timeout, which measures inactivity.
+func (t *feedTimers) reset() {
+	if t.timeout != nil {
+		t.timeout.Stop()
+		t.timeout = time.NewTimer(t.duration)
+	}
+}
+
+func (t *feedTimers) stop() {
+	if t.heartbeat != nil {
+		t.heartbeat.Stop()
+	}
+	if t.timeout != nil {
+		t.timeout.Stop()
+	}
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// pollChanges serves a normal or longpoll feed. A longpoll feed waits until
+// there is at least one change, or the timeout expires.
+func (s *Server) pollChanges(w http.ResponseWriter, r *http.Request, db *database, o *changesOptions, longpoll bool) error {
+	timers := newFeedTimers(o)
+	defer timers.stop()
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", typeJSON)
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+	state := s.feedState(db, o.since, o)
+wait:
+	for longpoll && len(state.rows) == 0 && !state.deleted {
+		select {
+		case <-state.updated:
+			state = s.feedState(db, o.since, o)
+		case <-timers.beats():
+			start()
+			_, _ = w.Write([]byte("\n"))
+			flush(w)
+		case <-timers.expired():
+			break wait
+		case <-r.Context().Done():
+			return nil
+		case <-s.done:
+			return nil
+		}
+	}
+	rows, lastSeq, pending := state.rows, state.seq, 0
+	if o.limit >= 0 && len(rows) > o.limit {
+		pending = len(rows) - o.limit
+		rows = rows[:o.limit]
+		lastSeq = rows[len(rows)-1].seq
+	}
+	start()
+	_, _ = w.Write([]byte(`{"results":[` + "\n"))
+	for i, row := range rows {
+		line, err := json.Marshal(row.row)
+		if err != nil {
+			return nil
+		}
+		if i > 0 {
+			_, _ = w.Write([]byte(",\n"))
+		}
+		_, _ = w.Write(line)
+	}
+	fmt.Fprintf(w, "\n],\n\"last_seq\":%q,\"pending\":%d}\n", formatSeq(lastSeq), pending)
+	return nil
+}
+
+// continuousChanges serves a continuous or eventsource feed, which sends
+// changes as they occur, until the limit is reached, the timeout expires, or
+// the client disconnects.
+func (s *Server) continuousChanges(w http.ResponseWriter, r *http.Request, db *database, o *changesOptions, eventSource bool) error {
+	timers := newFeedTimers(o)
+	defer timers.stop()
+	if eventSource {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", typeJSON)
+	}
+	w.WriteHeader(http.StatusOK)
+	flush(w)
+
+	since, sent := o.since, 0
+	end := func() {
+		if !eventSource {
+			fmt.Fprintf(w, "{\"last_seq\":%q,\"pending\":0}\n", formatSeq(since))
+		}
+	}
+	for {
+		state := s.feedState(db, since, o)
+		for _, row := range state.rows {
+			line, err := json.Marshal(row.row)
+			if err != nil {
+				return nil
+			}
+			if eventSource {
+				fmt.Fprintf(w, "data: %s\nid: %s\n\n", line, formatSeq(row.seq))
+			} else {
+				fmt.Fprintf(w, "%s\n", line)
+			}
+			since = row.seq
+			if sent++; o.limit >= 0 && sent >= o.limit {
+				end()
+				return nil
+			}
+		}
+		if len(state.rows) > 0 {
+			flush(w)
+			timers.reset()
+		}
+		if state.deleted {
+			end()
+			return nil
+		}
+	wait:
+		for {
+			select {
+			case <-state.updated:
+				break wait
+			case <-timers.beats():
+				if eventSource {
+					_, _ = w.Write([]byte("event: heartbeat\ndata: \n\n"))
+				} else {
+					_, _ = w.Write([]byte("\n"))
+				}
+				flush(w)
+			case <-timers.expired():
+				end()
+				return nil
+			case <-r.Context().Done():
+				return nil
+			case <-s.done:
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package couchdbtest_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/couchdb/v4/couchdbtest"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+func TestChangesNormal(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a", "b", "c")
+	ctx := context.Background()
+
+	changes, err := db.Changes(ctx, kivik.Options{"limit": 2, "include_docs": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	var seq string
+	for changes.Next() {
+		var doc map[string]interface{}
+		if err := changes.ScanDoc(&doc); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc["id"].(string))
+		seq = changes.Seq()
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b"}, ids); d != nil {
+		t.Error(d)
+	}
+	if !strings.HasPrefix(seq, "2-") {
+		t.Errorf("Unexpected seq %s", seq)
+	}
+	if changes.LastSeq() != seq || changes.Pending() != 1 {
+		t.Errorf("Unexpected last_seq %s, pending %d", changes.LastSeq(), changes.Pending())
+	}
+
+	changes, err = db.Changes(ctx, kivik.Options{"since": seq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = nil
+	for changes.Next() {
+		ids = append(ids, changes.ID())
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"c"}, ids); d != nil {
+		t.Error(d)
+	}
+
+	changes, err = db.Changes(ctx, kivik.Options{"filter": "_doc_ids", "doc_ids": []string{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = nil
+	for changes.Next() {
+		ids = append(ids, changes.ID())
+	}
+	if d := testy.DiffInterface([]string{"b"}, ids); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestChangesLongpoll(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		couchdbtest.PutDocs(t, db, "b")
+	}()
+	changes, err := db.Changes(context.Background(), kivik.Options{"feed": "longpoll", "since": "now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for changes.Next() {
+		ids = append(ids, changes.ID())
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"b"}, ids); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestChangesContinuous(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := db.Changes(ctx, kivik.Options{"feed": "continuous", "heartbeat": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		couchdbtest.PutDocs(t, db, "b")
+		if _, err := db.Delete(context.Background(), "a", changesRev(t, db, "a")); err != nil {
+			t.Error(err)
+		}
+	}()
+	type result struct {
+		ID      string
+		Deleted bool
+	}
+	var results []result
+	for len(results) < 3 && changes.Next() {
+		results = append(results, result{ID: changes.ID(), Deleted: changes.Deleted()})
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []result{{ID: "a"}, {ID: "b"}, {ID: "a", Deleted: true}}
+	if d := testy.DiffInterface(expected, results); d != nil {
+		t.Error(d)
+	}
+}
+
+func changesRev(t *testing.T, db *kivik.DB, id string) string {
+	t.Helper()
+	_, rev, err := db.GetMeta(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rev
+}
+
+func TestChangesContinuousTimeout(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a")
+
+	res, err := http.Get(s.URL + "/testdb/_changes?feed=continuous&timeout=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close() // nolint: errcheck
+	var lines []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], `{"last_seq":"1-`) {
+		t.Errorf("Unexpected feed: %q", lines)
+	}
+}
+
+func TestChangesEventSource(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a")
+
+	res, err := http.Get(s.URL + "/testdb/_changes?feed=eventsource&limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close() // nolint: errcheck
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Unexpected Content-Type: %s", ct)
+	}
+	scanner := bufio.NewScanner(res.Body)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], `data: {"changes":[{"rev":"1-`) || !strings.HasPrefix(lines[1], "id: 1-") {
+		t.Errorf("Unexpected feed: %q", lines)
+	}
+}
+
+func TestCloseEndsFeeds(t *testing.T) {
+	s := couchdbtest.NewServer()
+	db := s.NewDB(t, "testdb", nil)
+
+	changes, err := db.Changes(context.Background(), kivik.Options{"feed": "continuous", "heartbeat": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		for changes.Next() { // nolint: revive
+		}
+		close(done)
+	}()
+	s.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Feed not closed")
+	}
+}
+
+func TestChangesEventSourceResume(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a", "b")
+
+	req, err := http.NewRequest(http.MethodGet, s.URL+"/testdb/_changes?feed=eventsource&limit=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1-g1AAAAcouchdbtest")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close() // nolint: errcheck
+	scanner := bufio.NewScanner(res.Body)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "id: 2-") {
+		t.Errorf("Unexpected feed: %q", lines)
+	}
+
+	changes, err := db.Changes(context.Background(), kivik.Options{"feed": "eventsource", "limit": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for changes.Next() {
+		ids = append(ids, changes.ID())
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b"}, ids); d != nil {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,42 @@
+package couchdbtest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-kivik/couchdb/v4"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// NewDB creates the database name on s, and returns it, using a new client.
+// If transport is not nil, the client sends all subsequent requests through
+// it. Any error fails t.
+func (s *Server) NewDB(t testing.TB, name string, transport http.RoundTripper) *kivik.DB {
+	t.Helper()
+	ctx := context.Background()
+	client, err := kivik.New("couch", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateDB(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	if transport != nil {
+		if err := client.Authenticate(ctx, couchdb.SetTransport(transport)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client.DB(ctx, name)
+}
+
+// PutDocs creates a document in db for each of ids, with a single "id" field
+// set to its ID. Any error fails t.
+func PutDocs(t testing.TB, db *kivik.DB, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if _, err := db.Put(context.Background(), id, map[string]string{"id": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,641 @@
+package couchdbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// database is an in-memory database.
+type database struct {
+	name     string
+	seq      int64
+	docs     map[string]*document
+	local    map[string]*localDoc
+	security map[string]interface{}
+	log      *updateLog
+
+	// updated is closed, and replaced, whenever the database changes or is
+	// deleted, to wake waiting changes feeds.
+	updated chan struct{}
+}
+
+func newDatabase(name string, log *updateLog) *database {
+	return &database{
+		name:     name,
+		docs:     make(map[string]*document),
+		local:    make(map[string]*localDoc),
+		security: map[string]interface{}{},
+		log:      log,
+		updated:  make(chan struct{}),
+	}
+}
+
+// notify wakes any goroutines waiting for an update.
+func (db *database) notify() {
+	close(db.updated)
+	db.updated = make(chan struct{})
+}
+
+// formatSeq formats an update sequence number in the opaque style used by
+// CouchDB 2.x and later.
+func formatSeq(seq int64) string {
+	return strconv.FormatInt(seq, 10) + "-g1AAAAcouchdbtest"
+}
+
+// parseSeq parses a sequence as returned by formatSeq, or a plain integer.
+func parseSeq(seq string) (int64, error) {
+	n, err := strconv.ParseInt(strings.SplitN(seq, "-", 2)[0], 10, 64)
+	if err != nil {
+		return 0, badRequest("Malformed sequence supplied in 'since' parameter.")
+	}
+	return n, nil
+}
+
+// lookup returns the named database, with the server lock held. The caller
+// must call s.mu.Unlock.
+func (s *Server) lookup(name string) (*database, error) {
+	s.mu.Lock()
+	db, ok := s.dbs[name]
+	if !ok {
+		s.mu.Unlock()
+		return nil, errNoDB
+	}
+	return db, nil
+}
+
+func (s *Server) serveDB(w http.ResponseWriter, r *http.Request, name string) error {
+	switch r.Method {
+	case http.MethodPut:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.dbs[name]; ok {
+			return errDBExists
+		}
+		s.dbs[name] = newDatabase(name, s.updates)
+		s.updates.add(name, "created")
+		return writeJSON(w, http.StatusCreated, map[string]bool{"ok": true})
+	case http.MethodDelete:
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		delete(s.dbs, name)
+		db.notify()
+		s.updates.add(name, "deleted")
+		return writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	case http.MethodGet, http.MethodHead:
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		var docCount, delCount int
+		for _, doc := range db.docs {
+			if doc.winner().deleted {
+				delCount++
+			} else {
+				docCount++
+			}
+		}
+		return writeJSON(w, http.StatusOK, map[string]interface{}{
+			"db_name":             db.name,
+			"update_seq":          formatSeq(db.seq),
+			"purge_seq":           formatSeq(0),
+			"doc_count":           docCount,
+			"doc_del_count":       delCount,
+			"sizes":               map[string]int{"file": 0, "external": 0, "active": 0},
+			"cluster":             map[string]int{"q": 1, "n": 1, "w": 1, "r": 1},
+			"props":               map[string]interface{}{},
+			"disk_format_version": 8,
+			"compact_running":     false,
+			"instance_start_time": "0",
+		})
+	case http.MethodPost:
+		return s.createDoc(w, r, name)
+	}
+	return errBadMethod
+}
+
+func (s *Server) serveDBPath(w http.ResponseWriter, r *http.Request, name string, segments []string) error {
+	switch segments[0] {
+	case "_all_docs", "_design_docs", "_local_docs":
+		if len(segments) == 1 {
+			return s.serveAllDocs(w, r, name, segments[0])
+		}
+	case "_bulk_docs":
+		return s.serveBulkDocs(w, r, name)
+	case "_bulk_get":
+		return s.serveBulkGet(w, r, name)
+	case "_revs_diff":
+		return s.serveRevsDiff(w, r, name)
+	case "_changes":
+		return s.serveChanges(w, r, name)
+	case "_security":
+		return s.serveSecurity(w, r, name)
+	case "_ensure_full_commit":
+		return s.serveNoop(w, r, name, http.StatusCreated, map[string]interface{}{"ok": true, "instance_start_time": "0"})
+	case "_compact", "_view_cleanup":
+		return s.serveNoop(w, r, name, http.StatusAccepted, map[string]bool{"ok": true})
+	case "_find", "_index", "_explain":
+		return notImplemented("Mango")
+	case "_purge", "_purged_infos_limit", "_revs_limit", "_shards", "_partition":
+		return notImplemented(segments[0])
+	case "_design", "_local":
+		if len(segments) < 2 {
+			return badRequest("Invalid document ID")
+		}
+		id, err := url.QueryUnescape(segments[1])
+		if err != nil {
+			return badRequest("Invalid document ID")
+		}
+		id = segments[0] + "/" + id
+		rest := segments[2:]
+		if segments[0] == "_local" {
+			if len(rest) > 0 {
+				return badRequest("Local documents may not have attachments")
+			}
+			return s.serveLocalDoc(w, r, name, id)
+		}
+		if len(rest) > 0 && strings.HasPrefix(rest[0], "_") {
+			return notImplemented("Views")
+		}
+		return s.serveDocPath(w, r, name, id, rest)
+	default:
+		if strings.HasPrefix(segments[0], "_") {
+			return badRequest("Only reserved document ids may start with underscore.")
+		}
+		id, err := url.QueryUnescape(segments[0])
+		if err != nil {
+			return badRequest("Invalid document ID")
+		}
+		return s.serveDocPath(w, r, name, id, segments[1:])
+	}
+	return errMissing
+}
+
+func (s *Server) serveDocPath(w http.ResponseWriter, r *http.Request, name, id string, rest []string) error {
+	if len(rest) == 0 {
+		return s.serveDoc(w, r, name, id)
+	}
+	filename, err := url.PathUnescape(strings.Join(rest, "/"))
+	if err != nil {
+		return badRequest("Invalid attachment name")
+	}
+	return s.serveAttachment(w, r, name, id, filename)
+}
+
+func (s *Server) serveNoop(w http.ResponseWriter, r *http.Request, name string, status int, result interface{}) error {
+	if r.Method != http.MethodPost {
+		return errBadMethod
+	}
+	if _, err := s.lookup(name); err != nil {
+		return err
+	}
+	s.mu.Unlock()
+	return writeJSON(w, status, result)
+}
+
+func (s *Server) serveSecurity(w http.ResponseWriter, r *http.Request, name string) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		return writeJSON(w, http.StatusOK, db.security)
+	case http.MethodPut:
+		var sec map[string]interface{}
+		if err := readJSON(r, &sec); err != nil {
+			return err
+		}
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		db.security = sec
+		return writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	}
+	return errBadMethod
+}
+
+// query holds the common query parameters of GET requests.
+type query struct {
+	values       url.Values
+	descending   bool
+	limit        int
+	skip         int
+	includeDocs  bool
+	inclusiveEnd bool
+	conflicts    bool
+	updateSeq    bool
+	key          *string
+	startKey     *string
+	endKey       *string
+	keys         []string
+}
+
+func parseQuery(r *http.Request) (*query, error) {
+	v := r.URL.Query()
+	q := &query{values: v, limit: -1, inclusiveEnd: true}
+	var err error
+	for _, b := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"descending", &q.descending},
+		{"include_docs", &q.includeDocs},
+		{"inclusive_end", &q.inclusiveEnd},
+		{"conflicts", &q.conflicts},
+		{"update_seq", &q.updateSeq},
+	} {
+		if *b.dst, err = boolParam(v, b.name, *b.dst); err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range []struct {
+		name string
+		dst  *int
+	}{
+		{"limit", &q.limit},
+		{"skip", &q.skip},
+	} {
+		if s := v.Get(i.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return nil, badRequest(fmt.Sprintf("Invalid value for %s: %q", i.name, s))
+			}
+			*i.dst = n
+		}
+	}
+	for _, k := range []struct {
+		names []string
+		dst   **string
+	}{
+		{[]string{"key"}, &q.key},
+		{[]string{"startkey", "start_key"}, &q.startKey},
+		{[]string{"endkey", "end_key"}, &q.endKey},
+	} {
+		for _, name := range k.names {
+			if s := v.Get(name); s != "" {
+				var key string
+				if err := json.Unmarshal([]byte(s), &key); err != nil {
+					return nil, badRequest(fmt.Sprintf("Invalid value for %s: %s", name, s))
+				}
+				*k.dst = &key
+			}
+		}
+	}
+	if s := v.Get("keys"); s != "" {
+		if err := json.Unmarshal([]byte(s), &q.keys); err != nil {
+			return nil, badRequest("`keys` parameter must be an array.")
+		}
+	}
+	return q, nil
+}
+
+func boolParam(v url.Values, name string, def bool) (bool, error) {
+	switch v.Get(name) {
+	case "":
+		return def, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, badRequest(fmt.Sprintf("Invalid boolean parameter: %q", v.Get(name)))
+}
+
+// inRange reports whether id falls between the start and end keys of q.
+func (q *query) inRange(id string) bool {
+	if q.key != nil && id != *q.key {
+		return false
+	}
+	before, after := func(a, b string) bool { return a < b }, func(a, b string) bool { return a > b }
+	if q.descending {
+		before, after = after, before
+	}
+	if q.startKey != nil && before(id, *q.startKey) {
+		return false
+	}
+	if q.endKey != nil && (after(id, *q.endKey) || (!q.inclusiveEnd && id == *q.endKey)) {
+		return false
+	}
+	return true
+}
+
+// rowWriter streams rows in the format used by CouchDB for _all_docs and
+// views.
+type rowWriter struct {
+	w     http.ResponseWriter
+	count int
+}
+
+func newRowWriter(w http.ResponseWriter, header string) *rowWriter {
+	w.Header().Set("Content-Type", typeJSON)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(header + "\r\n"))
+	return &rowWriter{w: w}
+}
+
+func (rw *rowWriter) row(v interface{}) error {
+	row, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if rw.count > 0 {
+		_, _ = rw.w.Write([]byte(",\r\n"))
+	}
+	rw.count++
+	_, err = rw.w.Write(row)
+	return err
+}
+
+func (rw *rowWriter) close(footer string) {
+	_, _ = rw.w.Write([]byte("\r\n" + footer + "\n"))
+}
+
+func (s *Server) serveAllDocs(w http.ResponseWriter, r *http.Request, name, kind string) error {
+	q, err := parseQuery(r)
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		var body struct {
+			Keys []string `json:"keys"`
+		}
+		if err := readJSON(r, &body); err != nil {
+			return err
+		}
+		if body.Keys != nil {
+			q.keys = body.Keys
+		}
+	default:
+		return errBadMethod
+	}
+	db, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	rows, total, offset := db.allDocs(kind, q)
+	updateSeq := db.seq
+	s.mu.Unlock()
+
+	header := fmt.Sprintf(`{"total_rows":%d,"offset":%d,`, total, offset)
+	if q.keys != nil {
+		header = fmt.Sprintf(`{"total_rows":%d,`, total)
+	}
+	if q.updateSeq {
+		header += fmt.Sprintf(`"update_seq":%q,`, formatSeq(updateSeq))
+	}
+	rw := newRowWriter(w, header+`"rows":[`)
+	for _, row := range rows {
+		if err := rw.row(row); err != nil {
+			return nil
+		}
+	}
+	rw.close("]}")
+	return nil
+}
+
+// allDocs returns the rows of an _all_docs, _design_docs or _local_docs
+// query, the total number of rows, and the offset of the first row.
+func (db *database) allDocs(kind string, q *query) (rows []map[string]interface{}, total, offset int) {
+	var ids []string
+	if kind == "_local_docs" {
+		for id := range db.local {
+			ids = append(ids, id)
+		}
+	} else {
+		for id, doc := range db.docs {
+			if kind == "_design_docs" && !strings.HasPrefix(id, "_design/") {
+				continue
+			}
+			if !doc.winner().deleted {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	total = len(ids)
+
+	if q.keys != nil {
+		for _, key := range q.keys {
+			rows = append(rows, db.allDocsRow(key, q))
+		}
+		return rows, total, 0
+	}
+	if q.descending {
+		reverse(ids)
+	}
+	selected := make([]string, 0, len(ids))
+	for i, id := range ids {
+		if q.inRange(id) {
+			selected = append(selected, id)
+		} else if len(selected) == 0 {
+			offset = i + 1
+		}
+	}
+	offset += q.skip
+	if offset > total {
+		offset = total
+	}
+	for _, id := range page(selected, q.skip, q.limit) {
+		rows = append(rows, db.allDocsRow(id, q))
+	}
+	return rows, total, offset
+}
+
+func (db *database) allDocsRow(id string, q *query) map[string]interface{} {
+	if local, ok := db.local[id]; ok {
+		row := map[string]interface{}{"id": id, "key": id, "value": map[string]string{"rev": local.revID()}}
+		if q.includeDocs {
+			row["doc"] = local.render(id)
+		}
+		return row
+	}
+	doc, ok := db.docs[id]
+	if !ok {
+		return map[string]interface{}{"key": id, "error": "not_found"}
+	}
+	winner := doc.winner()
+	if winner.deleted {
+		return map[string]interface{}{
+			"id":    id,
+			"key":   id,
+			"value": map[string]interface{}{"rev": winner.id, "deleted": true},
+			"doc":   nil,
+		}
+	}
+	row := map[string]interface{}{"id": id, "key": id, "value": map[string]string{"rev": winner.id}}
+	if q.includeDocs {
+		row["doc"] = doc.render(winner, &docOptions{conflicts: q.conflicts})
+	}
+	return row
+}
+
+func (s *Server) serveBulkDocs(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodPost {
+		return errBadMethod
+	}
+	var body struct {
+		Docs     []map[string]interface{} `json:"docs"`
+		NewEdits *bool                    `json:"new_edits"`
+	}
+	if err := readJSON(r, &body); err != nil {
+		return err
+	}
+	if body.Docs == nil {
+		return badRequest("POST body must include `docs` parameter.")
+	}
+	newEdits := body.NewEdits == nil || *body.NewEdits
+	db, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	results := make([]map[string]interface{}, 0, len(body.Docs))
+	for _, doc := range body.Docs {
+		id, _ := doc["_id"].(string)
+		if id == "" {
+			id = randomHex(16)
+		}
+		var rev string
+		var err error
+		if newEdits {
+			rev, err = db.put(id, doc)
+		} else {
+			err = db.replicate(id, doc)
+		}
+		switch {
+		case err != nil:
+			result := map[string]interface{}{"id": id, "error": "unknown_error", "reason": err.Error()}
+			if ce, ok := err.(*couchError); ok {
+				result["error"], result["reason"] = ce.err, ce.reason
+			}
+			results = append(results, result)
+		case newEdits:
+			results = append(results, map[string]interface{}{"ok": true, "id": id, "rev": rev})
+		}
+	}
+	s.mu.Unlock()
+	return writeJSON(w, http.StatusCreated, results)
+}
+
+type bulkGetRef struct {
+	ID  string `json:"id"`
+	Rev string `json:"rev"`
+}
+
+func (s *Server) serveBulkGet(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodPost {
+		return errBadMethod
+	}
+	var raw json.RawMessage
+	if err := readJSON(r, &raw); err != nil {
+		return err
+	}
+	// The body is {"docs":[...]}, but a bare array is also accepted.
+	var refs []bulkGetRef
+	if err := json.Unmarshal(raw, &refs); err != nil {
+		var body struct {
+			Docs []bulkGetRef `json:"docs"`
+		}
+		if err := json.Unmarshal(raw, &body); err != nil || body.Docs == nil {
+			return badRequest("Missing JSON list of 'docs'.")
+		}
+		refs = body.Docs
+	}
+	opts, err := parseDocOptions(r)
+	if err != nil {
+		return err
+	}
+	db, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	results := make([]map[string]interface{}, len(refs))
+	for i, ref := range refs {
+		var result map[string]interface{}
+		if doc, ok := db.docs[ref.ID]; ok {
+			rev := doc.winner()
+			if ref.Rev != "" {
+				rev = doc.revs[ref.Rev]
+			}
+			if rev != nil && !rev.pruned {
+				result = map[string]interface{}{"ok": doc.render(rev, opts)}
+			}
+		}
+		if result == nil {
+			result = map[string]interface{}{"error": map[string]string{
+				"id":     ref.ID,
+				"rev":    ref.Rev,
+				"error":  "not_found",
+				"reason": "missing",
+			}}
+		}
+		results[i] = map[string]interface{}{
+			"id":   ref.ID,
+			"docs": []map[string]interface{}{result},
+		}
+	}
+	s.mu.Unlock()
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+func (s *Server) serveRevsDiff(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodPost {
+		return errBadMethod
+	}
+	var revMap map[string][]string
+	if err := readJSON(r, &revMap); err != nil {
+		return err
+	}
+	db, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	results := make(map[string]interface{})
+	for id, revs := range revMap {
+		doc := db.docs[id]
+		var missing []string
+		maxGen := 0
+		for _, rev := range revs {
+			if doc != nil {
+				if _, ok := doc.revs[rev]; ok {
+					continue
+				}
+			}
+			missing = append(missing, rev)
+			if gen := revGen(rev); gen > maxGen {
+				maxGen = gen
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		result := map[string]interface{}{"missing": missing}
+		if doc != nil {
+			var ancestors []string
+			for _, leaf := range doc.leaves() {
+				if revGen(leaf.id) < maxGen {
+					ancestors = append(ancestors, leaf.id)
+				}
+			}
+			if len(ancestors) > 0 {
+				result["possible_ancestors"] = ancestors
+			}
+		}
+		results[id] = result
+	}
+	s.mu.Unlock()
+	return writeJSON(w, http.StatusOK, results)
+}
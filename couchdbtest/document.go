@@ -0,0 +1,898 @@
+package couchdbtest
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// document is a document and its revision tree.
+type document struct {
+	id   string
+	seq  int64
+	revs map[string]*revision
+}
+
+// revision is a node in a document's revision tree.
+type revision struct {
+	id      string
+	parent  string
+	deleted bool
+	leaf    bool
+	// pruned is true for ancestors known only from the revision history of a
+	// replicated revision, whose content is unavailable.
+	pruned bool
+	body   map[string]interface{}
+	atts   map[string]*attachment
+}
+
+type attachment struct {
+	contentType string
+	data        []byte
+	digest      string
+	revpos      int
+}
+
+func newAttachment(contentType string, data []byte, revpos int) *attachment {
+	sum := md5.Sum(data)
+	return &attachment{
+		contentType: contentType,
+		data:        data,
+		digest:      "md5-" + base64.StdEncoding.EncodeToString(sum[:]),
+		revpos:      revpos,
+	}
+}
+
+// etag returns the ETag of the attachment, which is the quoted, base64-encoded
+// MD5 digest of its content.
+func (a *attachment) etag() string {
+	return `"` + strings.TrimPrefix(a.digest, "md5-") + `"`
+}
+
+// revGen returns the generation of rev, or 0 if it is malformed.
+func revGen(rev string) int {
+	gen, _ := strconv.Atoi(strings.SplitN(rev, "-", 2)[0])
+	return gen
+}
+
+// wins reports whether leaf a is preferred to leaf b as the winning revision.
+// As in CouchDB, live revisions beat deleted ones, then the longest revision
+// history wins, with ties broken by the greater revision ID.
+func wins(a, b *revision) bool {
+	if a.deleted != b.deleted {
+		return !a.deleted
+	}
+	if genA, genB := revGen(a.id), revGen(b.id); genA != genB {
+		return genA > genB
+	}
+	return a.id > b.id
+}
+
+// leaves returns the leaf revisions of d, with the winner first.
+func (d *document) leaves() []*revision {
+	leaves := make([]*revision, 0, 1)
+	for _, rev := range d.revs {
+		if rev.leaf {
+			leaves = append(leaves, rev)
+		}
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return wins(leaves[i], leaves[j])
+	})
+	return leaves
+}
+
+// winner returns the winning revision of d.
+func (d *document) winner() *revision {
+	return d.leaves()[0]
+}
+
+// history returns the revision hashes from rev back to the root of its
+// branch, as reported in _revisions.
+func (d *document) history(rev *revision) []string {
+	var ids []string
+	for r := rev; r != nil; r = d.revs[r.parent] {
+		ids = append(ids, strings.SplitN(r.id, "-", 2)[1])
+	}
+	return ids
+}
+
+// newRevID returns a revision ID for rev, derived from its content.
+func newRevID(gen int, rev *revision) string {
+	h := md5.New()
+	body, _ := json.Marshal(rev.body)
+	_, _ = h.Write(body)
+	fmt.Fprintf(h, "%s %t", rev.parent, rev.deleted)
+	names := make([]string, 0, len(rev.atts))
+	for name := range rev.atts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, " %s %s", name, rev.atts[name].digest)
+	}
+	return fmt.Sprintf("%d-%x", gen, h.Sum(nil))
+}
+
+// docMeta holds the special fields of a document sent by a client.
+type docMeta struct {
+	rev       string
+	deleted   bool
+	atts      map[string]interface{}
+	revisions *struct {
+		Start int      `json:"start"`
+		IDs   []string `json:"ids"`
+	}
+}
+
+// splitDoc separates the special fields from the body of doc.
+func splitDoc(doc map[string]interface{}) (map[string]interface{}, *docMeta, error) {
+	body := make(map[string]interface{}, len(doc))
+	meta := &docMeta{}
+	for k, v := range doc {
+		switch k {
+		case "_id", "_conflicts", "_deleted_conflicts", "_revs_info", "_local_seq":
+		case "_rev":
+			meta.rev, _ = v.(string)
+		case "_deleted":
+			meta.deleted, _ = v.(bool)
+		case "_attachments":
+			atts, ok := v.(map[string]interface{})
+			if !ok && v != nil {
+				return nil, nil, badRequest("_attachments must be an object")
+			}
+			meta.atts = atts
+		case "_revisions":
+			raw, _ := json.Marshal(v)
+			if err := json.Unmarshal(raw, &meta.revisions); err != nil {
+				return nil, nil, badRequest("Invalid _revisions")
+			}
+		default:
+			if strings.HasPrefix(k, "_") {
+				return nil, nil, &couchError{status: http.StatusBadRequest, err: "doc_validation", reason: "Bad special document member: " + k}
+			}
+			body[k] = v
+		}
+	}
+	return body, meta, nil
+}
+
+// buildAttachments returns the attachments described by raw, the
+// _attachments field of a document being stored as generation gen. Stubs
+// refer to the attachments of parent.
+func buildAttachments(docID string, raw map[string]interface{}, parent *revision, gen int) (map[string]*attachment, error) {
+	atts := make(map[string]*attachment, len(raw))
+	for name, v := range raw {
+		meta, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, badRequest("Invalid attachment " + name)
+		}
+		if stub, _ := meta["stub"].(bool); stub {
+			var att *attachment
+			if parent != nil {
+				att = parent.atts[name]
+			}
+			if att == nil {
+				return nil, &couchError{
+					status: http.StatusPreconditionFailed,
+					err:    "missing_stub",
+					reason: fmt.Sprintf("Invalid attachment stub in %s for %s", docID, name),
+				}
+			}
+			atts[name] = att
+			continue
+		}
+		data, ok := meta["data"].(string)
+		if !ok {
+			return nil, badRequest("Invalid attachment " + name)
+		}
+		content, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, badRequest("Invalid attachment data for " + name)
+		}
+		contentType, _ := meta["content_type"].(string)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		atts[name] = newAttachment(contentType, content, gen)
+	}
+	return atts, nil
+}
+
+// stubs returns the _attachments field of a document which retains the
+// attachments of rev.
+func stubs(rev *revision) map[string]interface{} {
+	atts := make(map[string]interface{}, len(rev.atts))
+	for name := range rev.atts {
+		atts[name] = map[string]interface{}{"stub": true}
+	}
+	return atts
+}
+
+// put stores a new revision of the document id, as for a PUT request, and
+// returns the new revision ID.
+func (db *database) put(id string, doc map[string]interface{}) (string, error) {
+	body, meta, err := splitDoc(doc)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(id, "_local/") {
+		return db.putLocal(id, body, meta.rev, meta.deleted)
+	}
+	d := db.docs[id]
+	var parent *revision
+	switch {
+	case d == nil && meta.rev != "":
+		return "", errConflict
+	case d == nil:
+	case meta.rev == "":
+		// A deleted document may be recreated without a revision.
+		if parent = d.winner(); !parent.deleted {
+			return "", errConflict
+		}
+	default:
+		if parent = d.revs[meta.rev]; parent == nil || !parent.leaf {
+			return "", errConflict
+		}
+	}
+	gen := 1
+	rev := &revision{deleted: meta.deleted, leaf: true, body: body}
+	if parent != nil {
+		gen = revGen(parent.id) + 1
+		rev.parent = parent.id
+	}
+	if rev.atts, err = buildAttachments(id, meta.atts, parent, gen); err != nil {
+		return "", err
+	}
+	rev.id = newRevID(gen, rev)
+	if d == nil {
+		d = &document{id: id, revs: make(map[string]*revision)}
+		db.docs[id] = d
+	}
+	if parent != nil {
+		parent.leaf = false
+	}
+	d.revs[rev.id] = rev
+	db.bump(d)
+	return rev.id, nil
+}
+
+// replicate stores a revision of the document id, with its revision ID and
+// history as provided, as for new_edits=false. Conflicts are created rather
+// than rejected.
+func (db *database) replicate(id string, doc map[string]interface{}) error {
+	body, meta, err := splitDoc(doc)
+	if err != nil {
+		return err
+	}
+	if meta.rev == "" || revGen(meta.rev) == 0 {
+		return badRequest("Document rev is required when new_edits is false")
+	}
+	if strings.HasPrefix(id, "_local/") {
+		db.local[id] = &localDoc{rev: revGen(meta.rev), body: body}
+		return nil
+	}
+	history := []string{meta.rev}
+	if meta.revisions != nil && len(meta.revisions.IDs) > 0 {
+		history = make([]string, len(meta.revisions.IDs))
+		for i, hash := range meta.revisions.IDs {
+			history[i] = fmt.Sprintf("%d-%s", meta.revisions.Start-i, hash)
+		}
+		if history[0] != meta.rev {
+			return badRequest("_rev does not match _revisions")
+		}
+	}
+	d := db.docs[id]
+	if d == nil {
+		d = &document{id: id, revs: make(map[string]*revision)}
+	}
+	if _, ok := d.revs[meta.rev]; ok {
+		return nil
+	}
+	var parent *revision
+	if len(history) > 1 {
+		parent = d.revs[history[1]]
+	}
+	atts, err := buildAttachments(id, meta.atts, parent, revGen(meta.rev))
+	if err != nil {
+		return err
+	}
+	for i := len(history) - 1; i > 0; i-- {
+		if rev, ok := d.revs[history[i]]; ok {
+			rev.leaf = false
+			continue
+		}
+		rev := &revision{id: history[i], pruned: true}
+		if i+1 < len(history) {
+			rev.parent = history[i+1]
+		}
+		d.revs[rev.id] = rev
+	}
+	rev := &revision{id: meta.rev, deleted: meta.deleted, leaf: true, body: body, atts: atts}
+	if len(history) > 1 {
+		rev.parent = history[1]
+	}
+	d.revs[rev.id] = rev
+	db.docs[id] = d
+	db.bump(d)
+	return nil
+}
+
+// bump assigns the next update sequence to d, and notifies waiting feeds.
+func (db *database) bump(d *document) {
+	db.seq++
+	d.seq = db.seq
+	db.notify()
+	db.log.add(db.name, "updated")
+}
+
+// docOptions are the query parameters which control the rendering of a
+// document.
+type docOptions struct {
+	revs             bool
+	revsInfo         bool
+	conflicts        bool
+	deletedConflicts bool
+	attachments      bool
+}
+
+func parseDocOptions(r *http.Request) (*docOptions, error) {
+	v := r.URL.Query()
+	opts := &docOptions{}
+	meta, err := boolParam(v, "meta", false)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"revs", &opts.revs},
+		{"revs_info", &opts.revsInfo},
+		{"conflicts", &opts.conflicts},
+		{"deleted_conflicts", &opts.deletedConflicts},
+		{"attachments", &opts.attachments},
+	} {
+		if *b.dst, err = boolParam(v, b.name, false); err != nil {
+			return nil, err
+		}
+	}
+	if meta {
+		opts.revsInfo, opts.conflicts, opts.deletedConflicts = true, true, true
+	}
+	return opts, nil
+}
+
+// render returns the JSON representation of revision rev of d.
+func (d *document) render(rev *revision, opts *docOptions) map[string]interface{} {
+	if opts == nil {
+		opts = &docOptions{}
+	}
+	doc := make(map[string]interface{}, len(rev.body)+2)
+	for k, v := range rev.body {
+		doc[k] = v
+	}
+	doc["_id"] = d.id
+	doc["_rev"] = rev.id
+	if rev.deleted {
+		doc["_deleted"] = true
+	}
+	if len(rev.atts) > 0 {
+		atts := make(map[string]interface{}, len(rev.atts))
+		for name, att := range rev.atts {
+			meta := map[string]interface{}{
+				"content_type": att.contentType,
+				"digest":       att.digest,
+				"length":       len(att.data),
+				"revpos":       att.revpos,
+			}
+			if opts.attachments {
+				meta["data"] = att.data
+			} else {
+				meta["stub"] = true
+			}
+			atts[name] = meta
+		}
+		doc["_attachments"] = atts
+	}
+	if opts.revs {
+		doc["_revisions"] = map[string]interface{}{
+			"start": revGen(rev.id),
+			"ids":   d.history(rev),
+		}
+	}
+	if opts.revsInfo {
+		var info []map[string]string
+		for r := rev; r != nil; r = d.revs[r.parent] {
+			status := "available"
+			switch {
+			case r.pruned:
+				status = "missing"
+			case r.deleted:
+				status = "deleted"
+			}
+			info = append(info, map[string]string{"rev": r.id, "status": status})
+		}
+		doc["_revs_info"] = info
+	}
+	var conflicts, deletedConflicts []string
+	for _, leaf := range d.leaves() {
+		switch {
+		case leaf == rev:
+		case leaf.deleted:
+			deletedConflicts = append(deletedConflicts, leaf.id)
+		default:
+			conflicts = append(conflicts, leaf.id)
+		}
+	}
+	if opts.conflicts && len(conflicts) > 0 {
+		doc["_conflicts"] = conflicts
+	}
+	if opts.deletedConflicts && len(deletedConflicts) > 0 {
+		doc["_deleted_conflicts"] = deletedConflicts
+	}
+	return doc
+}
+
+// selectRev returns the revision requested by the rev query parameter, or the
+// winning revision.
+func selectRev(d *document, rev string) (*revision, error) {
+	if rev == "" {
+		winner := d.winner()
+		if winner.deleted {
+			return nil, notFound("deleted")
+		}
+		return winner, nil
+	}
+	r, ok := d.revs[rev]
+	if !ok || r.pruned {
+		return nil, errMissing
+	}
+	return r, nil
+}
+
+// requestRev returns the revision specified by the rev query parameter, or
+// the If-Match header.
+func requestRev(r *http.Request) string {
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		return rev
+	}
+	return strings.Trim(r.Header.Get("If-Match"), `"`)
+}
+
+// readDoc reads a document from the body of r, which may be JSON, or
+// multipart/related with attachments following the document.
+func readDoc(r *http.Request) (map[string]interface{}, error) {
+	ct, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var doc map[string]interface{}
+	if ct != typeMPRelated {
+		if err := readJSON(r, &doc); err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			return nil, badRequest("Document must be a JSON object")
+		}
+		return doc, nil
+	}
+	body, err := requestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, badRequest("Invalid multipart body")
+	}
+	if err := json.NewDecoder(part).Decode(&doc); err != nil || doc == nil {
+		return nil, errBadJSON
+	}
+	atts, _ := doc["_attachments"].(map[string]interface{})
+	names := make([]string, 0, len(atts))
+	for name, v := range atts {
+		if meta, ok := v.(map[string]interface{}); ok && meta["follows"] == true {
+			names = append(names, name)
+		}
+	}
+	// Attachments follow the document in the order of the _attachments
+	// object, which is sorted when encoded by the driver.
+	sort.Strings(names)
+	for _, name := range names {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, badRequest("Missing attachment data for " + name)
+		}
+		if filename := part.FileName(); filename != "" {
+			name = filename
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, badRequest(err.Error())
+		}
+		meta, ok := atts[name].(map[string]interface{})
+		if !ok {
+			return nil, badRequest("Unexpected attachment " + name)
+		}
+		delete(meta, "follows")
+		meta["data"] = base64.StdEncoding.EncodeToString(data)
+	}
+	return doc, nil
+}
+
+func (s *Server) createDoc(w http.ResponseWriter, r *http.Request, name string) error {
+	doc, err := readDoc(r)
+	if err != nil {
+		return err
+	}
+	id, _ := doc["_id"].(string)
+	if id == "" {
+		id = randomHex(16)
+	}
+	db, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	rev, err := db.put(id, doc)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+rev+`"`)
+	return writeJSON(w, writeStatus(r), map[string]interface{}{"ok": true, "id": id, "rev": rev})
+}
+
+// writeStatus returns the status for a successful write, which is 202
+// Accepted for batch mode writes.
+func writeStatus(r *http.Request) int {
+	if r.URL.Query().Get("batch") == "ok" {
+		return http.StatusAccepted
+	}
+	return http.StatusCreated
+}
+
+func (s *Server) serveDoc(w http.ResponseWriter, r *http.Request, name, id string) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		opts, err := parseDocOptions(r)
+		if err != nil {
+			return err
+		}
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		d, ok := db.docs[id]
+		if !ok {
+			return errMissing
+		}
+		rev, err := selectRev(d, r.URL.Query().Get("rev"))
+		if err != nil {
+			return err
+		}
+		etag := `"` + rev.id + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		return writeJSON(w, http.StatusOK, d.render(rev, opts))
+	case http.MethodPut:
+		doc, err := readDoc(r)
+		if err != nil {
+			return err
+		}
+		if _, ok := doc["_rev"]; !ok {
+			if rev := requestRev(r); rev != "" {
+				doc["_rev"] = rev
+			}
+		}
+		newEdits, err := boolParam(r.URL.Query(), "new_edits", true)
+		if err != nil {
+			return err
+		}
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		var rev string
+		if newEdits {
+			rev, err = db.put(id, doc)
+		} else {
+			err = db.replicate(id, doc)
+			rev, _ = doc["_rev"].(string)
+		}
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", `"`+rev+`"`)
+		return writeJSON(w, writeStatus(r), map[string]interface{}{"ok": true, "id": id, "rev": rev})
+	case http.MethodDelete:
+		rev := requestRev(r)
+		if rev == "" {
+			return errConflict
+		}
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		if _, ok := db.docs[id]; !ok {
+			return errMissing
+		}
+		newRev, err := db.put(id, map[string]interface{}{"_rev": rev, "_deleted": true})
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", `"`+newRev+`"`)
+		return writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "id": id, "rev": newRev})
+	case "COPY":
+		return s.copyDoc(w, r, name, id)
+	}
+	return errBadMethod
+}
+
+func (s *Server) copyDoc(w http.ResponseWriter, r *http.Request, name, id string) error {
+	dest := strings.SplitN(r.Header.Get("Destination"), "?", 2)
+	destID := dest[0]
+	if destID == "" {
+		return badRequest("Destination header is mandatory for COPY.")
+	}
+	var destRev string
+	if len(dest) == 2 {
+		query, err := url.ParseQuery(dest[1])
+		if err != nil {
+			return badRequest("Invalid Destination header")
+		}
+		destRev = query.Get("rev")
+	}
+	db, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	d, ok := db.docs[id]
+	if !ok {
+		return errMissing
+	}
+	src, err := selectRev(d, r.URL.Query().Get("rev"))
+	if err != nil {
+		return err
+	}
+	doc := make(map[string]interface{}, len(src.body)+2)
+	for k, v := range src.body {
+		doc[k] = v
+	}
+	atts := make(map[string]interface{}, len(src.atts))
+	for filename, att := range src.atts {
+		atts[filename] = map[string]interface{}{
+			"content_type": att.contentType,
+			"data":         base64.StdEncoding.EncodeToString(att.data),
+		}
+	}
+	doc["_attachments"] = atts
+	if destRev != "" {
+		doc["_rev"] = destRev
+	}
+	newRev, err := db.put(destID, doc)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+newRev+`"`)
+	return writeJSON(w, http.StatusCreated, map[string]interface{}{"ok": true, "id": destID, "rev": newRev})
+}
+
+func (s *Server) serveAttachment(w http.ResponseWriter, r *http.Request, name, id, filename string) error {
+	errNoAttachment := notFound("Document is missing attachment")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		d, ok := db.docs[id]
+		if !ok {
+			return errMissing
+		}
+		rev, err := selectRev(d, r.URL.Query().Get("rev"))
+		if err != nil {
+			return err
+		}
+		att, ok := rev.atts[filename]
+		if !ok {
+			return errNoAttachment
+		}
+		w.Header().Set("ETag", att.etag())
+		if r.Header.Get("If-None-Match") == att.etag() {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		w.Header().Set("Content-Type", att.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(att.data)))
+		w.Header().Set("Accept-Ranges", "none")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(att.data)
+		return nil
+	case http.MethodPut:
+		body, err := requestBody(r)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		rev := requestRev(r)
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		doc := map[string]interface{}{}
+		atts := map[string]interface{}{}
+		if d, ok := db.docs[id]; ok && rev != "" {
+			if parent, ok := d.revs[rev]; ok && parent.leaf {
+				for k, v := range parent.body {
+					doc[k] = v
+				}
+				atts = stubs(parent)
+			}
+		}
+		if rev != "" {
+			doc["_rev"] = rev
+		}
+		atts[filename] = map[string]interface{}{
+			"content_type": contentType,
+			"data":         base64.StdEncoding.EncodeToString(data),
+		}
+		doc["_attachments"] = atts
+		newRev, err := db.put(id, doc)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", `"`+newRev+`"`)
+		return writeJSON(w, http.StatusCreated, map[string]interface{}{"ok": true, "id": id, "rev": newRev})
+	case http.MethodDelete:
+		rev := requestRev(r)
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		d, ok := db.docs[id]
+		if !ok {
+			return errMissing
+		}
+		parent, ok := d.revs[rev]
+		if !ok || !parent.leaf {
+			return errConflict
+		}
+		if _, ok := parent.atts[filename]; !ok {
+			return errNoAttachment
+		}
+		doc := make(map[string]interface{}, len(parent.body)+2)
+		for k, v := range parent.body {
+			doc[k] = v
+		}
+		atts := stubs(parent)
+		delete(atts, filename)
+		doc["_attachments"] = atts
+		doc["_rev"] = rev
+		newRev, err := db.put(id, doc)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", `"`+newRev+`"`)
+		return writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "id": id, "rev": newRev})
+	}
+	return errBadMethod
+}
+
+// localDoc is a local, non-replicating, document. Local documents have no
+// revision history or attachments, and do not appear in the changes feed.
+type localDoc struct {
+	rev  int
+	body map[string]interface{}
+}
+
+func (l *localDoc) revID() string {
+	return "0-" + strconv.Itoa(l.rev)
+}
+
+func (l *localDoc) render(id string) map[string]interface{} {
+	doc := make(map[string]interface{}, len(l.body)+2)
+	for k, v := range l.body {
+		doc[k] = v
+	}
+	doc["_id"] = id
+	doc["_rev"] = l.revID()
+	return doc
+}
+
+// putLocal stores or deletes the local document id.
+func (db *database) putLocal(id string, body map[string]interface{}, rev string, deleted bool) (string, error) {
+	l, ok := db.local[id]
+	if deleted {
+		if !ok {
+			return "", errMissing
+		}
+		if rev != l.revID() {
+			return "", errConflict
+		}
+		delete(db.local, id)
+		return "0-0", nil
+	}
+	if ok && rev != l.revID() || !ok && rev != "" {
+		return "", errConflict
+	}
+	if !ok {
+		l = &localDoc{}
+		db.local[id] = l
+	}
+	l.rev++
+	l.body = body
+	return l.revID(), nil
+}
+
+func (s *Server) serveLocalDoc(w http.ResponseWriter, r *http.Request, name, id string) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		l, ok := db.local[id]
+		if !ok {
+			return errMissing
+		}
+		w.Header().Set("ETag", `"`+l.revID()+`"`)
+		return writeJSON(w, http.StatusOK, l.render(id))
+	case http.MethodPut:
+		doc, err := readDoc(r)
+		if err != nil {
+			return err
+		}
+		if _, ok := doc["_rev"]; !ok {
+			if rev := requestRev(r); rev != "" {
+				doc["_rev"] = rev
+			}
+		}
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		rev, err := db.put(id, doc)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusCreated, map[string]interface{}{"ok": true, "id": id, "rev": rev})
+	case http.MethodDelete:
+		db, err := s.lookup(name)
+		if err != nil {
+			return err
+		}
+		defer s.mu.Unlock()
+		rev, err := db.putLocal(id, nil, requestRev(r), true)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "id": id, "rev": rev})
+	}
+	return errBadMethod
+}
@@ -0,0 +1,437 @@
+// Package couchdbtest provides an in-memory fake CouchDB server, to allow
+// fast, hermetic tests of code which uses the CouchDB driver.
+//
+// Example:
+//
+//     s := couchdbtest.NewServer()
+//     defer s.Close()
+//     client, err := kivik.New("couch", s.URL)
+//     if err != nil {
+//         t.Fatal(err)
+//     }
+//
+// The fake implements the core of the CouchDB 3.x HTTP API: server
+// information, database management, document CRUD with revision trees and
+// conflicts, attachments, _all_docs, _design_docs, _local_docs, _bulk_docs,
+// _bulk_get, _revs_diff, _changes (normal, longpoll and continuous),
+// _db_updates, _security and _session. Responses are streamed in the same
+// format as CouchDB. Views, Mango queries and replication are not supported,
+// and respond with 501 Not Implemented.
+//
+// Document IDs and keys are collated by byte order, rather than with the ICU
+// collation used by CouchDB.
+package couchdbtest
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Version is the CouchDB version reported by the fake server.
+const Version = "3.1.1"
+
+const (
+	typeJSON      = "application/json"
+	typeForm      = "application/x-www-form-urlencoded"
+	typeMPRelated = "multipart/related"
+
+	sessionCookie = "AuthSession"
+)
+
+// Server is an in-memory fake CouchDB server. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	dbs      map[string]*database
+	users    map[string]*user
+	sessions map[string]string
+	updates  *updateLog
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type user struct {
+	password string
+	roles    []string
+}
+
+var _ http.Handler = &Server{}
+
+// NewServer starts and returns a new, empty, fake server. The caller should
+// call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		dbs:      make(map[string]*database),
+		users:    make(map[string]*user),
+		sessions: make(map[string]string),
+		updates:  newUpdateLog(),
+		done:     make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Close terminates any open continuous or longpoll feeds, then shuts down the
+// server.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.Server.Close()
+}
+
+// AddUser adds a user, who may authenticate with basic auth or with a cookie
+// session. While the server has no users, it is in "admin party" mode, and all
+// requests are permitted. Once a user has been added, all requests other than
+// to /, /_up, /_uuids and /_session must be authenticated.
+func (s *Server) AddUser(name, password string, roles ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[name] = &user{password: password, roles: append([]string{}, roles...)}
+}
+
+// couchError is an error response, in the format returned by CouchDB.
+type couchError struct {
+	status int
+	err    string
+	reason string
+}
+
+func (e *couchError) Error() string {
+	return e.err + ": " + e.reason
+}
+
+func notFound(reason string) error {
+	return &couchError{status: http.StatusNotFound, err: "not_found", reason: reason}
+}
+
+func badRequest(reason string) error {
+	return &couchError{status: http.StatusBadRequest, err: "bad_request", reason: reason}
+}
+
+var (
+	errConflict       = &couchError{status: http.StatusConflict, err: "conflict", reason: "Document update conflict."}
+	errDBExists       = &couchError{status: http.StatusPreconditionFailed, err: "file_exists", reason: "The database could not be created, the file already exists."}
+	errNoDB           = notFound("Database does not exist.")
+	errMissing        = notFound("missing")
+	errBadMethod      = &couchError{status: http.StatusMethodNotAllowed, err: "method_not_allowed", reason: "Method not allowed."}
+	errBadCredentials = &couchError{status: http.StatusUnauthorized, err: "unauthorized", reason: "Name or password is incorrect."}
+	errUnauthorized   = &couchError{status: http.StatusUnauthorized, err: "unauthorized", reason: "You are not authorized to access this db."}
+	errBadJSON        = badRequest("invalid UTF-8 JSON")
+)
+
+func notImplemented(feature string) error {
+	return &couchError{status: http.StatusNotImplemented, err: "not_implemented", reason: feature + " is not supported by couchdbtest"}
+}
+
+// userCtx identifies the user making a request.
+type userCtx struct {
+	name    string
+	roles   []string
+	session string
+	method  string
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", "CouchDB/"+Version+" (Erlang OTP/20)")
+	w.Header().Set("X-Couch-Request-ID", randomHex(5))
+	w.Header().Set("Cache-Control", "must-revalidate")
+	if err := s.serve(w, r); err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) error {
+	path := strings.Trim(r.URL.EscapedPath(), "/")
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, "/")
+	}
+	ctx, err := s.authenticate(r)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return serveWelcome(w, r)
+	}
+	first, err := url.PathUnescape(segments[0])
+	if err != nil {
+		return badRequest("invalid path")
+	}
+	switch first {
+	case "_up":
+		return writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case "_uuids":
+		return serveUUIDs(w, r)
+	case "_session":
+		return s.serveSession(w, r, ctx)
+	}
+	if ctx.name == "" && !s.adminParty() {
+		return errUnauthorized
+	}
+	switch first {
+	case "_all_dbs":
+		return s.serveAllDBs(w, r)
+	case "_db_updates":
+		return s.serveDBUpdates(w, r)
+	}
+	if !validDBName(first) {
+		return &couchError{
+			status: http.StatusBadRequest,
+			err:    "illegal_database_name",
+			reason: fmt.Sprintf("Name: '%s'. Only lowercase characters (a-z), digits (0-9), and any of the characters _, $, (, ), +, -, and / are allowed. Must begin with a letter.", first),
+		}
+	}
+	if len(segments) == 1 {
+		return s.serveDB(w, r, first)
+	}
+	return s.serveDBPath(w, r, first, segments[1:])
+}
+
+var dbNameRE = regexp.MustCompile(`^[a-z][a-z0-9_$()+/-]*$`)
+
+func validDBName(name string) bool {
+	switch name {
+	case "_users", "_replicator", "_global_changes":
+		return true
+	}
+	return dbNameRE.MatchString(name)
+}
+
+func (s *Server) adminParty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.users) == 0
+}
+
+// authenticate identifies the user from a session cookie or basic auth
+// credentials. Anonymous requests return an empty userCtx.
+func (s *Server) authenticate(r *http.Request) (*userCtx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name, password, ok := r.BasicAuth(); ok {
+		u, ok := s.users[name]
+		if !ok || u.password != password {
+			return nil, errBadCredentials
+		}
+		return &userCtx{name: name, roles: u.roles, method: "default"}, nil
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if name, ok := s.sessions[c.Value]; ok {
+			if u, ok := s.users[name]; ok {
+				return &userCtx{name: name, roles: u.roles, session: c.Value, method: "cookie"}, nil
+			}
+		}
+	}
+	if len(s.users) == 0 {
+		return &userCtx{roles: []string{"_admin"}}, nil
+	}
+	return &userCtx{}, nil
+}
+
+func serveWelcome(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return errBadMethod
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"couchdb":  "Welcome",
+		"version":  Version,
+		"git_sha":  "couchdbtest",
+		"uuid":     "85fb71bf700c17267fef77535820e371",
+		"features": []string{"access-ready", "partitioned", "pluggable-storage-engines", "reshard", "scheduler"},
+		"vendor":   map[string]string{"name": "The Apache Software Foundation"},
+	})
+}
+
+func serveUUIDs(w http.ResponseWriter, r *http.Request) error {
+	count := 1
+	if c := r.URL.Query().Get("count"); c != "" {
+		var err error
+		if count, err = strconv.Atoi(c); err != nil || count < 1 {
+			return badRequest("count must be a positive integer")
+		}
+	}
+	uuids := make([]string, count)
+	for i := range uuids {
+		uuids[i] = randomHex(16)
+	}
+	return writeJSON(w, http.StatusOK, map[string][]string{"uuids": uuids})
+}
+
+func (s *Server) serveAllDBs(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return errBadMethod
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	names := make([]string, 0, len(s.dbs))
+	for name := range s.dbs {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+	if q.descending {
+		reverse(names)
+	}
+	names = page(names, q.skip, q.limit)
+	return writeJSON(w, http.StatusOK, names)
+}
+
+func (s *Server) serveSession(w http.ResponseWriter, r *http.Request, ctx *userCtx) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		var name interface{}
+		if ctx.name != "" {
+			name = ctx.name
+		}
+		roles := ctx.roles
+		if roles == nil {
+			roles = []string{}
+		}
+		info := map[string]interface{}{
+			"authentication_handlers": []string{"cookie", "default"},
+		}
+		if ctx.method != "" {
+			info["authenticated"] = ctx.method
+		}
+		return writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":      true,
+			"userCtx": map[string]interface{}{"name": name, "roles": roles},
+			"info":    info,
+		})
+	case http.MethodPost:
+		var creds struct {
+			Name     string `json:"name"`
+			Password string `json:"password"`
+		}
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch ct {
+		case typeJSON:
+			if err := readJSON(r, &creds); err != nil {
+				return err
+			}
+		case typeForm:
+			if err := r.ParseForm(); err != nil {
+				return badRequest(err.Error())
+			}
+			creds.Name, creds.Password = r.PostForm.Get("name"), r.PostForm.Get("password")
+		default:
+			return &couchError{status: http.StatusUnsupportedMediaType, err: "bad_content_type", reason: "Content-Type must be application/x-www-form-urlencoded or application/json"}
+		}
+		s.mu.Lock()
+		u, ok := s.users[creds.Name]
+		if !ok || u.password != creds.Password {
+			s.mu.Unlock()
+			return errBadCredentials
+		}
+		token := randomHex(16)
+		s.sessions[token] = creds.Name
+		s.mu.Unlock()
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: token, Path: "/", HttpOnly: true})
+		return writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":    true,
+			"name":  creds.Name,
+			"roles": u.roles,
+		})
+	case http.MethodDelete:
+		if ctx.session != "" {
+			s.mu.Lock()
+			delete(s.sessions, ctx.session)
+			s.mu.Unlock()
+		}
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", HttpOnly: true, MaxAge: -1})
+		return writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	}
+	return errBadMethod
+}
+
+// writeJSON writes v as the JSON response body, followed by a newline, as
+// CouchDB does.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Type", typeJSON)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+	return nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	ce, ok := err.(*couchError)
+	if !ok {
+		ce = &couchError{status: http.StatusInternalServerError, err: "unknown_error", reason: err.Error()}
+	}
+	_ = writeJSON(w, ce.status, map[string]string{"error": ce.err, "reason": ce.reason})
+}
+
+// requestBody returns the body of r, decompressing it if necessary.
+func requestBody(r *http.Request) (io.Reader, error) {
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return r.Body, nil
+	}
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, badRequest("invalid gzip body")
+	}
+	return zr, nil
+}
+
+// readJSON decodes the JSON request body into v.
+func readJSON(r *http.Request, v interface{}) error {
+	body, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return errBadJSON
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func reverse(s []string) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// page applies skip and limit to s. A negative limit means no limit.
+func page(s []string, skip, limit int) []string {
+	if skip >= len(s) {
+		return s[:0]
+	}
+	s = s[skip:]
+	if limit >= 0 && limit < len(s) {
+		s = s[:limit]
+	}
+	return s
+}
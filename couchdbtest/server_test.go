@@ -0,0 +1,411 @@
+package couchdbtest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/couchdb/v4"
+	"github.com/go-kivik/couchdb/v4/couchdbtest"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+func newClient(t *testing.T, s *couchdbtest.Server) *kivik.Client {
+	t.Helper()
+	client, err := kivik.New("couch", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestServerDatabases(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	client := newClient(t, s)
+	ctx := context.Background()
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != couchdbtest.Version {
+		t.Errorf("Unexpected version: %s", version.Version)
+	}
+	for _, name := range []string{"foo", "bar"} {
+		if err := client.CreateDB(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = client.CreateDB(ctx, "foo")
+	testy.StatusError(t, "Precondition Failed: The database could not be created, the file already exists.", http.StatusPreconditionFailed, err)
+	err = client.CreateDB(ctx, "Foo")
+	testy.StatusErrorRE(t, "^Bad Request: Name: 'Foo'", http.StatusBadRequest, err)
+
+	dbs, err := client.AllDBs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"bar", "foo"}, dbs); d != nil {
+		t.Error(d)
+	}
+	if err := client.DestroyDB(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	exists, err := client.DBExists(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("foo should not exist")
+	}
+	err = client.DestroyDB(ctx, "foo")
+	testy.StatusError(t, "Not Found: Database does not exist.", http.StatusNotFound, err)
+}
+
+func TestServerAuthentication(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	s.AddUser("bob", "abc123", "users")
+	ctx := context.Background()
+
+	client := newClient(t, s)
+	err := client.CreateDB(ctx, "foo")
+	testy.StatusError(t, "Unauthorized: You are not authorized to access this db.", http.StatusUnauthorized, err)
+
+	err = client.Authenticate(ctx, couchdb.CookieAuth("bob", "wrong"))
+	testy.StatusError(t, "Unauthorized: Name or password is incorrect.", http.StatusUnauthorized, err)
+
+	client = newClient(t, s)
+	if err := client.Authenticate(ctx, couchdb.CookieAuth("bob", "abc123")); err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.Session(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Name != "bob" || session.AuthenticationMethod != "cookie" {
+		t.Errorf("Unexpected session: %+v", session)
+	}
+	if err := client.CreateDB(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	client = newClient(t, s)
+	if err := client.Authenticate(ctx, couchdb.BasicAuth("bob", "abc123")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AllDBs(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerDocuments(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	ctx := context.Background()
+
+	rev1, err := db.Put(ctx, "foo", map[string]string{"name": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rev1, "1-") {
+		t.Errorf("Unexpected rev: %s", rev1)
+	}
+	_, err = db.Put(ctx, "foo", map[string]string{"name": "Bob"})
+	testy.StatusError(t, "Conflict: Document update conflict.", http.StatusConflict, err)
+
+	rev2, err := db.Put(ctx, "foo", map[string]string{"_rev": rev1, "name": "Bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := db.Get(ctx, "foo").ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"_id": "foo", "_rev": rev2, "name": "Bob"}
+	if d := testy.DiffInterface(expected, doc); d != nil {
+		t.Error(d)
+	}
+	if err := db.Get(ctx, "foo", kivik.Options{"rev": rev1}).ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["name"] != "Alice" {
+		t.Errorf("Unexpected old revision: %v", doc)
+	}
+	_, rev, err := db.GetMeta(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != rev2 {
+		t.Errorf("Unexpected rev: %s", rev)
+	}
+
+	id, _, err := db.CreateDoc(ctx, map[string]string{"name": "Carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) != 32 {
+		t.Errorf("Unexpected generated ID: %s", id)
+	}
+	copyRev, err := db.Copy(ctx, "bar", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(copyRev, "1-") {
+		t.Errorf("Unexpected copy rev: %s", copyRev)
+	}
+
+	if _, err := db.Delete(ctx, "foo", rev1); err == nil {
+		t.Error("Expected conflict deleting an old revision")
+	}
+	if _, err := db.Delete(ctx, "foo", rev2); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Get(ctx, "foo").ScanDoc(&doc)
+	testy.StatusError(t, "Not Found: deleted", http.StatusNotFound, err)
+	if _, err := db.Put(ctx, "foo", map[string]string{"name": "Dave"}); err != nil {
+		t.Errorf("Failed to recreate deleted doc: %s", err)
+	}
+}
+
+func TestServerConflicts(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	ctx := context.Background()
+
+	results, err := db.BulkDocs(ctx, []interface{}{
+		map[string]interface{}{"_id": "foo", "_rev": "1-a", "value": 1},
+		map[string]interface{}{"_id": "foo", "_rev": "2-b", "_revisions": map[string]interface{}{"start": 2, "ids": []string{"b", "a"}}, "value": 2},
+		map[string]interface{}{"_id": "foo", "_rev": "2-c", "_revisions": map[string]interface{}{"start": 2, "ids": []string{"c", "a"}}, "value": 3},
+	}, kivik.Options{"new_edits": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = results.Close()
+
+	var doc map[string]interface{}
+	if err := db.Get(ctx, "foo", kivik.Options{"conflicts": true, "revs": true}).ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"_id":        "foo",
+		"_rev":       "2-c",
+		"value":      float64(3),
+		"_conflicts": []interface{}{"2-b"},
+		"_revisions": map[string]interface{}{"start": float64(2), "ids": []interface{}{"c", "a"}},
+	}
+	if d := testy.DiffInterface(expected, doc); d != nil {
+		t.Error(d)
+	}
+
+	rows, err := db.RevsDiff(ctx, map[string][]string{
+		"foo": {"2-b", "3-d"},
+		"bar": {"1-x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs := map[string]interface{}{}
+	for rows.Next() {
+		var value interface{}
+		if err := rows.ScanValue(&value); err != nil {
+			t.Fatal(err)
+		}
+		diffs[rows.ID()] = value
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expectedDiffs := map[string]interface{}{
+		"foo": map[string]interface{}{"missing": []interface{}{"3-d"}, "possible_ancestors": []interface{}{"2-c", "2-b"}},
+		"bar": map[string]interface{}{"missing": []interface{}{"1-x"}},
+	}
+	if d := testy.DiffInterface(expectedDiffs, diffs); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestServerBulk(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	ctx := context.Background()
+
+	if _, err := db.Put(ctx, "b", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := db.BulkDocs(ctx, []interface{}{
+		map[string]string{"_id": "a"},
+		map[string]string{"_id": "b"},
+		map[string]string{"_id": "_design/c"},
+		map[string]string{"_id": "_local/d"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []string
+	for results.Next() {
+		if err := results.UpdateErr(); err != nil {
+			errs = append(errs, results.ID()+": "+err.Error())
+		}
+	}
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"b: Document update conflict. (conflict)"}, errs); d != nil {
+		t.Error(d)
+	}
+
+	rows, err := db.AllDocs(ctx, kivik.Options{"include_docs": true, "skip": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for rows.Next() {
+		var doc map[string]interface{}
+		if err := rows.ScanDoc(&doc); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc["_id"].(string))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b"}, ids); d != nil {
+		t.Error(d)
+	}
+	if rows.TotalRows() != 3 || rows.Offset() != 1 {
+		t.Errorf("Unexpected total_rows %d, offset %d", rows.TotalRows(), rows.Offset())
+	}
+
+	rows, err = db.AllDocs(ctx, kivik.Options{"keys": []string{"b", "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for rows.Next() {
+		keys = append(keys, rows.Key())
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{`"b"`, `"missing"`}, keys); d != nil {
+		t.Error(d)
+	}
+
+	rows, err = db.BulkGet(ctx, []kivik.BulkGetReference{{ID: "a"}, {ID: "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for rows.Next() {
+		var doc map[string]interface{}
+		if err := rows.ScanDoc(&doc); err != nil {
+			got = append(got, err.Error())
+			continue
+		}
+		got = append(got, doc["_id"].(string))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if d := testy.DiffInterface([]string{"a", "not_found: missing"}, got); d != nil {
+		t.Error(d)
+	}
+
+	var local map[string]interface{}
+	if err := db.Get(ctx, "_local/d").ScanDoc(&local); err != nil {
+		t.Fatal(err)
+	}
+	if local["_rev"] != "0-1" {
+		t.Errorf("Unexpected local doc: %v", local)
+	}
+}
+
+func TestServerSecurity(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	ctx := context.Background()
+
+	sec := &kivik.Security{
+		Admins:  kivik.Members{Names: []string{"bob"}},
+		Members: kivik.Members{Roles: []string{"users"}},
+	}
+	if err := db.SetSecurity(ctx, sec); err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.Security(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface(sec, result); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestServerAttachments(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := s.NewDB(t, "testdb", nil)
+	ctx := context.Background()
+
+	rev, err := db.Put(ctx, "foo", map[string]interface{}{
+		"name": "Alice",
+		"_attachments": kivik.Attachments{
+			"a.txt": &kivik.Attachment{ContentType: "text/plain", Content: ioutil.NopCloser(strings.NewReader("hello"))},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err = db.PutAttachment(ctx, "foo", rev, &kivik.Attachment{
+		Filename:    "b/c.json",
+		ContentType: "application/json",
+		Content:     ioutil.NopCloser(strings.NewReader(`{"b":"c"}`)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	att, err := db.GetAttachment(ctx, "foo", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(att.Content)
+	_ = att.Content.Close()
+	if string(content) != "hello" || att.ContentType != "text/plain" || att.Digest != "XUFAKrxLKna5cZ2REBfFkg==" {
+		t.Errorf("Unexpected attachment: %s %+v", content, att)
+	}
+	var doc struct {
+		Attachments map[string]struct {
+			Stub   bool  `json:"stub"`
+			Length int64 `json:"length"`
+			RevPos int   `json:"revpos"`
+		} `json:"_attachments"`
+	}
+	if err := db.Get(ctx, "foo").ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if a := doc.Attachments["a.txt"]; !a.Stub || a.Length != 5 || a.RevPos != 1 {
+		t.Errorf("Unexpected stub: %+v", a)
+	}
+	if a := doc.Attachments["b/c.json"]; a.RevPos != 2 {
+		t.Errorf("Unexpected stub: %+v", a)
+	}
+
+	if _, err := db.DeleteAttachment(ctx, "foo", rev, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetAttachment(ctx, "foo", "a.txt")
+	testy.StatusError(t, "Not Found: Document is missing attachment", http.StatusNotFound, err)
+}
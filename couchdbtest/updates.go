@@ -0,0 +1,188 @@
+package couchdbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// updateLog records database events, for the _db_updates feed. It is
+// protected by the server lock.
+type updateLog struct {
+	events []dbUpdate
+
+	// updated is closed, and replaced, whenever an event is added.
+	updated chan struct{}
+}
+
+type dbUpdate struct {
+	seq  int64
+	name string
+	typ  string
+}
+
+func newUpdateLog() *updateLog {
+	return &updateLog{updated: make(chan struct{})}
+}
+
+func (l *updateLog) add(name, typ string) {
+	l.events = append(l.events, dbUpdate{seq: int64(len(l.events) + 1), name: name, typ: typ})
+	close(l.updated)
+	l.updated = make(chan struct{})
+}
+
+func (l *updateLog) seq() int64 {
+	return int64(len(l.events))
+}
+
+// since returns the events after since. As in CouchDB, only the latest event
+// for each database is included.
+func (l *updateLog) since(since int64) []dbUpdate {
+	if since > l.seq() {
+		since = l.seq()
+	}
+	latest := make(map[string]int64)
+	for _, e := range l.events[since:] {
+		latest[e.name] = e.seq
+	}
+	var events []dbUpdate
+	for _, e := range l.events[since:] {
+		if latest[e.name] == e.seq {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (e dbUpdate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"db_name": e.name,
+		"type":    e.typ,
+		"seq":     formatSeq(e.seq),
+	})
+}
+
+// updatesState is a snapshot of the update log, taken to serve a feed.
+type updatesState struct {
+	events  []dbUpdate
+	seq     int64
+	updated <-chan struct{}
+}
+
+func (s *Server) updatesState(since int64) *updatesState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &updatesState{
+		events:  s.updates.since(since),
+		seq:     s.updates.seq(),
+		updated: s.updates.updated,
+	}
+}
+
+func (s *Server) serveDBUpdates(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return errBadMethod
+	}
+	o, err := parseChangesOptions(r)
+	if err != nil {
+		return err
+	}
+	if o.sinceNow {
+		s.mu.Lock()
+		o.since = s.updates.seq()
+		s.mu.Unlock()
+	}
+	switch o.feed {
+	case "", "normal":
+		return s.pollUpdates(w, r, o, false)
+	case "longpoll":
+		return s.pollUpdates(w, r, o, true)
+	case "continuous":
+		return s.continuousUpdates(w, r, o)
+	}
+	return badRequest("Supported `feed` types: normal, longpoll, continuous")
+}
+
+// pollUpdates serves a normal or longpoll _db_updates feed.
+func (s *Server) pollUpdates(w http.ResponseWriter, r *http.Request, o *changesOptions, longpoll bool) error {
+	timers := newFeedTimers(o)
+	defer timers.stop()
+	state := s.updatesState(o.since)
+wait:
+	for longpoll && len(state.events) == 0 {
+		select {
+		case <-state.updated:
+			state = s.updatesState(o.since)
+		case <-timers.expired():
+			break wait
+		case <-r.Context().Done():
+			return nil
+		case <-s.done:
+			return nil
+		}
+	}
+	events, lastSeq := state.events, state.seq
+	if o.limit >= 0 && len(events) > o.limit {
+		events = events[:o.limit]
+		lastSeq = events[len(events)-1].seq
+	}
+	if events == nil {
+		events = []dbUpdate{}
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results":  events,
+		"last_seq": formatSeq(lastSeq),
+	})
+}
+
+// continuousUpdates serves a continuous _db_updates feed, until the limit is
+// reached, the timeout expires, or the client disconnects.
+func (s *Server) continuousUpdates(w http.ResponseWriter, r *http.Request, o *changesOptions) error {
+	timers := newFeedTimers(o)
+	defer timers.stop()
+	w.Header().Set("Content-Type", typeJSON)
+	w.WriteHeader(http.StatusOK)
+	flush(w)
+
+	since, sent := o.since, 0
+	end := func() {
+		fmt.Fprintf(w, "{\"last_seq\":%q}\n", formatSeq(since))
+	}
+	for {
+		state := s.updatesState(since)
+		for _, e := range state.events {
+			line, err := json.Marshal(e)
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(w, "%s\n", line)
+			since = e.seq
+			if sent++; o.limit >= 0 && sent >= o.limit {
+				end()
+				return nil
+			}
+		}
+		if len(state.events) > 0 {
+			flush(w)
+			timers.reset()
+		}
+		since = state.seq
+	wait:
+		for {
+			select {
+			case <-state.updated:
+				break wait
+			case <-timers.beats():
+				_, _ = w.Write([]byte("\n"))
+				flush(w)
+			case <-timers.expired():
+				end()
+				return nil
+			case <-r.Context().Done():
+				return nil
+			case <-s.done:
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package couchdbtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/couchdb/v4"
+	"github.com/go-kivik/couchdb/v4/couchdbtest"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+type dbUpdate struct {
+	DBName string
+	Type   string
+}
+
+func readUpdates(t *testing.T, updates *kivik.DBUpdates, n int) []dbUpdate {
+	t.Helper()
+	var results []dbUpdate
+	for (n < 0 || len(results) < n) && updates.Next() {
+		results = append(results, dbUpdate{DBName: updates.DBName(), Type: updates.Type()})
+	}
+	if err := updates.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestDBUpdatesNormal(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	client := newClient(t, s)
+	ctx := context.Background()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a")
+	for _, name := range []string{"foo", "bar"} {
+		if err := client.CreateDB(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.DestroyDB(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	updates, err := client.DBUpdates(couchdb.WithDBUpdatesOptions(ctx, map[string]interface{}{"since": "1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []dbUpdate{
+		{DBName: "testdb", Type: "updated"},
+		{DBName: "bar", Type: "created"},
+		{DBName: "foo", Type: "deleted"},
+	}
+	if d := testy.DiffInterface(expected, readUpdates(t, updates, -1)); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestDBUpdatesContinuous(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	client := newClient(t, s)
+	db := s.NewDB(t, "testdb", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := client.DBUpdates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updates.Close() // nolint: errcheck
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		couchdbtest.PutDocs(t, db, "a")
+		if err := client.CreateDB(context.Background(), "foo"); err != nil {
+			t.Error(err)
+		}
+	}()
+	expected := []dbUpdate{
+		{DBName: "testdb", Type: "updated"},
+		{DBName: "foo", Type: "created"},
+	}
+	if d := testy.DiffInterface(expected, readUpdates(t, updates, 2)); d != nil {
+		t.Error(d)
+	}
+}
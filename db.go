@@ -70,8 +70,9 @@ func optionsToParams(opts ...map[string]interface{}) (url.Values, error) {
 	return params, nil
 }
 
-// rowsQuery performs a query that returns a rows iterator.
-func (d *db) rowsQuery(ctx context.Context, path string, opts map[string]interface{}) (driver.Rows, error) {
+// rowsQuery performs a query that returns a rows iterator. span, if not nil,
+// is ended when the iterator is closed.
+func (d *db) rowsQuery(ctx context.Context, span chttp.Span, path string, opts map[string]interface{}) (driver.Rows, error) {
 	keys := opts["keys"]
 	delete(opts, "keys")
 	query, err := optionsToParams(opts)
@@ -96,35 +97,46 @@ func (d *db) rowsQuery(ctx context.Context, path string, opts map[string]interfa
 	if err = chttp.ResponseError(resp); err != nil {
 		return nil, err
 	}
-	return newRows(ctx, resp.Body), nil
+	return newRows(ctx, endSpanOnClose(span, resp.Body)), nil
 }
 
 // AllDocs returns all of the documents in the database.
-func (d *db) AllDocs(ctx context.Context, opts map[string]interface{}) (driver.Rows, error) {
-	return d.rowsQuery(ctx, "_all_docs", opts)
+func (d *db) AllDocs(ctx context.Context, opts map[string]interface{}) (_ driver.Rows, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.AllDocs", "")
+	defer endSpanOnError(span, &err)
+	return d.rowsQuery(ctx, span, "_all_docs", opts)
 }
 
 // DesignDocs returns all of the documents in the database.
-func (d *db) DesignDocs(ctx context.Context, opts map[string]interface{}) (driver.Rows, error) {
-	return d.rowsQuery(ctx, "_design_docs", opts)
+func (d *db) DesignDocs(ctx context.Context, opts map[string]interface{}) (_ driver.Rows, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.DesignDocs", "")
+	defer endSpanOnError(span, &err)
+	return d.rowsQuery(ctx, span, "_design_docs", opts)
 }
 
 // LocalDocs returns all of the documents in the database.
-func (d *db) LocalDocs(ctx context.Context, opts map[string]interface{}) (driver.Rows, error) {
-	return d.rowsQuery(ctx, "_local_docs", opts)
+func (d *db) LocalDocs(ctx context.Context, opts map[string]interface{}) (_ driver.Rows, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.LocalDocs", "")
+	defer endSpanOnError(span, &err)
+	return d.rowsQuery(ctx, span, "_local_docs", opts)
 }
 
 // Query queries a view.
-func (d *db) Query(ctx context.Context, ddoc, view string, opts map[string]interface{}) (driver.Rows, error) {
-	return d.rowsQuery(ctx, fmt.Sprintf("_design/%s/_view/%s", chttp.EncodeDocID(ddoc), chttp.EncodeDocID(view)), opts)
+func (d *db) Query(ctx context.Context, ddoc, view string, opts map[string]interface{}) (_ driver.Rows, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Query", "")
+	defer endSpanOnError(span, &err)
+	return d.rowsQuery(ctx, span, fmt.Sprintf("_design/%s/_view/%s", chttp.EncodeDocID(ddoc), chttp.EncodeDocID(view)), opts)
 }
 
 // Get fetches the requested document.
-func (d *db) Get(ctx context.Context, docID string, options map[string]interface{}) (*driver.Document, error) {
+func (d *db) Get(ctx context.Context, docID string, options map[string]interface{}) (_ *driver.Document, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Get", docID)
+	defer endSpanOnError(span, &err)
 	resp, rev, err := d.get(ctx, http.MethodGet, docID, options)
 	if err != nil {
 		return nil, err
 	}
+	resp.Body = endSpanOnClose(span, resp.Body)
 	ct, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 	if err != nil {
 		return nil, &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
@@ -250,6 +262,8 @@ func (a *multipartAttachments) Close() error {
 
 // Rev returns the most current rev of the requested document.
 func (d *db) GetMeta(ctx context.Context, docID string, options map[string]interface{}) (size int64, rev string, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.GetMeta", docID)
+	defer endSpan(span, &err)
 	resp, rev, err := d.get(ctx, http.MethodHead, docID, options)
 	if err != nil {
 		return 0, "", err
@@ -291,6 +305,8 @@ func (d *db) get(ctx context.Context, method string, docID string, options map[s
 }
 
 func (d *db) CreateDoc(ctx context.Context, doc interface{}, options map[string]interface{}) (docID, rev string, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.CreateDoc", "")
+	defer endSpan(span, &err)
 	result := struct {
 		ID  string `json:"id"`
 		Rev string `json:"rev"`
@@ -300,6 +316,10 @@ func (d *db) CreateDoc(ctx context.Context, doc interface{}, options map[string]
 	if err != nil {
 		return "", "", err
 	}
+	gzip, gzipThreshold, err := gzipRequest(options)
+	if err != nil {
+		return "", "", err
+	}
 
 	path := d.dbName
 	if len(options) > 0 {
@@ -311,8 +331,10 @@ func (d *db) CreateDoc(ctx context.Context, doc interface{}, options map[string]
 	}
 
 	opts := &chttp.Options{
-		Body:       chttp.EncodeBody(doc),
-		FullCommit: fullCommit,
+		Body:          chttp.EncodeBody(doc),
+		FullCommit:    fullCommit,
+		Gzip:          gzip,
+		GzipThreshold: gzipThreshold,
 	}
 	_, err = d.Client.DoJSON(ctx, http.MethodPost, path, opts, &result)
 	return result.ID, result.Rev, err
@@ -323,6 +345,10 @@ func putOpts(doc interface{}, options map[string]interface{}) (*chttp.Options, e
 	if err != nil {
 		return nil, err
 	}
+	gzip, gzipThreshold, err := gzipRequest(options)
+	if err != nil {
+		return nil, err
+	}
 	params, err := optionsToParams(options)
 	if err != nil {
 		return nil, err
@@ -343,13 +369,17 @@ func putOpts(doc interface{}, options map[string]interface{}) (*chttp.Options, e
 		}
 	}
 	return &chttp.Options{
-		Body:       chttp.EncodeBody(doc),
-		FullCommit: fullCommit,
-		Query:      params,
+		Body:          chttp.EncodeBody(doc),
+		FullCommit:    fullCommit,
+		Query:         params,
+		Gzip:          gzip,
+		GzipThreshold: gzipThreshold,
 	}, nil
 }
 
 func (d *db) Put(ctx context.Context, docID string, doc interface{}, options map[string]interface{}) (rev string, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Put", docID)
+	defer endSpan(span, &err)
 	if docID == "" {
 		return "", missingArg("docID")
 	}
@@ -700,7 +730,9 @@ func copyWithAttachmentStubs(w io.Writer, r io.Reader, atts map[string]*stub) er
 	return nil
 }
 
-func (d *db) Delete(ctx context.Context, docID, rev string, options map[string]interface{}) (string, error) {
+func (d *db) Delete(ctx context.Context, docID, rev string, options map[string]interface{}) (_ string, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Delete", docID)
+	defer endSpan(span, &err)
 	if docID == "" {
 		return "", missingArg("docID")
 	}
@@ -730,17 +762,21 @@ func (d *db) Delete(ctx context.Context, docID, rev string, options map[string]i
 	return chttp.GetRev(resp)
 }
 
-func (d *db) Flush(ctx context.Context) error {
+func (d *db) Flush(ctx context.Context) (err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Flush", "")
+	defer endSpan(span, &err)
 	opts := &chttp.Options{
 		Header: http.Header{
 			chttp.HeaderIdempotencyKey: []string{},
 		},
 	}
-	_, err := d.Client.DoError(ctx, http.MethodPost, d.path("/_ensure_full_commit"), opts)
+	_, err = d.Client.DoError(ctx, http.MethodPost, d.path("/_ensure_full_commit"), opts)
 	return err
 }
 
-func (d *db) Compact(ctx context.Context) error {
+func (d *db) Compact(ctx context.Context) (err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Compact", "")
+	defer endSpan(span, &err)
 	opts := &chttp.Options{
 		Header: http.Header{
 			chttp.HeaderIdempotencyKey: []string{},
@@ -753,7 +789,9 @@ func (d *db) Compact(ctx context.Context) error {
 	return chttp.ResponseError(res)
 }
 
-func (d *db) CompactView(ctx context.Context, ddocID string) error {
+func (d *db) CompactView(ctx context.Context, ddocID string) (err error) {
+	ctx, span := d.startDBSpan(ctx, "db.CompactView", "")
+	defer endSpan(span, &err)
 	if ddocID == "" {
 		return missingArg("ddocID")
 	}
@@ -769,7 +807,9 @@ func (d *db) CompactView(ctx context.Context, ddocID string) error {
 	return chttp.ResponseError(res)
 }
 
-func (d *db) ViewCleanup(ctx context.Context) error {
+func (d *db) ViewCleanup(ctx context.Context) (err error) {
+	ctx, span := d.startDBSpan(ctx, "db.ViewCleanup", "")
+	defer endSpan(span, &err)
 	opts := &chttp.Options{
 		Header: http.Header{
 			chttp.HeaderIdempotencyKey: []string{},
@@ -782,13 +822,17 @@ func (d *db) ViewCleanup(ctx context.Context) error {
 	return chttp.ResponseError(res)
 }
 
-func (d *db) Security(ctx context.Context) (*driver.Security, error) {
+func (d *db) Security(ctx context.Context) (_ *driver.Security, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Security", "")
+	defer endSpan(span, &err)
 	var sec *driver.Security
-	_, err := d.Client.DoJSON(ctx, http.MethodGet, d.path("/_security"), nil, &sec)
+	_, err = d.Client.DoJSON(ctx, http.MethodGet, d.path("/_security"), nil, &sec)
 	return sec, err
 }
 
-func (d *db) SetSecurity(ctx context.Context, security *driver.Security) error {
+func (d *db) SetSecurity(ctx context.Context, security *driver.Security) (err error) {
+	ctx, span := d.startDBSpan(ctx, "db.SetSecurity", "")
+	defer endSpan(span, &err)
 	opts := &chttp.Options{
 		GetBody: chttp.BodyEncoder(security),
 		Header: http.Header{
@@ -804,6 +848,8 @@ func (d *db) SetSecurity(ctx context.Context, security *driver.Security) error {
 }
 
 func (d *db) Copy(ctx context.Context, targetID, sourceID string, options map[string]interface{}) (targetRev string, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Copy", targetID)
+	defer endSpan(span, &err)
 	if sourceID == "" {
 		return "", missingArg("sourceID")
 	}
@@ -833,7 +879,9 @@ func (d *db) Copy(ctx context.Context, targetID, sourceID string, options map[st
 	return chttp.GetRev(resp)
 }
 
-func (d *db) Purge(ctx context.Context, docMap map[string][]string) (*driver.PurgeResult, error) {
+func (d *db) Purge(ctx context.Context, docMap map[string][]string) (_ *driver.PurgeResult, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Purge", "")
+	defer endSpan(span, &err)
 	result := &driver.PurgeResult{}
 	options := &chttp.Options{
 		GetBody: chttp.BodyEncoder(docMap),
@@ -841,13 +889,15 @@ func (d *db) Purge(ctx context.Context, docMap map[string][]string) (*driver.Pur
 			chttp.HeaderIdempotencyKey: []string{},
 		},
 	}
-	_, err := d.Client.DoJSON(ctx, http.MethodPost, d.path("_purge"), options, &result)
+	_, err = d.Client.DoJSON(ctx, http.MethodPost, d.path("_purge"), options, &result)
 	return result, err
 }
 
 var _ driver.RevsDiffer = &db{}
 
-func (d *db) RevsDiff(ctx context.Context, revMap interface{}) (driver.Rows, error) {
+func (d *db) RevsDiff(ctx context.Context, revMap interface{}) (_ driver.Rows, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.RevsDiff", "")
+	defer endSpanOnError(span, &err)
 	options := &chttp.Options{
 		GetBody: chttp.BodyEncoder(revMap),
 		Header: http.Header{
@@ -861,7 +911,7 @@ func (d *db) RevsDiff(ctx context.Context, revMap interface{}) (driver.Rows, err
 	if err = chttp.ResponseError(resp); err != nil {
 		return nil, err
 	}
-	return newRevsDiffRows(ctx, resp.Body), nil
+	return newRevsDiffRows(ctx, endSpanOnClose(span, resp.Body)), nil
 }
 
 type revsDiffParser struct{}
@@ -1329,7 +1329,7 @@ func TestRowsQuery(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			rows, err := test.db.rowsQuery(context.Background(), test.path, test.options)
+			rows, err := test.db.rowsQuery(context.Background(), nil, test.path, test.options)
 			testy.StatusErrorRE(t, test.err, test.status, err)
 			result := queryResult{
 				Rows: []driver.Row{},
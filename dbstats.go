@@ -48,7 +48,9 @@ func (s *dbStats) driverStats() *driver.DBStats {
 	return stats
 }
 
-func (d *db) Stats(ctx context.Context) (*driver.DBStats, error) {
+func (d *db) Stats(ctx context.Context) (_ *driver.DBStats, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Stats", "")
+	defer endSpan(span, &err)
 	result := dbStats{}
 	if _, err := d.Client.DoJSON(ctx, http.MethodGet, d.dbName, nil, &result); err != nil {
 		return nil, err
@@ -66,7 +68,9 @@ type dbsInfoResponse struct {
 	Error  string  `json:"error"`
 }
 
-func (c *client) DBsStats(ctx context.Context, dbnames []string) ([]*driver.DBStats, error) {
+func (c *client) DBsStats(ctx context.Context, dbnames []string) (_ []*driver.DBStats, err error) {
+	ctx, span := c.startSpan(ctx, "client.DBsStats", "")
+	defer endSpan(span, &err)
 	opts := &chttp.Options{
 		GetBody: chttp.BodyEncoder(dbsInfoRequest{Keys: dbnames}),
 		Header: http.Header{
@@ -74,7 +78,7 @@ func (c *client) DBsStats(ctx context.Context, dbnames []string) ([]*driver.DBSt
 		},
 	}
 	result := []dbsInfoResponse{}
-	_, err := c.DoJSON(context.Background(), http.MethodPost, "/_dbs_info", opts, &result)
+	_, err = c.DoJSON(ctx, http.MethodPost, "/_dbs_info", opts, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -10,3 +10,28 @@ import (
 func missingArg(arg string) error {
 	return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: %s required", arg)}
 }
+
+// errorStatus returns the HTTP status CouchDB uses for the error name it
+// reports for a single document, such as in a _bulk_docs or _bulk_get
+// response.
+func errorStatus(name string) int {
+	switch name {
+	case "bad_request", "doc_validation", "illegal_docid", "invalid_rev":
+		return http.StatusBadRequest
+	case "unauthorized":
+		return http.StatusUnauthorized
+	case "forbidden":
+		return http.StatusForbidden
+	case "not_found":
+		return http.StatusNotFound
+	case "conflict":
+		return http.StatusConflict
+	case "file_exists", "precondition_failed", "missing_stub":
+		return http.StatusPreconditionFailed
+	case "too_large", "document_too_large", "attachment_too_large", "request_entity_too_large":
+		return http.StatusRequestEntityTooLarge
+	case "not_implemented":
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
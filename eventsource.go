@@ -0,0 +1,194 @@
+package couchdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultEventSourceRetry is the delay before reconnecting a dropped
+	// eventsource feed, unless the server specifies otherwise with a retry
+	// field.
+	defaultEventSourceRetry = 3 * time.Second
+
+	// eventSourceMaxRetries is the number of consecutive failed attempts to
+	// reconnect a dropped eventsource feed, before giving up.
+	eventSourceMaxRetries = 3
+
+	// headerLastEventID is sent when reconnecting an eventsource feed, to
+	// resume after the last event received.
+	headerLastEventID = "Last-Event-ID"
+)
+
+// reconnectFunc re-opens an eventsource stream, resuming after lastEventID.
+type reconnectFunc func(ctx context.Context, lastEventID string) (io.ReadCloser, error)
+
+// eventSourceReader reads a text/event-stream, and returns the data of each
+// message event followed by a newline, which is the format of a continuous
+// feed. Comments and heartbeat events are discarded. If the stream fails
+// with an error other than io.EOF, and reconnect is set, the stream is
+// re-opened, resuming from the last event ID.
+type eventSourceReader struct {
+	ctx       context.Context
+	reconnect reconnectFunc
+
+	mu     sync.Mutex
+	body   io.ReadCloser
+	closed bool
+
+	r           *bufio.Reader
+	lastEventID string
+	retry       time.Duration
+	pending     []byte
+
+	// The event being parsed
+	data      bytes.Buffer
+	eventType string
+}
+
+var _ io.ReadCloser = &eventSourceReader{}
+
+func newEventSourceReader(ctx context.Context, body io.ReadCloser, reconnect reconnectFunc) *eventSourceReader {
+	return &eventSourceReader{
+		ctx:       ctx,
+		reconnect: reconnect,
+		body:      body,
+		r:         bufio.NewReader(body),
+		retry:     defaultEventSourceRetry,
+	}
+}
+
+func (r *eventSourceReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		data, err := r.nextEvent()
+		if err != nil {
+			return 0, err
+		}
+		r.pending = append(data, '\n')
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// nextEvent returns the data of the next message event.
+func (r *eventSourceReader) nextEvent() ([]byte, error) {
+	failures := 0
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" {
+				return nil, io.EOF
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if failures >= eventSourceMaxRetries || !r.reopen() {
+				return nil, err
+			}
+			failures++
+			continue
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			if data, ok := r.dispatch(); ok {
+				return data, nil
+			}
+			continue
+		}
+		r.parseField(line)
+	}
+}
+
+// parseField processes a single non-blank line of the stream.
+func (r *eventSourceReader) parseField(line string) {
+	if strings.HasPrefix(line, ":") {
+		// Comment
+		return
+	}
+	parts := strings.SplitN(line, ":", 2)
+	field, value := parts[0], ""
+	if len(parts) == 2 {
+		value = strings.TrimPrefix(parts[1], " ")
+	}
+	switch field {
+	case "data":
+		r.data.WriteString(value)
+		r.data.WriteByte('\n')
+	case "event":
+		r.eventType = value
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			r.lastEventID = value
+		}
+	case "retry":
+		if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+			r.retry = time.Duration(ms) * time.Millisecond
+		}
+	}
+}
+
+// dispatch completes the current event, and returns its data if it is a
+// message event.
+func (r *eventSourceReader) dispatch() ([]byte, bool) {
+	eventType := r.eventType
+	data := bytes.TrimSuffix(r.data.Bytes(), []byte("\n"))
+	r.eventType = ""
+	defer r.data.Reset()
+	if r.data.Len() == 0 || (eventType != "" && eventType != "message") {
+		return nil, false
+	}
+	return append([]byte(nil), data...), true
+}
+
+// reopen attempts to reconnect after the stream failed. It returns false if
+// the stream should not be reconnected.
+func (r *eventSourceReader) reopen() bool {
+	if r.reconnect == nil || r.ctx.Err() != nil {
+		return false
+	}
+	r.mu.Lock()
+	closed := r.closed
+	r.mu.Unlock()
+	if closed {
+		return false
+	}
+	timer := time.NewTimer(r.retry)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.ctx.Done():
+		return false
+	}
+	body, err := r.reconnect(r.ctx, r.lastEventID)
+	if err != nil {
+		// Allow another attempt, after the retry delay
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		_ = body.Close()
+		return false
+	}
+	_ = r.body.Close()
+	r.body = body
+	r.r = bufio.NewReader(body)
+	// A partially received event is discarded.
+	r.data.Reset()
+	r.eventType = ""
+	return true
+}
+
+func (r *eventSourceReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return r.body.Close()
+}
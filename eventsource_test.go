@@ -0,0 +1,194 @@
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+func TestEventSourceReader(t *testing.T) {
+	type tt struct {
+		input    string
+		expected string
+		err      string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("empty", tt{
+		input:    "",
+		expected: "",
+	})
+	tests.Add("single event", tt{
+		input:    "data: {\"seq\":\"1-x\"}\nid: 1-x\n\n",
+		expected: "{\"seq\":\"1-x\"}\n",
+	})
+	tests.Add("CRLF line endings", tt{
+		input:    "data: {\"a\":1}\r\n\r\ndata: {\"b\":2}\r\n\r\n",
+		expected: "{\"a\":1}\n{\"b\":2}\n",
+	})
+	tests.Add("multi-line data", tt{
+		input:    "data: {\"a\":\ndata: 1}\n\n",
+		expected: "{\"a\":\n1}\n",
+	})
+	tests.Add("no space after colon", tt{
+		input:    "data:{\"a\":1}\n\n",
+		expected: "{\"a\":1}\n",
+	})
+	tests.Add("comments and heartbeats", tt{
+		input:    ": comment\n\nevent: heartbeat\ndata: \n\ndata: {\"a\":1}\nid: 1\n\n",
+		expected: "{\"a\":1}\n",
+	})
+	tests.Add("explicit message event", tt{
+		input:    "event: message\ndata: {\"a\":1}\n\n",
+		expected: "{\"a\":1}\n",
+	})
+	tests.Add("unknown fields", tt{
+		input:    "foo: bar\nretry: x\ndata: {\"a\":1}\n\n",
+		expected: "{\"a\":1}\n",
+	})
+	tests.Add("incomplete event", tt{
+		input:    "data: {\"a\":1}\n\ndata: {\"b\":2}\n",
+		expected: "{\"a\":1}\n",
+	})
+	tests.Add("truncated line", tt{
+		input:    "data: {\"a\":1}\n\ndata: {\"b",
+		expected: "{\"a\":1}\n",
+		err:      "unexpected EOF",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		r := newEventSourceReader(context.Background(), ioutil.NopCloser(strings.NewReader(tt.input)), nil)
+		defer r.Close() // nolint: errcheck
+		result, err := ioutil.ReadAll(r)
+		testy.Error(t, tt.err, err)
+		if d := testy.DiffText(tt.expected, string(result)); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestEventSourceReaderReconnect(t *testing.T) {
+	first := "retry: 1\ndata: {\"seq\":\"1\"}\nid: 1\n\ndata: {\"seq\":\"2\"}\nid: 2\n\ndata: {\"seq\""
+	var lastEventIDs []string
+	reconnect := func(_ context.Context, lastEventID string) (io.ReadCloser, error) {
+		lastEventIDs = append(lastEventIDs, lastEventID)
+		switch len(lastEventIDs) {
+		case 1:
+			return nil, errors.New("connection refused")
+		case 2:
+			return ioutil.NopCloser(strings.NewReader("data: {\"seq\":\"3\"}\nid: 3\n\n")), nil
+		}
+		t.Fatal("Unexpected reconnection")
+		return nil, nil
+	}
+	body := ioutil.NopCloser(io.MultiReader(strings.NewReader(first), testy.ErrorReader("", errors.New("connection reset"))))
+	r := newEventSourceReader(context.Background(), body, reconnect)
+	defer r.Close() // nolint: errcheck
+	result, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "{\"seq\":\"1\"}\n{\"seq\":\"2\"}\n{\"seq\":\"3\"}\n"
+	if d := testy.DiffText(expected, string(result)); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffInterface([]string{"2", "2"}, lastEventIDs); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestEventSourceReaderGiveUp(t *testing.T) {
+	attempts := 0
+	reconnect := func(context.Context, string) (io.ReadCloser, error) {
+		attempts++
+		return ioutil.NopCloser(testy.ErrorReader("", errors.New("connection reset"))), nil
+	}
+	body := ioutil.NopCloser(strings.NewReader("retry: 0\ndata: {\"a\":1}\n\ndata: {\"b"))
+	r := newEventSourceReader(context.Background(), body, reconnect)
+	defer r.Close() // nolint: errcheck
+	_, err := ioutil.ReadAll(r)
+	testy.Error(t, "connection reset", err)
+	if attempts != eventSourceMaxRetries {
+		t.Errorf("Unexpected reconnection attempts: %d", attempts)
+	}
+}
+
+func TestEventSourceReaderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reconnect := func(context.Context, string) (io.ReadCloser, error) {
+		t.Fatal("Unexpected reconnection")
+		return nil, nil
+	}
+	body := ioutil.NopCloser(strings.NewReader("data: {\"a\":1}\n\ndata: {\"b"))
+	r := newEventSourceReader(ctx, body, reconnect)
+	defer r.Close() // nolint: errcheck
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := ioutil.ReadAll(r)
+	testy.Error(t, "unexpected EOF", err)
+}
+
+func TestChangesEventSource(t *testing.T) {
+	var requests []*http.Request
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req)
+		var body string
+		if len(requests) == 1 {
+			body = "retry: 0\n" +
+				`data: {"seq":"1-x","id":"foo","changes":[{"rev":"1-a"}]}` + "\nid: 1-x\n\n" +
+				"event: heartbeat\ndata: \n\n" +
+				`data: {"seq":"2-`
+		} else {
+			body = `data: {"seq":"2-x","id":"bar","changes":[{"rev":"1-b"}],"deleted":true}` + "\nid: 2-x\n\n"
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/event-stream"}},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+	changes, err := db.Changes(context.Background(), map[string]interface{}{"feed": "eventsource", "heartbeat": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	var results []driver.Change
+	for {
+		row := new(driver.Change)
+		if err := changes.Next(row); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		row.Doc = nil
+		results = append(results, *row)
+	}
+	expected := []driver.Change{
+		{ID: "foo", Seq: "1-x", Changes: []string{"1-a"}},
+		{ID: "bar", Seq: "2-x", Changes: []string{"1-b"}, Deleted: true},
+	}
+	if d := testy.DiffInterface(expected, results); d != nil {
+		t.Error(d)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("Unexpected number of requests: %d", len(requests))
+	}
+	if id := requests[1].Header.Get("Last-Event-ID"); id != "1-x" {
+		t.Errorf("Unexpected Last-Event-ID: %s", id)
+	}
+	if q := requests[1].URL.RawQuery; q != "feed=eventsource&heartbeat=10" {
+		t.Errorf("Unexpected query: %s", q)
+	}
+}
@@ -0,0 +1,363 @@
+// Package faultinject provides an http.RoundTripper which injects network and
+// server failures into requests, to test how code which uses the CouchDB
+// driver behaves when requests fail, or when responses break partway through.
+//
+// Example:
+//
+//     faults := &faultinject.Transport{
+//         Faults: []*faultinject.Fault{
+//             {
+//                 Kind:        faultinject.Status,
+//                 Status:      http.StatusServiceUnavailable,
+//                 Path:        regexp.MustCompile(`/_bulk_docs$`),
+//                 Probability: 0.1,
+//                 Burst:       3,
+//             },
+//             {
+//                 Kind:  faultinject.DropFeed,
+//                 Path:  regexp.MustCompile(`/_changes$`),
+//                 Delay: 5 * time.Second,
+//             },
+//         },
+//     }
+//     client, err := kivik.New("couch", "http://localhost:5984/")
+//     if err != nil {
+//         t.Fatal(err)
+//     }
+//     if err := client.Authenticate(ctx, couchdb.SetTransport(faults)); err != nil {
+//         t.Fatal(err)
+//     }
+package faultinject
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Kind is a kind of fault.
+type Kind int
+
+const (
+	// ConnRefused fails the request as if the connection was refused, without
+	// contacting the server.
+	ConnRefused Kind = iota
+
+	// Timeout fails the request with a timeout error after Delay, without
+	// contacting the server.
+	Timeout
+
+	// Status responds with the HTTP status Status, and a CouchDB-style error
+	// body, without contacting the server.
+	Status
+
+	// SlowBody delivers the response body ChunkSize bytes at a time, with
+	// Delay before each chunk.
+	SlowBody
+
+	// TruncatedBody ends the response body prematurely, after After bytes,
+	// with io.ErrUnexpectedEOF.
+	TruncatedBody
+
+	// DropFeed fails the response body with a connection reset error, after
+	// After bytes, or once Delay has elapsed, whichever comes first. It is
+	// intended to simulate a dropped continuous feed.
+	DropFeed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case ConnRefused:
+		return "connection refused"
+	case Timeout:
+		return "timeout"
+	case Status:
+		return "status"
+	case SlowBody:
+		return "slow body"
+	case TruncatedBody:
+		return "truncated body"
+	case DropFeed:
+		return "dropped feed"
+	}
+	return "unknown"
+}
+
+// Fault describes a failure, and the requests it affects. A Fault must not be
+// copied after first use.
+type Fault struct {
+	Kind Kind
+
+	// Method, if set, restricts the fault to requests with this method.
+	Method string
+
+	// Path, if set, restricts the fault to requests whose URL path matches.
+	Path *regexp.Regexp
+
+	// Probability is the chance, between 0 and 1, that a matching request is
+	// affected. Zero means every matching request is affected.
+	Probability float64
+
+	// Burst is the number of consecutive matching requests affected each
+	// time the fault is triggered, such as for a burst of 503 responses.
+	// Values below 2 affect only the triggering request.
+	Burst int
+
+	// Limit is the maximum number of requests affected. Zero means no limit.
+	Limit int
+
+	// Status is the HTTP status returned by Status faults. Defaults to 500.
+	Status int
+
+	// Delay is the time before a Timeout fault fails, the delay before each
+	// chunk of a SlowBody, or the time after which a DropFeed fault drops the
+	// connection.
+	Delay time.Duration
+
+	// After is the number of response body bytes delivered before a
+	// TruncatedBody or DropFeed fault takes effect.
+	After int64
+
+	// ChunkSize is the number of bytes delivered at a time by a SlowBody
+	// fault. Defaults to 1.
+	ChunkSize int
+
+	mu       sync.Mutex
+	injected int
+	burst    int
+}
+
+// Injected returns the number of requests affected by f so far.
+func (f *Fault) Injected() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.injected
+}
+
+func (f *Fault) matches(req *http.Request) bool {
+	if f.Method != "" && f.Method != req.Method {
+		return false
+	}
+	return f.Path == nil || f.Path.MatchString(req.URL.Path)
+}
+
+// trigger reports whether f should be injected into a matching request.
+func (f *Fault) trigger(random func() float64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Limit > 0 && f.injected >= f.Limit {
+		return false
+	}
+	switch {
+	case f.burst > 0:
+		f.burst--
+	case f.Probability > 0 && random() >= f.Probability:
+		return false
+	case f.Burst > 1:
+		f.burst = f.Burst - 1
+	}
+	f.injected++
+	return true
+}
+
+// Transport is an http.RoundTripper which injects faults into requests sent
+// by an underlying transport. Faults are considered in order, and at most one
+// fault is injected into each request. It is safe for concurrent use.
+type Transport struct {
+	// Transport sends requests which are not failed outright. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Faults are the faults to inject.
+	Faults []*Fault
+
+	// Rand returns a pseudo-random number in [0.0,1.0), used to select
+	// requests to fail with probabilistic faults. Defaults to rand.Float64.
+	Rand func() float64
+}
+
+var _ http.RoundTripper = &Transport{}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f := t.fault(req)
+	if f == nil {
+		return t.transport().RoundTrip(req)
+	}
+	switch f.Kind {
+	case ConnRefused, Timeout, Status:
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+	}
+	switch f.Kind {
+	case ConnRefused:
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	case Timeout:
+		return nil, wait(req.Context(), f.Delay, timeoutError{})
+	case Status:
+		return statusResponse(req, f.Status), nil
+	}
+	res, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = newFaultyBody(req.Context(), res.Body, f)
+	return res, nil
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) fault(req *http.Request) *Fault {
+	random := t.Rand
+	if random == nil {
+		random = rand.Float64
+	}
+	for _, f := range t.Faults {
+		if f.matches(req) && f.trigger(random) {
+			return f
+		}
+	}
+	return nil
+}
+
+// wait returns err after delay, or the context's error if it is cancelled
+// first.
+func wait(ctx context.Context, delay time.Duration, err error) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// timeoutError is a net.Error which reports a timeout.
+type timeoutError struct{}
+
+var _ net.Error = timeoutError{}
+
+func (timeoutError) Error() string   { return "faultinject: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func statusResponse(req *http.Request, status int) *http.Response {
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	errName := "unknown_error"
+	if status != http.StatusInternalServerError {
+		errName = strings.Replace(strings.ToLower(http.StatusText(status)), " ", "_", -1)
+	}
+	body := fmt.Sprintf(`{"error":%q,"reason":"Injected fault"}`+"\n", errName)
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+		},
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		Request:       req,
+	}
+}
+
+// errConnReset is returned when a DropFeed fault drops the connection.
+var errConnReset = &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+
+// faultyBody injects a SlowBody, TruncatedBody or DropFeed fault into a
+// response body.
+type faultyBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	fault *Fault
+	// limit is the number of bytes to deliver before failing with err, or -1
+	// for no limit.
+	limit   int64
+	err     error
+	read    int64
+	dropped int32
+	timer   *time.Timer
+}
+
+func newFaultyBody(ctx context.Context, body io.ReadCloser, f *Fault) *faultyBody {
+	b := &faultyBody{ReadCloser: body, ctx: ctx, fault: f, limit: -1}
+	switch f.Kind {
+	case TruncatedBody:
+		b.limit, b.err = f.After, io.ErrUnexpectedEOF
+	case DropFeed:
+		b.err = errConnReset
+		if f.After > 0 || f.Delay <= 0 {
+			b.limit = f.After
+		}
+		if f.Delay > 0 {
+			b.timer = time.AfterFunc(f.Delay, func() {
+				atomic.StoreInt32(&b.dropped, 1)
+				// Unblock any pending read.
+				_ = body.Close()
+			})
+		}
+	}
+	return b
+}
+
+func (b *faultyBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&b.dropped) == 1 {
+		return 0, b.err
+	}
+	if b.limit >= 0 {
+		remaining := b.limit - b.read
+		if remaining <= 0 {
+			return 0, b.err
+		}
+		if int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	if b.fault.Kind == SlowBody {
+		chunk := b.fault.ChunkSize
+		if chunk <= 0 {
+			chunk = 1
+		}
+		if len(p) > chunk {
+			p = p[:chunk]
+		}
+		if err := wait(b.ctx, b.fault.Delay, nil); err != nil {
+			return 0, err
+		}
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if atomic.LoadInt32(&b.dropped) == 1 {
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *faultyBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	return b.ReadCloser.Close()
+}
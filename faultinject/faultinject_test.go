@@ -0,0 +1,289 @@
+package faultinject_test
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/couchdb/v4"
+	"github.com/go-kivik/couchdb/v4/chttp"
+	"github.com/go-kivik/couchdb/v4/couchdbtest"
+	"github.com/go-kivik/couchdb/v4/faultinject"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// newDB returns a database on a fake server, with three documents, accessed
+// through faults.
+func newDB(t *testing.T, s *couchdbtest.Server, faults *faultinject.Transport) *kivik.DB {
+	t.Helper()
+	db := s.NewDB(t, "testdb", nil)
+	couchdbtest.PutDocs(t, db, "a", "b", "c")
+	if err := db.Client().Authenticate(context.Background(), couchdb.SetTransport(faults)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func exitStatus(err error) int {
+	if e, ok := err.(interface{ ExitStatus() int }); ok {
+		return e.ExitStatus()
+	}
+	return 0
+}
+
+func TestRequestFaults(t *testing.T) {
+	type tt struct {
+		fault  *faultinject.Fault
+		err    string
+		status int
+		exit   int
+	}
+
+	tests := testy.NewTable()
+	tests.Add("connection refused", tt{
+		fault:  &faultinject.Fault{Kind: faultinject.ConnRefused},
+		err:    `Get "?http://127.0.0.1:\d+/"?: dial tcp: connect: connection refused`,
+		status: http.StatusBadGateway,
+		exit:   chttp.ExitFailedToConnect,
+	})
+	tests.Add("timeout", tt{
+		fault:  &faultinject.Fault{Kind: faultinject.Timeout, Delay: time.Millisecond},
+		err:    `Get "?http://127.0.0.1:\d+/"?: faultinject: i/o timeout`,
+		status: http.StatusBadGateway,
+		exit:   chttp.ExitOperationTimeout,
+	})
+	tests.Add("service unavailable", tt{
+		fault:  &faultinject.Fault{Kind: faultinject.Status, Status: http.StatusServiceUnavailable},
+		err:    "Service Unavailable: Injected fault",
+		status: http.StatusServiceUnavailable,
+		exit:   chttp.ExitNotRetrieved,
+	})
+	tests.Add("default status", tt{
+		fault:  &faultinject.Fault{Kind: faultinject.Status},
+		err:    "Internal Server Error: Injected fault",
+		status: http.StatusInternalServerError,
+		exit:   chttp.ExitNotRetrieved,
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		s := couchdbtest.NewServer()
+		defer s.Close()
+		c, err := chttp.New(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Client.Transport = &faultinject.Transport{Faults: []*faultinject.Fault{tt.fault}}
+		_, err = c.DoError(context.Background(), http.MethodGet, "/", nil)
+		testy.StatusErrorRE(t, tt.err, tt.status, err)
+		if status := exitStatus(err); status != tt.exit {
+			t.Errorf("Unexpected exit status: %d", status)
+		}
+	})
+}
+
+func TestRetryAfterFaults(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	burst := &faultinject.Fault{Kind: faultinject.Status, Status: http.StatusServiceUnavailable, Burst: 2, Limit: 2}
+	refused := &faultinject.Fault{Kind: faultinject.ConnRefused, Method: http.MethodGet, Path: regexp.MustCompile(`^/_up$`), Limit: 1}
+	c, err := chttp.New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Client.Transport = &faultinject.Transport{Faults: []*faultinject.Fault{refused, burst}}
+	c.RetryPolicy = &chttp.RetryPolicy{MaxAttempts: 4, MinDelay: time.Millisecond}
+
+	res, err := c.DoError(context.Background(), http.MethodGet, "/_up", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if refused.Injected() != 1 || burst.Injected() != 2 {
+		t.Errorf("Unexpected injections: refused %d, burst %d", refused.Injected(), burst.Injected())
+	}
+}
+
+func TestProbability(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	values := []float64{0.9, 0.1, 0.5}
+	fault := &faultinject.Fault{Kind: faultinject.Status, Probability: 0.5}
+	c, err := chttp.New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Client.Transport = &faultinject.Transport{
+		Faults: []*faultinject.Fault{fault},
+		Rand: func() float64 {
+			v := values[0]
+			values = values[1:]
+			return v
+		},
+	}
+	var statuses []int
+	for i := 0; i < 3; i++ {
+		_, err := c.DoError(context.Background(), http.MethodGet, "/", nil)
+		statuses = append(statuses, kivik.StatusCode(err))
+	}
+	if d := testy.DiffInterface([]int{0, 500, 0}, statuses); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestTruncatedRows(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := newDB(t, s, &faultinject.Transport{Faults: []*faultinject.Fault{
+		{Kind: faultinject.TruncatedBody, Path: regexp.MustCompile(`/_all_docs$`), After: 100},
+	}})
+	rows, err := db.AllDocs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for rows.Next() {
+		ids = append(ids, rows.ID())
+	}
+	testy.Error(t, "unexpected EOF", rows.Err())
+	if d := testy.DiffInterface([]string{"a"}, ids); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestDroppedFeed(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := newDB(t, s, &faultinject.Transport{Faults: []*faultinject.Fault{
+		{Kind: faultinject.DropFeed, Path: regexp.MustCompile(`/_changes$`), Delay: 50 * time.Millisecond},
+	}})
+	changes, err := db.Changes(context.Background(), kivik.Options{"feed": "continuous", "heartbeat": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	var ids []string
+	for changes.Next() {
+		ids = append(ids, changes.ID())
+	}
+	testy.StatusErrorRE(t, "connection reset by peer", http.StatusBadGateway, changes.Err())
+	if d := testy.DiffInterface([]string{"a", "b", "c"}, ids); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestSlowBody(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	db := newDB(t, s, &faultinject.Transport{Faults: []*faultinject.Fault{
+		{Kind: faultinject.SlowBody, Path: regexp.MustCompile(`/a$`), ChunkSize: 4, Delay: time.Millisecond},
+	}})
+
+	var doc map[string]interface{}
+	if err := db.Get(context.Background(), "a").ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["id"] != "a" {
+		t.Errorf("Unexpected doc: %v", doc)
+	}
+
+	slow := couchdbtest.NewServer()
+	defer slow.Close()
+	db = newDB(t, slow, &faultinject.Transport{Faults: []*faultinject.Fault{
+		{Kind: faultinject.SlowBody, Path: regexp.MustCompile(`/a$`), Delay: time.Second},
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	err := db.Get(ctx, "a").ScanDoc(&doc)
+	if err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestReconnectDroppedFeed(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	drop := &faultinject.Fault{Kind: faultinject.DropFeed, Path: regexp.MustCompile(`/_changes$`), Delay: 50 * time.Millisecond, Limit: 1}
+	db := newDB(t, s, &faultinject.Transport{Faults: []*faultinject.Fault{drop}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := db.Changes(ctx, kivik.Options{
+		"feed":                  "continuous",
+		"heartbeat":             10,
+		couchdb.OptionReconnect: &couchdb.ReconnectPolicy{MinDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := db.Put(context.Background(), "d", map[string]string{"id": "d"}); err != nil {
+			t.Error(err)
+		}
+	}()
+	var ids []string
+	for len(ids) < 4 && changes.Next() {
+		ids = append(ids, changes.ID())
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b", "c", "d"}, ids); d != nil {
+		t.Error(d)
+	}
+	if drop.Injected() != 1 {
+		t.Errorf("Unexpected injections: %d", drop.Injected())
+	}
+}
+
+func TestReconnectDBUpdates(t *testing.T) {
+	s := couchdbtest.NewServer()
+	defer s.Close()
+	drop := &faultinject.Fault{Kind: faultinject.DropFeed, Path: regexp.MustCompile(`/_db_updates$`), Delay: 50 * time.Millisecond, Limit: 1}
+	client, err := kivik.New("couch", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Authenticate(context.Background(), couchdb.SetTransport(&faultinject.Transport{Faults: []*faultinject.Fault{drop}})); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateDB(context.Background(), "foo"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := client.DBUpdates(couchdb.WithDBUpdatesOptions(ctx, map[string]interface{}{
+		"feed":                  "continuous",
+		"since":                 "0",
+		"heartbeat":             10,
+		couchdb.OptionReconnect: &couchdb.ReconnectPolicy{MinDelay: time.Millisecond},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updates.Close() // nolint: errcheck
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := client.CreateDB(context.Background(), "bar"); err != nil {
+			t.Error(err)
+		}
+	}()
+	var names []string
+	for len(names) < 2 && updates.Next() {
+		names = append(names, updates.DBName())
+	}
+	if err := updates.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"foo", "bar"}, names); d != nil {
+		t.Error(d)
+	}
+	if drop.Injected() != 1 {
+		t.Errorf("Unexpected injections: %d", drop.Injected())
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/go-kivik/kivik/v4/driver"
 )
 
-func (d *db) CreateIndex(ctx context.Context, ddoc, name string, index interface{}) error {
+func (d *db) CreateIndex(ctx context.Context, ddoc, name string, index interface{}) (err error) {
+	ctx, span := d.startDBSpan(ctx, "db.CreateIndex", "")
+	defer endSpan(span, &err)
 	indexObj, err := deJSONify(index)
 	if err != nil {
 		return err
@@ -31,15 +33,19 @@ func (d *db) CreateIndex(ctx context.Context, ddoc, name string, index interface
 	return err
 }
 
-func (d *db) GetIndexes(ctx context.Context) ([]driver.Index, error) {
+func (d *db) GetIndexes(ctx context.Context) (_ []driver.Index, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.GetIndexes", "")
+	defer endSpan(span, &err)
 	var result struct {
 		Indexes []driver.Index `json:"indexes"`
 	}
-	_, err := d.Client.DoJSON(ctx, http.MethodGet, d.path("_index"), nil, &result)
+	_, err = d.Client.DoJSON(ctx, http.MethodGet, d.path("_index"), nil, &result)
 	return result.Indexes, err
 }
 
-func (d *db) DeleteIndex(ctx context.Context, ddoc, name string) error {
+func (d *db) DeleteIndex(ctx context.Context, ddoc, name string) (err error) {
+	ctx, span := d.startDBSpan(ctx, "db.DeleteIndex", "")
+	defer endSpan(span, &err)
 	if ddoc == "" {
 		return missingArg("ddoc")
 	}
@@ -47,11 +53,13 @@ func (d *db) DeleteIndex(ctx context.Context, ddoc, name string) error {
 		return missingArg("name")
 	}
 	path := fmt.Sprintf("_index/%s/json/%s", ddoc, name)
-	_, err := d.Client.DoError(ctx, http.MethodDelete, d.path(path), nil)
+	_, err = d.Client.DoError(ctx, http.MethodDelete, d.path(path), nil)
 	return err
 }
 
-func (d *db) Find(ctx context.Context, query interface{}) (driver.Rows, error) {
+func (d *db) Find(ctx context.Context, query interface{}) (_ driver.Rows, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Find", "")
+	defer endSpanOnError(span, &err)
 	opts := &chttp.Options{
 		GetBody: chttp.BodyEncoder(query),
 		Header: http.Header{
@@ -65,7 +73,7 @@ func (d *db) Find(ctx context.Context, query interface{}) (driver.Rows, error) {
 	if err = chttp.ResponseError(resp); err != nil {
 		return nil, err
 	}
-	return newFindRows(ctx, resp.Body), nil
+	return newFindRows(ctx, endSpanOnClose(span, resp.Body)), nil
 }
 
 type queryPlan struct {
@@ -95,7 +103,9 @@ func (f *fields) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (d *db) Explain(ctx context.Context, query interface{}) (*driver.QueryPlan, error) {
+func (d *db) Explain(ctx context.Context, query interface{}) (_ *driver.QueryPlan, err error) {
+	ctx, span := d.startDBSpan(ctx, "db.Explain", "")
+	defer endSpan(span, &err)
 	opts := &chttp.Options{
 		GetBody: chttp.BodyEncoder(query),
 		Header: http.Header{
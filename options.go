@@ -20,6 +20,29 @@ func fullCommit(opts map[string]interface{}) (bool, error) {
 	return fcBool, nil
 }
 
+// gzipRequest returns the values of chttp.Options.Gzip and
+// chttp.Options.GzipThreshold requested by the OptionGzipRequest option.
+func gzipRequest(opts map[string]interface{}) (gzip bool, threshold int, err error) {
+	gz, ok := opts[OptionGzipRequest]
+	if !ok {
+		return false, 0, nil
+	}
+	delete(opts, OptionGzipRequest)
+	switch t := gz.(type) {
+	case bool:
+		if !t {
+			return false, -1, nil
+		}
+		return true, 0, nil
+	case int:
+		if t <= 0 {
+			return false, -1, nil
+		}
+		return true, t, nil
+	}
+	return false, 0, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: option '%s' must be bool or int, not %T", OptionGzipRequest, gz)}
+}
+
 func ifNoneMatch(opts map[string]interface{}) (string, error) {
 	inm, ok := opts[OptionIfNoneMatch]
 	if !ok {
@@ -35,3 +58,33 @@ func ifNoneMatch(opts map[string]interface{}) (string, error) {
 	}
 	return inmString, nil
 }
+
+// reconnectPolicy returns the policy requested by the OptionReconnect option,
+// or nil if reconnection was not requested. Reconnection requires a
+// continuous feed.
+func reconnectPolicy(opts map[string]interface{}) (*ReconnectPolicy, error) {
+	rc, ok := opts[OptionReconnect]
+	if !ok {
+		return nil, nil
+	}
+	delete(opts, OptionReconnect)
+	var policy *ReconnectPolicy
+	switch t := rc.(type) {
+	case bool:
+		if !t {
+			return nil, nil
+		}
+		policy = &ReconnectPolicy{}
+	case *ReconnectPolicy:
+		if t == nil {
+			return nil, nil
+		}
+		policy = t
+	default:
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: option '%s' must be bool or *ReconnectPolicy, not %T", OptionReconnect, rc)}
+	}
+	if opts["feed"] != "continuous" {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: option '%s' requires a continuous feed", OptionReconnect)}
+	}
+	return policy, nil
+}
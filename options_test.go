@@ -94,3 +94,58 @@ func TestIfNoneMatch(t *testing.T) {
 		})
 	}
 }
+
+func TestGzipRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     map[string]interface{}
+		gzip      bool
+		threshold int
+		status    int
+		err       string
+	}{
+		{
+			name:  "none",
+			input: nil,
+		},
+		{
+			name:  "enabled",
+			input: map[string]interface{}{OptionGzipRequest: true},
+			gzip:  true,
+		},
+		{
+			name:      "disabled",
+			input:     map[string]interface{}{OptionGzipRequest: false},
+			threshold: -1,
+		},
+		{
+			name:      "threshold",
+			input:     map[string]interface{}{OptionGzipRequest: 4096},
+			gzip:      true,
+			threshold: 4096,
+		},
+		{
+			name:      "zero threshold",
+			input:     map[string]interface{}{OptionGzipRequest: 0},
+			threshold: -1,
+		},
+		{
+			name:   "invalid type",
+			input:  map[string]interface{}{OptionGzipRequest: "yes"},
+			status: http.StatusBadRequest,
+			err:    "kivik: option 'kivik:gzip-request' must be bool or int, not string",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gzip, threshold, err := gzipRequest(test.input)
+			testy.StatusError(t, test.err, test.status, err)
+			if gzip != test.gzip || threshold != test.threshold {
+				t.Errorf("Unexpected result: %t, %d", gzip, threshold)
+			}
+			if _, ok := test.input[OptionGzipRequest]; ok {
+				t.Errorf("%s still set in options", OptionGzipRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package couchdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+// Default values used by a ReconnectPolicy when the corresponding field is
+// unset.
+const (
+	DefaultReconnectAttempts  = 10
+	DefaultReconnectHeartbeat = 10 * time.Second
+)
+
+// ReconnectPolicy controls the recovery of a continuous feed, requested with
+// the OptionReconnect option, when its connection drops or stalls.
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of consecutive failed reconnection attempts
+	// after which the feed fails. An attempt only counts as successful once a
+	// row has been received. Defaults to DefaultReconnectAttempts.
+	MaxAttempts int
+
+	// MinDelay is the delay before the first reconnection attempt. Each
+	// consecutive failure doubles the delay, up to MaxDelay. A random jitter
+	// of up to half of the delay is subtracted from each wait. Defaults to
+	// chttp.DefaultMinDelay and chttp.DefaultMaxDelay respectively.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// HeartbeatTimeout is the time without receiving any data, including
+	// heartbeats, after which the connection is considered dead. Defaults to
+	// twice the feed's heartbeat interval. If the feed options do not request
+	// heartbeats, a heartbeat of DefaultReconnectHeartbeat is requested.
+	HeartbeatTimeout time.Duration
+}
+
+func (p *ReconnectPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return DefaultReconnectAttempts
+}
+
+// heartbeatTimeout ensures that query requests heartbeats, and returns the
+// time without data after which the connection is considered dead.
+func (p *ReconnectPolicy) heartbeatTimeout(query url.Values) time.Duration {
+	interval := DefaultReconnectHeartbeat
+	switch hb := query.Get("heartbeat"); hb {
+	case "", "false":
+		query.Set("heartbeat", strconv.FormatInt(int64(interval/time.Millisecond), 10))
+	case "true":
+		// CouchDB's default heartbeat interval
+		interval = 60 * time.Second
+	default:
+		if ms, err := strconv.ParseInt(hb, 10, 64); err == nil && ms > 0 {
+			interval = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if p.HeartbeatTimeout > 0 {
+		return p.HeartbeatTimeout
+	}
+	return 2 * interval
+}
+
+// errStalled is reported when a feed is abandoned because no heartbeat was
+// received in time.
+var errStalled = errors.New("kivik: no heartbeat received from feed")
+
+// resumeFunc re-opens a continuous feed, starting after the sequence since,
+// once rows rows have been delivered. An empty since means no row has been
+// received yet.
+type resumeFunc func(ctx context.Context, since string, rows int) (io.ReadCloser, error)
+
+// resumableFeed reads a continuous feed of line-delimited JSON objects. If
+// the connection drops before the final last_seq line is received, or stalls
+// for longer than the heartbeat timeout, the feed is re-opened after the
+// sequence ID of the last complete row, so that reading continues without
+// duplicates or gaps.
+type resumableFeed struct {
+	ctx     context.Context
+	policy  *ReconnectPolicy
+	resume  resumeFunc
+	timeout time.Duration
+	done    chan struct{}
+
+	mu      sync.Mutex
+	body    io.ReadCloser
+	closed  bool
+	stalled bool
+	timer   *time.Timer
+
+	r        *bufio.Reader
+	since    string
+	rows     int
+	finished bool
+	failures int
+	pending  []byte
+}
+
+var _ io.ReadCloser = &resumableFeed{}
+
+func newResumableFeed(ctx context.Context, body io.ReadCloser, policy *ReconnectPolicy, timeout time.Duration, resume resumeFunc) *resumableFeed {
+	f := &resumableFeed{
+		ctx:     ctx,
+		policy:  policy,
+		resume:  resume,
+		timeout: timeout,
+		done:    make(chan struct{}),
+		body:    body,
+		r:       bufio.NewReader(body),
+	}
+	if timeout > 0 {
+		f.timer = time.AfterFunc(timeout, f.stall)
+		f.timer.Stop()
+	}
+	return f
+}
+
+// stall drops the current connection, when the heartbeat timeout expires.
+func (f *resumableFeed) stall() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.stalled = true
+	_ = f.body.Close()
+}
+
+// readLine reads a line from the current connection. Only time spent waiting
+// on the connection counts towards the heartbeat timeout, so that a slow
+// consumer does not cause a healthy connection to be dropped.
+func (f *resumableFeed) readLine() ([]byte, error) {
+	if f.timer == nil {
+		return f.r.ReadBytes('\n')
+	}
+	f.timer.Reset(f.timeout)
+	defer f.timer.Stop()
+	return f.r.ReadBytes('\n')
+}
+
+func (f *resumableFeed) Read(p []byte) (int, error) {
+	for len(f.pending) == 0 {
+		line, err := f.nextLine()
+		if err != nil {
+			return 0, err
+		}
+		f.pending = line
+	}
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// nextLine returns the next non-blank line of the feed.
+func (f *resumableFeed) nextLine() ([]byte, error) {
+	for {
+		line, err := f.readLine()
+		if err == nil {
+			trimmed := bytes.TrimSpace(line)
+			if len(trimmed) == 0 {
+				// Heartbeat
+				continue
+			}
+			f.track(trimmed)
+			return line, nil
+		}
+		if err == io.EOF && len(line) == 0 && f.finished {
+			return nil, io.EOF
+		}
+		f.mu.Lock()
+		if f.stalled {
+			err = errStalled
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		f.mu.Unlock()
+		if err := f.reopen(err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// track records the sequence ID of a complete row, or the end of the feed.
+func (f *resumableFeed) track(line []byte) {
+	var row struct {
+		Seq     sequenceID  `json:"seq"`
+		LastSeq *sequenceID `json:"last_seq"`
+	}
+	if err := json.Unmarshal(line, &row); err != nil {
+		// Let the caller's parser report the error
+		return
+	}
+	if row.LastSeq != nil {
+		f.finished = true
+		return
+	}
+	if row.Seq != "" {
+		f.since = string(row.Seq)
+	}
+	f.rows++
+	f.failures = 0
+}
+
+// reopen reconnects the feed after it failed with cause. It returns the error
+// to report if the feed cannot be reconnected.
+func (f *resumableFeed) reopen(cause error) error {
+	for {
+		if f.failures++; f.failures > f.policy.maxAttempts() {
+			return cause
+		}
+		timer := time.NewTimer(chttp.Backoff(f.failures, f.policy.MinDelay, f.policy.MaxDelay))
+		select {
+		case <-timer.C:
+		case <-f.ctx.Done():
+			timer.Stop()
+			return f.ctx.Err()
+		case <-f.done:
+			timer.Stop()
+			return cause
+		}
+		body, err := f.resume(f.ctx, f.since, f.rows)
+		if err != nil {
+			cause = err
+			continue
+		}
+		f.mu.Lock()
+		if f.closed {
+			f.mu.Unlock()
+			_ = body.Close()
+			return cause
+		}
+		_ = f.body.Close()
+		f.body = body
+		f.stalled = false
+		f.mu.Unlock()
+		f.r = bufio.NewReader(body)
+		return nil
+	}
+}
+
+func (f *resumableFeed) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	close(f.done)
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	return f.body.Close()
+}
+
+// feedRequest is a request for a changes or _db_updates feed, retained so
+// that the feed can be re-opened.
+type feedRequest struct {
+	client  *chttp.Client
+	method  string
+	path    string
+	options *chttp.Options
+}
+
+// do sends the feed request with options, which replace the request's own.
+func (r *feedRequest) do(ctx context.Context, options *chttp.Options) (*http.Response, error) {
+	resp, err := r.client.DoReq(ctx, r.method, r.path, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := chttp.ResponseError(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// openFeed opens the feed. If policy is not nil, the body of the returned
+// response resumes the feed when its connection drops or stalls.
+func openFeed(ctx context.Context, r *feedRequest, policy *ReconnectPolicy) (*http.Response, error) {
+	query := r.options.Query
+	var timeout time.Duration
+	if policy != nil {
+		timeout = policy.heartbeatTimeout(query)
+		if err := resolveSinceNow(ctx, r.client, r.path, query); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := r.do(ctx, r.options)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		resp.Body = newResumableFeed(ctx, resp.Body, policy, timeout, func(ctx context.Context, since string, rows int) (io.ReadCloser, error) {
+			reopen := *r.options
+			reopen.Query = resumeQuery(query, since, rows)
+			resp, err := r.do(ctx, &reopen)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Body, nil
+		})
+	}
+	return resp, nil
+}
+
+// resolveSinceNow replaces since=now in query with the current sequence ID of
+// the feed at path, so that a feed which drops before its first row resumes
+// from where it started, rather than skipping the changes made while it was
+// disconnected.
+func resolveSinceNow(ctx context.Context, c *chttp.Client, path string, query url.Values) error {
+	if query.Get("since") != "now" {
+		return nil
+	}
+	var result struct {
+		LastSeq sequenceID `json:"last_seq"`
+	}
+	if _, err := c.DoJSON(ctx, http.MethodGet, path, &chttp.Options{Query: url.Values{"since": {"now"}}}, &result); err != nil {
+		return err
+	}
+	if result.LastSeq != "" {
+		query.Set("since", string(result.LastSeq))
+	}
+	return nil
+}
+
+// resumeQuery returns a copy of query, adjusted to resume a feed after since,
+// once rows rows have been delivered.
+func resumeQuery(query url.Values, since string, rows int) url.Values {
+	q := make(url.Values, len(query))
+	for k, v := range query {
+		q[k] = v
+	}
+	if since != "" {
+		q.Set("since", since)
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && rows > 0 {
+		q.Set("limit", strconv.Itoa(limit-rows))
+	}
+	return q
+}
@@ -0,0 +1,333 @@
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+func TestReconnectPolicyHeartbeatTimeout(t *testing.T) {
+	type tt struct {
+		policy    *ReconnectPolicy
+		heartbeat string
+		timeout   time.Duration
+		query     string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("no heartbeat", tt{
+		policy:  &ReconnectPolicy{},
+		timeout: 2 * DefaultReconnectHeartbeat,
+		query:   "heartbeat=10000",
+	})
+	tests.Add("default heartbeat", tt{
+		policy:    &ReconnectPolicy{},
+		heartbeat: "true",
+		timeout:   2 * time.Minute,
+		query:     "heartbeat=true",
+	})
+	tests.Add("heartbeat", tt{
+		policy:    &ReconnectPolicy{},
+		heartbeat: "500",
+		timeout:   time.Second,
+		query:     "heartbeat=500",
+	})
+	tests.Add("explicit timeout", tt{
+		policy:    &ReconnectPolicy{HeartbeatTimeout: 3 * time.Second},
+		heartbeat: "500",
+		timeout:   3 * time.Second,
+		query:     "heartbeat=500",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		query := url.Values{}
+		if tt.heartbeat != "" {
+			query.Set("heartbeat", tt.heartbeat)
+		}
+		timeout := tt.policy.heartbeatTimeout(query)
+		if timeout != tt.timeout {
+			t.Errorf("Unexpected timeout: %s", timeout)
+		}
+		if q := query.Encode(); q != tt.query {
+			t.Errorf("Unexpected query: %s", q)
+		}
+	})
+}
+
+func TestResumeQuery(t *testing.T) {
+	query := url.Values{"feed": {"continuous"}, "since": {"now"}, "limit": {"10"}}
+	if q := resumeQuery(query, "", 0).Encode(); q != "feed=continuous&limit=10&since=now" {
+		t.Errorf("Unexpected query: %s", q)
+	}
+	if q := resumeQuery(query, "3-x", 3).Encode(); q != "feed=continuous&limit=7&since=3-x" {
+		t.Errorf("Unexpected query: %s", q)
+	}
+	if query.Get("since") != "now" {
+		t.Error("Original query modified")
+	}
+}
+
+func TestChangesReconnectOptions(t *testing.T) {
+	db := newTestDB(nil, errors.New("net error"))
+	_, err := db.Changes(context.Background(), map[string]interface{}{OptionReconnect: true})
+	testy.StatusError(t, "kivik: option 'kivik:reconnect' requires a continuous feed", http.StatusBadRequest, err)
+	_, err = db.Changes(context.Background(), map[string]interface{}{"feed": "continuous", OptionReconnect: "yes"})
+	testy.StatusError(t, "kivik: option 'kivik:reconnect' must be bool or *ReconnectPolicy, not string", http.StatusBadRequest, err)
+	_, err = db.Changes(context.Background(), map[string]interface{}{"feed": "continuous", OptionReconnect: false})
+	testy.StatusErrorRE(t, `_changes\?feed=continuous"?: net error`, http.StatusBadGateway, err)
+}
+
+// readChanges reads changes until the end of the feed, returning the IDs read,
+// and the error which ended the feed, if not io.EOF.
+func readChanges(t *testing.T, changes driver.Changes) ([]string, error) {
+	t.Helper()
+	var ids []string
+	for {
+		row := new(driver.Change)
+		if err := changes.Next(row); err != nil {
+			if err == io.EOF {
+				return ids, nil
+			}
+			return ids, err
+		}
+		// The final last_seq line of a continuous feed is read as an empty row.
+		if row.ID != "" {
+			ids = append(ids, row.ID)
+		}
+	}
+}
+
+func TestChangesReconnect(t *testing.T) {
+	var queries []string
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		queries = append(queries, req.URL.RawQuery)
+		var body io.Reader
+		switch len(queries) {
+		case 1:
+			body = io.MultiReader(
+				strings.NewReader(`{"seq":"1-x","id":"a","changes":[{"rev":"1-a"}]}`+"\n\n"+
+					`{"seq":"2-x","id":"b","changes":[{"rev":"1-b"}]}`+"\n"+
+					`{"seq":"3-x","id":"c","cha`),
+				testy.ErrorReader("", errors.New("connection reset")),
+			)
+		case 2:
+			return nil, errors.New("connection refused")
+		case 3:
+			body = strings.NewReader(`{"seq":"3-x","id":"c","changes":[{"rev":"1-c"}]}` + "\n" +
+				`{"last_seq":"3-x","pending":0}` + "\n")
+		default:
+			t.Fatal("Unexpected request")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(body),
+		}, nil
+	})
+	changes, err := db.Changes(context.Background(), map[string]interface{}{
+		"feed":          "continuous",
+		OptionReconnect: &ReconnectPolicy{MinDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	ids, err := readChanges(t, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b", "c"}, ids); d != nil {
+		t.Error(d)
+	}
+	expected := []string{
+		"feed=continuous&heartbeat=10000",
+		"feed=continuous&heartbeat=10000&since=2-x",
+		"feed=continuous&heartbeat=10000&since=2-x",
+	}
+	if d := testy.DiffInterface(expected, queries); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestChangesReconnectStalled(t *testing.T) {
+	stalled, w := io.Pipe()
+	defer w.Close() // nolint: errcheck
+	go func() {
+		_, _ = w.Write([]byte(`{"seq":"1-x","id":"a","changes":[{"rev":"1-a"}]}` + "\n"))
+	}()
+	requests := 0
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		requests++
+		if requests == 1 {
+			return &http.Response{StatusCode: http.StatusOK, Body: stalled}, nil
+		}
+		if since := req.URL.Query().Get("since"); since != "1-x" {
+			t.Errorf("Unexpected since: %s", since)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       Body(`{"seq":"2-x","id":"b","changes":[{"rev":"1-b"}]}` + "\n" + `{"last_seq":"2-x","pending":0}`),
+		}, nil
+	})
+	changes, err := db.Changes(context.Background(), map[string]interface{}{
+		"feed":          "continuous",
+		"heartbeat":     5,
+		OptionReconnect: &ReconnectPolicy{MinDelay: time.Millisecond, HeartbeatTimeout: 20 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	ids, err := readChanges(t, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b"}, ids); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestChangesReconnectGiveUp(t *testing.T) {
+	requests := 0
+	db := newCustomDB(func(*http.Request) (*http.Response, error) {
+		requests++
+		if requests > 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"seq":"1-x","id":"a","changes":[{"rev":"1-a"}]}` + "\n")),
+		}, nil
+	})
+	changes, err := db.Changes(context.Background(), map[string]interface{}{
+		"feed":          "continuous",
+		OptionReconnect: &ReconnectPolicy{MaxAttempts: 2, MinDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	ids, err := readChanges(t, changes)
+	testy.StatusErrorRE(t, "connection refused", http.StatusBadGateway, err)
+	if d := testy.DiffInterface([]string{"a"}, ids); d != nil {
+		t.Error(d)
+	}
+	if requests != 3 {
+		t.Errorf("Unexpected number of requests: %d", requests)
+	}
+}
+
+func TestChangesReconnectSinceNow(t *testing.T) {
+	var queries []string
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		queries = append(queries, req.URL.RawQuery)
+		var body io.Reader
+		switch len(queries) {
+		case 1:
+			body = strings.NewReader(`{"results":[],"last_seq":"5-x","pending":0}`)
+		case 2:
+			// Dropped before the first row
+			body = testy.ErrorReader("", errors.New("connection reset"))
+		case 3:
+			body = strings.NewReader(`{"seq":"6-x","id":"a","changes":[{"rev":"1-a"}]}` + "\n" +
+				`{"last_seq":"6-x","pending":0}` + "\n")
+		default:
+			t.Fatal("Unexpected request")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(body),
+		}, nil
+	})
+	changes, err := db.Changes(context.Background(), map[string]interface{}{
+		"feed":          "continuous",
+		"since":         "now",
+		OptionReconnect: &ReconnectPolicy{MinDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	ids, err := readChanges(t, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a"}, ids); d != nil {
+		t.Error(d)
+	}
+	expected := []string{
+		"since=now",
+		"feed=continuous&heartbeat=10000&since=5-x",
+		"feed=continuous&heartbeat=10000&since=5-x",
+	}
+	if d := testy.DiffInterface(expected, queries); d != nil {
+		t.Error(d)
+	}
+}
+
+
+func TestDBUpdatesReconnect(t *testing.T) {
+	var queries []string
+	client := newCustomClient(func(req *http.Request) (*http.Response, error) {
+		queries = append(queries, req.URL.RawQuery)
+		var body io.Reader
+		switch len(queries) {
+		case 1:
+			body = io.MultiReader(
+				strings.NewReader(`{"db_name":"a","type":"created","seq":"6-x"}`+"\n"),
+				testy.ErrorReader("", errors.New("connection reset")),
+			)
+		case 2:
+			body = strings.NewReader(`{"db_name":"b","type":"created","seq":"7-x"}` + "\n" +
+				`{"last_seq":"7-x"}` + "\n")
+		default:
+			t.Fatal("Unexpected request")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(body),
+		}, nil
+	})
+	ctx := WithDBUpdatesOptions(context.Background(), map[string]interface{}{
+		"feed":          "continuous",
+		"since":         "5-x",
+		OptionReconnect: &ReconnectPolicy{MinDelay: time.Millisecond},
+	})
+	updates, err := client.DBUpdates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updates.Close() // nolint: errcheck
+	var names []string
+	for {
+		update := new(driver.DBUpdate)
+		if err := updates.Next(update); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		if update.DBName != "" {
+			names = append(names, update.DBName)
+		}
+	}
+	if d := testy.DiffInterface([]string{"a", "b"}, names); d != nil {
+		t.Error(d)
+	}
+	expected := []string{
+		"feed=continuous&heartbeat=10000&since=5-x",
+		"feed=continuous&heartbeat=10000&since=6-x",
+	}
+	if d := testy.DiffInterface(expected, queries); d != nil {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,424 @@
+// Package recorder provides an http.RoundTripper which records exchanges with
+// a CouchDB server to a fixture file, and replays them later, to test code
+// which uses the CouchDB driver without a running server.
+//
+// Example:
+//
+//     rec, err := recorder.New("testdata/TestFoo.json", recorder.ModeReplay)
+//     if err != nil {
+//         t.Fatal(err)
+//     }
+//     client, err := kivik.New("couch", "http://localhost:5984/")
+//     if err != nil {
+//         t.Fatal(err)
+//     }
+//     if err := client.Authenticate(ctx, couchdb.SetTransport(rec)); err != nil {
+//         t.Fatal(err)
+//     }
+//
+// To (re-)record the fixture, run the test against a live server with
+// ModeRecord, and call Save when the test completes.
+package recorder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+// Mode selects whether a Recorder records or replays.
+type Mode int
+
+const (
+	// ModeReplay serves responses from the fixture file. Requests which do not
+	// match a recorded request fail.
+	ModeReplay Mode = iota
+
+	// ModeRecord sends requests to the server, and records them.
+	ModeRecord
+)
+
+// Interaction is a single recorded exchange.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+
+	// redact lists the headers which carried credentials, in addition to
+	// those always redacted.
+	redact []string
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is a recorded request or response body. It is stored in the fixture
+// as a string when it is valid UTF-8, and base64-encoded otherwise.
+type Body []byte
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string][]byte{"base64": b})
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	var encoded struct {
+		Base64 []byte `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	*b = encoded.Base64
+	return nil
+}
+
+// Matcher reports whether req, whose body is body, matches the recorded
+// request rec.
+type Matcher func(req *http.Request, body []byte, rec Request) bool
+
+// MatchMethod matches the request method.
+func MatchMethod(req *http.Request, _ []byte, rec Request) bool {
+	return req.Method == rec.Method
+}
+
+// MatchPath matches the request path.
+func MatchPath(req *http.Request, _ []byte, rec Request) bool {
+	u, err := url.Parse(rec.URL)
+	return err == nil && u.EscapedPath() == req.URL.EscapedPath()
+}
+
+// MatchQuery matches the query parameters, in any order.
+func MatchQuery(req *http.Request, _ []byte, rec Request) bool {
+	u, err := url.Parse(rec.URL)
+	if err != nil {
+		return false
+	}
+	return equalValues(u.Query(), req.URL.Query())
+}
+
+// MatchBody matches the request body. JSON bodies match if they are
+// equivalent, regardless of formatting and key order.
+func MatchBody(_ *http.Request, body []byte, rec Request) bool {
+	if bytes.Equal(body, rec.Body) {
+		return true
+	}
+	var x, y interface{}
+	if json.Unmarshal(body, &x) != nil || json.Unmarshal(rec.Body, &y) != nil {
+		return false
+	}
+	a, _ := json.Marshal(x)
+	b, _ := json.Marshal(y)
+	return bytes.Equal(a, b)
+}
+
+// DefaultMatchers are used when Recorder.Matchers is nil.
+var DefaultMatchers = []Matcher{MatchMethod, MatchPath, MatchQuery, MatchBody}
+
+// Recorder is an http.RoundTripper which records or replays exchanges with a
+// CouchDB server. It is safe for concurrent use.
+type Recorder struct {
+	// Path is the fixture file.
+	Path string
+
+	// Mode selects whether to record or replay.
+	Mode Mode
+
+	// Transport is used to send requests in ModeRecord. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Matchers select the recorded interaction to replay for a request. A
+	// request matches if all matchers return true. Each interaction is
+	// replayed at most once, in the order recorded. Defaults to
+	// DefaultMatchers.
+	Matchers []Matcher
+
+	// Scrub, if set, is called for each interaction before it is saved, to
+	// remove sensitive data in addition to the credentials removed by
+	// default.
+	Scrub func(*Interaction)
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	replayed     []bool
+}
+
+var _ http.RoundTripper = &Recorder{}
+
+// New returns a new Recorder. In ModeReplay, the fixture at path is loaded.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{Path: path, Mode: mode}
+	if mode == ModeRecord {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, xerrors.Errorf("recorder: invalid fixture %s: %w", path, err)
+	}
+	r.replayed = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+// Interactions returns the recorded interactions.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]Interaction, len(r.interactions))
+	for i, in := range r.interactions {
+		result[i] = *in
+	}
+	return result
+}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.Mode == ModeRecord {
+		return r.record(req, body)
+	}
+	return r.replay(req, body)
+}
+
+// requestBody reads, and replaces, the body of req, decompressing it if
+// necessary.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if req.Header.Get("Content-Encoding") != "gzip" {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(zr)
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	matchers := r.Matchers
+	if matchers == nil {
+		matchers = DefaultMatchers
+	}
+	// Credentials are scrubbed from recorded bodies, so scrub them before
+	// matching too.
+	body = chttp.RedactBody(req.URL.Path, body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if r.replayed[i] || !matchAll(matchers, req, body, in.Request) {
+			continue
+		}
+		r.replayed[i] = true
+		header := cloneHeader(in.Response.Header)
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Content-Length", strconv.Itoa(len(in.Response.Body)))
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			ContentLength: int64(len(in.Response.Body)),
+			Body:          ioutil.NopCloser(bytes.NewReader(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("recorder: no recorded interaction matches %s %s", req.Method, req.URL.RequestURI())
+}
+
+func matchAll(matchers []Matcher, req *http.Request, body []byte, rec Request) bool {
+	for _, m := range matchers {
+		if !m(req, body, rec) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	u := *req.URL
+	u.User = nil
+	in := &Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    u.String(),
+			Header: cloneHeader(req.Header),
+			Body:   body,
+		},
+		Response: Response{
+			StatusCode: res.StatusCode,
+			Header:     cloneHeader(res.Header),
+		},
+		redact: chttp.RedactedHeaders(req.Context()),
+	}
+	r.mu.Lock()
+	r.interactions = append(r.interactions, in)
+	r.mu.Unlock()
+	// The response body is recorded as it is read by the caller, so that
+	// continuous feeds may be recorded until they are closed.
+	res.Body = &recordedBody{ReadCloser: res.Body, mu: &r.mu, dst: &in.Response.Body}
+	return res, nil
+}
+
+type recordedBody struct {
+	io.ReadCloser
+	mu  *sync.Mutex
+	dst *Body
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	*b.dst = append(*b.dst, p[:n]...)
+	b.mu.Unlock()
+	return n, err
+}
+
+// Save writes the recorded interactions to the fixture file, after scrubbing
+// credentials. Response bodies are recorded as far as they have been read.
+func (r *Recorder) Save() error {
+	if r.Mode != ModeRecord {
+		return errors.New("recorder: Save is only valid in ModeRecord")
+	}
+	r.mu.Lock()
+	interactions := make([]*Interaction, len(r.interactions))
+	for i, in := range r.interactions {
+		clone := *in
+		clone.Response.Body = append(Body(nil), in.Response.Body...)
+		interactions[i] = &clone
+	}
+	r.mu.Unlock()
+	for _, in := range interactions {
+		scrub(in)
+		if r.Scrub != nil {
+			r.Scrub(in)
+		}
+	}
+	data, err := json.MarshalIndent(interactions, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.Path, append(data, '\n'), 0644)
+}
+
+// ScrubbedSession replaces the value of session cookies in recorded
+// responses, so that replayed cookies are syntactically valid.
+const ScrubbedSession = "scrubbed"
+
+var setCookieRE = regexp.MustCompile(`^([^=;]+)=[^;]*`)
+
+// scrub removes credentials from in.
+func scrub(in *Interaction) {
+	in.Request.Header = chttp.RedactHeader(in.Request.Header, in.redact...)
+	in.Request.Body = chttp.RedactBody(requestPath(in.Request.URL), in.Request.Body)
+	cookies := in.Response.Header["Set-Cookie"]
+	in.Response.Header = chttp.RedactHeader(in.Response.Header, in.redact...)
+	if len(cookies) > 0 {
+		scrubbed := make([]string, len(cookies))
+		for i, c := range cookies {
+			scrubbed[i] = setCookieRE.ReplaceAllString(c, "${1}="+ScrubbedSession)
+		}
+		in.Response.Header["Set-Cookie"] = scrubbed
+	}
+}
+
+// cloneHeader returns a deep copy of h.
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	clone := make(http.Header, len(h))
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
+
+func requestPath(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+func equalValues(a, b url.Values) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		w, ok := b[k]
+		if !ok || len(v) != len(w) {
+			return false
+		}
+		for i := range v {
+			if v[i] != w[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
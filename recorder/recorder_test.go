@@ -0,0 +1,242 @@
+package recorder
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+type exchange struct {
+	method, path, body string
+}
+
+func do(t *testing.T, c *http.Client, base string, ex exchange) (int, string, error) {
+	t.Helper()
+	var body io.Reader
+	if ex.body != "" {
+		body = strings.NewReader(ex.body)
+	}
+	req, err := http.NewRequest(ex.method, base+ex.path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("admin", "abc123")
+	res, err := c.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close() // nolint: errcheck
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(data), nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	var gets int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_session":
+			http.SetCookie(w, &http.Cookie{Name: "AuthSession", Value: "c2VjcmV0", Path: "/"})
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		case r.Method == http.MethodPut:
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"ok":true,"rev":"1-abc"}`))
+		default:
+			gets++
+			if gets == 1 {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":"not_found"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_id":"doc","_rev":"1-abc"}`))
+		}
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "testdata", "TestRecordReplay.json")
+
+	exchanges := []exchange{
+		{method: http.MethodPost, path: "/_session", body: `{"name":"admin","password":"abc123"}`},
+		{method: http.MethodGet, path: "/db/doc?revs=true&conflicts=true"},
+		{method: http.MethodPut, path: "/db/doc", body: `{"foo":"bar","baz":1}`},
+		{method: http.MethodGet, path: "/db/doc?revs=true&conflicts=true"},
+	}
+
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		Status int
+		Body   string
+	}
+	var recorded []result
+	for _, ex := range exchanges {
+		status, body, err := do(t, &http.Client{Transport: rec}, s.URL, ex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, result{status, body})
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"abc123", "c2VjcmV0", "YWRtaW46YWJjMTIz"} {
+		if strings.Contains(string(fixture), secret) {
+			t.Errorf("Fixture contains secret %q", secret)
+		}
+	}
+	if !strings.Contains(string(fixture), "AuthSession="+ScrubbedSession) {
+		t.Error("Expected scrubbed session cookie in fixture")
+	}
+
+	rec, err = New(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Query parameters in a different order, and a reformatted body, match.
+	replayExchanges := []exchange{
+		exchanges[0],
+		{method: http.MethodGet, path: "/db/doc?conflicts=true&revs=true"},
+		{method: http.MethodPut, path: "/db/doc", body: `{"baz": 1, "foo": "bar"}`},
+		exchanges[3],
+	}
+	var replayed []result
+	for _, ex := range replayExchanges {
+		status, body, err := do(t, &http.Client{Transport: rec}, "http://other.example.com", ex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replayed = append(replayed, result{status, body})
+	}
+	if d := testy.DiffInterface(recorded, replayed); d != nil {
+		t.Error(d)
+	}
+
+	_, _, err = do(t, &http.Client{Transport: rec}, "http://other.example.com", exchanges[3])
+	testy.ErrorRE(t, `recorder: no recorded interaction matches GET /db/doc\?revs=true&conflicts=true`, err)
+}
+
+func TestRecordRenamedProxyHeader(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "TestRecordRenamedProxyHeader.json")
+
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := chttp.WithProxyAuth(context.Background(), &chttp.ProxyAuth{
+		Username: "bob",
+		Headers:  http.Header{"X-Auth-Couchdb-Token": {"X-Proxy-Token"}},
+	})
+	req, err := http.NewRequest(http.MethodGet, s.URL+"/db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Proxy-Token", "s3cr3t")
+	res, err := (&http.Client{Transport: rec}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(fixture), "s3cr3t") {
+		t.Error("Fixture contains proxy token")
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/db/_find?limit=1", nil)
+	tests := []struct {
+		name     string
+		matcher  Matcher
+		body     string
+		rec      Request
+		expected bool
+	}{
+		{"method", MatchMethod, "", Request{Method: http.MethodPost}, true},
+		{"wrong method", MatchMethod, "", Request{Method: http.MethodGet}, false},
+		{"path", MatchPath, "", Request{URL: "http://example.com/db/_find"}, true},
+		{"wrong path", MatchPath, "", Request{URL: "http://example.com/db/_all_docs"}, false},
+		{"query", MatchQuery, "", Request{URL: "http://example.com/db/_find?limit=1"}, true},
+		{"wrong query", MatchQuery, "", Request{URL: "http://example.com/db/_find?limit=2"}, false},
+		{"identical body", MatchBody, "foo", Request{Body: Body("foo")}, true},
+		{"equivalent JSON", MatchBody, `{"a":1,"b":[2]}`, Request{Body: Body(`{"b": [2], "a": 1}`)}, true},
+		{"different body", MatchBody, `{"a":1}`, Request{Body: Body(`{"a":2}`)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.matcher(req, []byte(test.body), test.rec); result != test.expected {
+				t.Errorf("Expected %t", test.expected)
+			}
+		})
+	}
+}
+
+func TestBodyJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body Body
+		json string
+	}{
+		{"text", Body(`{"ok":true}`), `"{\"ok\":true}"`},
+		{"binary", Body{0xff, 0x00}, `{"base64":"/wA="}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := test.body.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != test.json {
+				t.Errorf("Unexpected JSON: %s", data)
+			}
+			var result Body
+			if err := result.UnmarshalJSON(data); err != nil {
+				t.Fatal(err)
+			}
+			if d := testy.DiffInterface(test.body, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
@@ -237,7 +237,9 @@ type replicatorDoc struct {
 	Error         *replicationError    `json:"_replication_state_reason,omitempty"`
 }
 
-func (c *client) GetReplications(ctx context.Context, options map[string]interface{}) ([]driver.Replication, error) {
+func (c *client) GetReplications(ctx context.Context, options map[string]interface{}) (_ []driver.Replication, err error) {
+	ctx, span := c.startSpan(ctx, "client.GetReplications", "")
+	defer endSpan(span, &err)
 	scheduler, err := c.schedulerSupported(ctx)
 	if err != nil {
 		return nil, err
@@ -280,7 +282,9 @@ func (c *client) legacyGetReplications(ctx context.Context, options map[string]i
 	return reps, nil
 }
 
-func (c *client) Replicate(ctx context.Context, targetDSN, sourceDSN string, options map[string]interface{}) (driver.Replication, error) {
+func (c *client) Replicate(ctx context.Context, targetDSN, sourceDSN string, options map[string]interface{}) (_ driver.Replication, err error) {
+	ctx, span := c.startSpan(ctx, "client.Replicate", "")
+	defer endSpan(span, &err)
 	if options == nil {
 		options = make(map[string]interface{})
 	}
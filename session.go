@@ -36,9 +36,11 @@ func (s *session) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (c *client) Session(ctx context.Context) (*driver.Session, error) {
+func (c *client) Session(ctx context.Context) (_ *driver.Session, err error) {
+	ctx, span := c.startSpan(ctx, "client.Session", "")
+	defer endSpan(span, &err)
 	s := &session{}
-	_, err := c.DoJSON(ctx, http.MethodGet, "/_session", nil, s)
+	_, err = c.DoJSON(ctx, http.MethodGet, "/_session", nil, s)
 	return &driver.Session{
 		RawResponse:            s.Data,
 		Name:                   s.UserCtx.Name,
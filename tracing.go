@@ -0,0 +1,87 @@
+package couchdb
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+// startSpan starts a span for the client operation op, if the client has a
+// Tracer. dbName is the database the operation acts on, if any.
+func (c *client) startSpan(ctx context.Context, op, dbName string) (context.Context, chttp.Span) {
+	if c == nil || c.Client == nil {
+		return ctx, nil
+	}
+	return c.StartSpan(ctx, op, dbName, "")
+}
+
+// startDBSpan starts a span for the database operation op, if the client has
+// a Tracer. docID is the document the operation acts on, if any.
+func (d *db) startDBSpan(ctx context.Context, op, docID string) (context.Context, chttp.Span) {
+	if d == nil || d.client == nil || d.Client == nil {
+		return ctx, nil
+	}
+	return d.StartSpan(ctx, op, d.dbName, docID)
+}
+
+// endSpan ends span, if it is not nil, with the error pointed to by err. It is
+// intended to be deferred.
+func endSpan(span chttp.Span, err *error) {
+	if span != nil {
+		span.End(*err)
+	}
+}
+
+// endSpanOnError ends span, if it is not nil, when the error pointed to by err
+// is not nil. It is intended to be deferred by operations which return a
+// streamed result, whose span is ended by endSpanOnClose when the operation
+// succeeds.
+func endSpanOnError(span chttp.Span, err *error) {
+	if *err != nil {
+		endSpan(span, err)
+	}
+}
+
+// endSpanOnClose returns body, wrapped so that span, if it is not nil, is
+// ended when body is closed, with the first error other than io.EOF returned
+// while reading it.
+func endSpanOnClose(span chttp.Span, body io.ReadCloser) io.ReadCloser {
+	if span == nil {
+		return body
+	}
+	return &spanBody{ReadCloser: body, span: span}
+}
+
+type spanBody struct {
+	io.ReadCloser
+	span chttp.Span
+
+	mu    sync.Mutex
+	err   error
+	ended bool
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
+		}
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.ended {
+		b.ended = true
+		b.span.End(b.err)
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package couchdb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+var testSpanContextKey = &struct{ name string }{"test span"}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name   string
+	parent *testSpan
+	attrs  map[string]interface{}
+	err    error
+	ends   int
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string) (context.Context, chttp.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent, _ := ctx.Value(testSpanContextKey).(*testSpan)
+	span := &testSpan{name: name, parent: parent, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, testSpanContextKey, span), span
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) TraceParent() string                        { return "" }
+func (s *testSpan) End(err error)                              { s.err, s.ends = err, s.ends+1 }
+
+func TestTracing(t *testing.T) {
+	tracer := &testTracer{}
+	db := newCustomDB(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Header: http.Header{
+				"Content-Type":       {"application/json"},
+				"X-Couch-Request-ID": {"aa1f852b27"},
+			},
+			Body:    Body(""),
+			Request: r,
+		}, nil
+	})
+	db.Tracer = tracer
+	_, err := db.Get(context.Background(), "foo", nil)
+	testy.StatusError(t, "Not Found", http.StatusNotFound, err)
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(tracer.spans))
+	}
+	op, req := tracer.spans[0], tracer.spans[1]
+	if op.name != "db.Get" || op.err != err {
+		t.Errorf("Unexpected operation span: %s, %v", op.name, op.err)
+	}
+	if d := testy.DiffInterface(map[string]interface{}{
+		chttp.AttrDBSystem:    "couchdb",
+		chttp.AttrDBOperation: "db.Get",
+		chttp.AttrDBName:      "testdb",
+		chttp.AttrDocID:       "foo",
+	}, op.attrs); d != nil {
+		t.Error(d)
+	}
+	if req.parent != op {
+		t.Error("Request span is not a child of the operation span")
+	}
+	if req.attrs[chttp.AttrHTTPStatusCode] != http.StatusNotFound || req.attrs[chttp.AttrRequestID] != "aa1f852b27" {
+		t.Errorf("Unexpected request span attributes: %v", req.attrs)
+	}
+}
+
+func TestTracingStreamed(t *testing.T) {
+	type tt struct {
+		status int
+		body   string
+		op     func(*db) (io.Closer, error)
+	}
+
+	tests := testy.NewTable()
+	tests.Add("AllDocs", tt{
+		status: http.StatusOK,
+		body:   `{"total_rows":0,"offset":0,"rows":[]}`,
+		op: func(d *db) (io.Closer, error) {
+			return d.AllDocs(context.Background(), nil)
+		},
+	})
+	tests.Add("Changes", tt{
+		status: http.StatusOK,
+		body:   `{"results":[],"last_seq":"1-x"}`,
+		op: func(d *db) (io.Closer, error) {
+			return d.Changes(context.Background(), nil)
+		},
+	})
+	tests.Add("BulkDocs", tt{
+		status: http.StatusCreated,
+		body:   `[]`,
+		op: func(d *db) (io.Closer, error) {
+			return d.BulkDocs(context.Background(), []interface{}{}, nil)
+		},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		tracer := &testTracer{}
+		db := newCustomDB(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       Body(tt.body),
+				Request:    r,
+			}, nil
+		})
+		db.Tracer = tracer
+		rows, err := tt.op(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		op := tracer.spans[0]
+		if op.ends != 0 {
+			t.Fatalf("Span ended before the result was closed")
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if op.ends != 1 || op.err != nil {
+			t.Errorf("Unexpected span end: %d times, err: %v", op.ends, op.err)
+		}
+	})
+}
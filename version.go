@@ -9,9 +9,11 @@ import (
 )
 
 // Version returns the server's version info.
-func (c *client) Version(ctx context.Context) (*driver.Version, error) {
+func (c *client) Version(ctx context.Context) (_ *driver.Version, err error) {
+	ctx, span := c.startSpan(ctx, "client.Version", "")
+	defer endSpan(span, &err)
 	i := &info{}
-	_, err := c.DoJSON(ctx, http.MethodGet, "/", nil, i)
+	_, err = c.DoJSON(ctx, http.MethodGet, "/", nil, i)
 	return &driver.Version{
 		Version:     i.Version,
 		Vendor:      i.Vendor.Name,